@@ -0,0 +1,154 @@
+//go:build unit
+
+package api_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/squarefactory/miner-api/api"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/mocks"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+const user = "miner"
+
+// squeueTasks are the arguments listing the tasks of the jobs of the user.
+func squeueTasks(name string) []string {
+	return []string{"squeue", "--name=" + name, "--me", "--array", "--noheader", "--format=%F|%K|%A|%T|%N|%r"}
+}
+
+// squeueJobs are the arguments listing the jobs of the user with their comments.
+func squeueJobs(name string) []string {
+	return []string{"squeue", "--name=" + name, "--me", "--noheader", "--format=%F|%k"}
+}
+
+type APITestSuite struct {
+	suite.Suite
+	executor *mocks.Executor
+	jobs     *jobstate.Store
+	slurm    *scheduler.Slurm
+}
+
+func (suite *APITestSuite) BeforeTest(suiteName, testName string) {
+	suite.executor = mocks.NewExecutor(suite.T())
+	api.Configure(suite.executor, user)
+	api.ConfigureLogs(nil)
+	suite.Require().NoError(api.ConfigureCancel("", 0))
+	suite.Require().NoError(api.ConfigurePlacement(api.Placements{}))
+	var err error
+	suite.jobs, err = jobstate.Open("")
+	suite.Require().NoError(err)
+	suite.Require().NoError(api.ConfigureInstance("lab", suite.jobs))
+	suite.slurm = scheduler.NewSlurm(suite.executor, user)
+}
+
+func (suite *APITestSuite) TestStopJobsTracked() {
+	// Arrange
+	suite.Require().NoError(suite.jobs.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 300, Name: "lab-gpu-auto-mining", Replicas: 2}))
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).
+		Return("300|1|301|RUNNING|cn1|None\n299|1|298|RUNNING|cn2|None\n", nil)
+	suite.executor.On("Run", mock.Anything, user, []string{"scancel", "--me", "300"}, nil).
+		Return("", nil).Once()
+	suite.executor.On("Run", mock.Anything, user, squeueJobs("lab-cpu-auto-mining"), nil).
+		Return("", nil)
+
+	// Act
+	err := api.StopJobs(suite.slurm, context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Empty(suite.jobs.List())
+}
+
+func (suite *APITestSuite) TestStopJobsUntracked() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, squeueJobs("lab-gpu-auto-mining"), nil).
+		Return("200|algo=kawpow instance=lab\n201|algo=kawpow instance=lab2\n", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueJobs("lab-cpu-auto-mining"), nil).
+		Return("210|algo=rx/0 instance=lab\n", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).
+		Return("200|1|202|RUNNING|cn1|None\n201|1|203|RUNNING|cn2|None\n", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-cpu-auto-mining"), nil).
+		Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, []string{"scancel", "--me", "200"}, nil).
+		Return("", nil).Once()
+
+	// Act
+	err := api.StopJobs(suite.slurm, context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, user, []string{"scancel", "--me", "201"}, nil)
+}
+
+func (suite *APITestSuite) TestScaleDown() {
+	// Arrange
+	api.SetMining("BTCwallet", 50, "")
+	suite.Require().NoError(suite.jobs.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 300, Name: "lab-gpu-auto-mining", Replicas: 4}))
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).Return(idleNodes, nil)
+	// Half of the 4 GPUs: the tasks above the second one are cancelled.
+	suite.executor.On("Run", mock.Anything, user, []string{"scancel", "--me", "300_[3,4]"}, nil).
+		Return("", nil).Once()
+
+	// Act
+	scaled, err := api.ScaleDown(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.True(scaled)
+	job, ok := suite.jobs.Get(jobstate.KindGPU)
+	suite.True(ok)
+	suite.Equal(2, job.Replicas)
+}
+
+func (suite *APITestSuite) TestScaleDownLowUsage() {
+	// Arrange
+	api.SetMining("BTCwallet", 5, "")
+	suite.Require().NoError(suite.jobs.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 300, Name: "lab-gpu-auto-mining", Replicas: 4}))
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).Return(idleNodes, nil)
+	// 5% of 4 GPUs still mines on one GPU.
+	suite.executor.On("Run", mock.Anything, user, []string{"scancel", "--me", "300_[2,3,4]"}, nil).
+		Return("", nil).Once()
+
+	// Act
+	scaled, err := api.ScaleDown(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.True(scaled)
+	job, ok := suite.jobs.Get(jobstate.KindGPU)
+	suite.True(ok)
+	suite.Equal(1, job.Replicas)
+}
+
+func (suite *APITestSuite) TestComputeReplicasWithoutGPU() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).
+		Return("NodeName=cn1 CPUAlloc=0 CPUTot=16 State=IDLE Partitions=cpu CfgTRES=cpu=16 AllocTRES=\n", nil)
+
+	// Act
+	_, err := api.ComputeReplicas(suite.slurm, context.Background(), 0.5)
+
+	// Assert
+	suite.ErrorContains(err, "no GPU")
+}
+
+func (suite *APITestSuite) TestScaleDownUntracked() {
+	// Arrange
+	api.SetMining("BTCwallet", 50, "")
+
+	// Act
+	scaled, err := api.ScaleDown(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.False(scaled)
+}
+
+func TestAPITestSuite(t *testing.T) {
+	suite.Run(t, &APITestSuite{})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/audit"
+)
+
+// Audit lists the commands executed against Slurm.
+//
+// Query parameters: since and until (RFC3339), user, trigger and limit.
+func Audit(w http.ResponseWriter, r *http.Request, l *audit.Log) {
+	q := audit.Query{
+		User:    r.URL.Query().Get("user"),
+		Trigger: r.URL.Query().Get("trigger"),
+		Limit:   100,
+	}
+
+	var err error
+	if v := r.URL.Query().Get("since"); v != "" {
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: "invalid since: " + err.Error()})
+			return
+		}
+	}
+	if v := r.URL.Query().Get("until"); v != "" {
+		if q.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: "invalid until: " + err.Error()})
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: "invalid limit: " + err.Error()})
+			return
+		}
+	}
+
+	records, err := l.Query(q)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, records)
+}
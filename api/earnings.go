@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/ledger"
+)
+
+// Earnings returns the daily or monthly aggregates of the earnings ledger.
+//
+// Query parameters: period (daily or monthly, default daily), since and until (RFC3339 or 2006-01-02).
+func Earnings(w http.ResponseWriter, r *http.Request, store *ledger.Store) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = ledger.Daily
+	}
+	since, err := parseTime(r.URL.Query().Get("since"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid since: " + err.Error()})
+		return
+	}
+	until, err := parseTime(r.URL.Query().Get("until"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid until: " + err.Error()})
+		return
+	}
+
+	aggs, err := store.Aggregate(period, since, until)
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, aggs)
+}
+
+// parseTime parses an optional RFC3339 timestamp or date.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
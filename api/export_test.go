@@ -0,0 +1,27 @@
+//go:build unit
+
+package api
+
+import (
+	"context"
+	"time"
+)
+
+// SetLogPollInterval sets the interval between two reads of a followed log.
+func SetLogPollInterval(d time.Duration) {
+	logPollInterval = d
+}
+
+// SetMining records that the mining jobs are supposed to run with a wallet, a usage and an optional pinned algorithm.
+func SetMining(walletID string, usage float64, pinnedAlgo string) {
+	setState(func(st *miningState) {
+		*st = miningState{desired: true, walletID: walletID, usage: usage, pinnedAlgo: pinnedAlgo}
+	})
+}
+
+// ScaleDown cancels the GPU tasks above the replicas of the current usage.
+func ScaleDown(ctx context.Context) (bool, error) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return scaleDown(ctx)
+}
@@ -6,22 +6,28 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/squarefactory/miner-api/executor"
-	"github.com/squarefactory/miner-api/scheduler"
-
 	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/scheduler"
 )
 
-func Health(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func Health(w http.ResponseWriter, r *http.Request, breaker *scheduler.CircuitBreaker) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
-	slurm := scheduler.NewSlurm(&executor.Shell{}, user)
+	slurm := newSlurm()
 
 	if err := slurm.HealthCheck(ctx); err != nil {
-		render.Status(r, http.StatusInternalServerError)
-		render.JSON(w, r, Error{Error: err.Error()})
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, HealthStatus{
+			Error:      err.Error(),
+			Scheduler:  breaker.State(),
+			Preemption: preemptionStatus(),
+		})
 		log.Printf("health failed: %s", err)
 		return
 	}
-	render.JSON(w, r, OK{"ok"})
+	render.JSON(w, r, HealthStatus{
+		Data:       "ok",
+		Scheduler:  breaker.State(),
+		Preemption: preemptionStatus(),
+	})
 }
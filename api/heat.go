@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/heat"
+)
+
+var errNoHeatDemand = errors.New("no heat demand")
+
+// HeatState returns the last heat demand and the usage derived from it.
+func HeatState(w http.ResponseWriter, r *http.Request, controller *heat.Controller) {
+	render.JSON(w, r, controller.State())
+}
+
+// RegulateHeat sets the usage of the mining jobs following the heat demand. When it lowers, the surplus GPU tasks
+// are cancelled, else the jobs are restarted.
+func RegulateHeat(ctx context.Context, s *autoswitch.Switcher, controller *heat.Controller) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	st := getState()
+	if !st.desired {
+		return nil
+	}
+	usage, changed, err := controller.Update(ctx, st.usage)
+	if err != nil || !changed {
+		return err
+	}
+	log.Printf("heat demand changed, setting usage from %.0f%% to %.0f%%", st.usage, usage)
+	setState(func(st *miningState) { st.usage = usage })
+	if usage < st.usage {
+		scaled, err := scaleDown(ctx)
+		if err != nil {
+			log.Printf("failed to scale down, restarting miners: %s", err)
+		}
+		if scaled && err == nil {
+			return nil
+		}
+	}
+	return restartMiners(ctx, s)
+}
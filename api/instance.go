@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+var (
+	instanceName string                              // Prefix of the job names, empty for the bare names.
+	jobStore     *jobstate.Store = &jobstate.Store{} // Jobs submitted by this instance, cancelled by ID.
+)
+
+// instancePattern matches the instance names which are valid in job names and comments.
+var instancePattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// ConfigureInstance sets the name of this instance and the store of the jobs it submits.
+func ConfigureInstance(name string, store *jobstate.Store) error {
+	if !instancePattern.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q", name)
+	}
+	instanceName = name
+	if store != nil {
+		jobStore = store
+	}
+	return nil
+}
+
+// JobNames returns the names of the GPU and CPU jobs of this instance.
+func JobNames() []string {
+	return []string{jobName(jobstate.KindGPU), jobName(jobstate.KindCPU)}
+}
+
+// jobName returns the name of the job of a kind, prefixed by the instance name.
+func jobName(kind string) string {
+	name := CPUJobName
+	if kind == jobstate.KindGPU {
+		name = GPUJobName
+	}
+	if instanceName == "" {
+		return name
+	}
+	return instanceName + "-" + name
+}
+
+// comment formats the comment of a job, tagged with the instance name.
+func comment(tags map[string]string) string {
+	if instanceName != "" {
+		tags["instance"] = instanceName
+	}
+	return scheduler.FormatComment(tags)
+}
+
+// trackJob records the job of a kind submitted with the output of sbatch, to cancel it by ID.
+func trackJob(kind, out string, replicas int) error {
+	id, err := scheduler.ParseJobID(out)
+	if err != nil {
+		return err
+	}
+	return jobStore.Put(jobstate.Job{
+		Kind:      kind,
+		ID:        id,
+		Name:      jobName(kind),
+		Replicas:  replicas,
+		Submitted: time.Now(),
+	})
+}
+
+// listTasks lists the tasks of the job of a kind submitted by this instance,
+// or of the jobs with its name if none is tracked.
+func listTasks(ctx context.Context, slurm *scheduler.Slurm, kind string) ([]scheduler.Task, error) {
+	job, ok := jobStore.Get(kind)
+	if !ok {
+		job.Name = jobName(kind)
+	}
+	return slurm.ListTasks(ctx, &scheduler.FindRunningJobByNameRequest{
+		Name:  job.Name,
+		User:  user,
+		JobID: job.ID,
+	})
+}
+
+// instanceJobs lists the jobs of a kind of this instance by name, tagged with the instance name in their comment.
+func instanceJobs(ctx context.Context, slurm *scheduler.Slurm, kind string) ([]jobstate.Job, error) {
+	listed, err := slurm.ListJobs(ctx, &scheduler.FindRunningJobByNameRequest{
+		Name: jobName(kind),
+		User: user,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []jobstate.Job
+	for _, j := range listed {
+		if scheduler.ParseComment(j.Comment)["instance"] == instanceName {
+			jobs = append(jobs, jobstate.Job{Kind: kind, ID: j.ID, Name: jobName(kind)})
+		}
+	}
+	return jobs, nil
+}
+
+// jobsToCancel returns the jobs of this instance: the tracked job of each kind, or else its jobs found by name
+// and comment, like after an upgrade from the versions cancelling by name or when the state file is lost.
+func jobsToCancel(ctx context.Context, slurm *scheduler.Slurm) ([]jobstate.Job, error) {
+	var jobs []jobstate.Job
+	for _, kind := range []string{jobstate.KindGPU, jobstate.KindCPU} {
+		if job, ok := jobStore.Get(kind); ok {
+			jobs = append(jobs, job)
+			continue
+		}
+		found, err := instanceJobs(ctx, slurm, kind)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, found...)
+	}
+	return jobs, nil
+}
+
+// cancelJob cancels a job of this instance, and forgets it.
+// The jobs of other instances with the same name are left running.
+func cancelJob(ctx context.Context, slurm *scheduler.Slurm, job jobstate.Job) error {
+	tasks, err := slurm.ListTasks(ctx, &scheduler.FindRunningJobByNameRequest{
+		Name:  job.Name,
+		User:  user,
+		JobID: job.ID,
+	})
+	if err != nil {
+		return err
+	}
+	if len(tasks) > 0 {
+		if err := slurm.CancelJob(ctx, &scheduler.CancelRequest{
+			Name:        job.Name,
+			User:        user,
+			JobID:       job.ID,
+			Signal:      cancelSignal,
+			GracePeriod: cancelGracePeriod,
+		}); err != nil {
+			return err
+		}
+	}
+	if tracked, ok := jobStore.Get(job.Kind); ok && tracked.ID == job.ID {
+		return jobStore.Delete(job.Kind)
+	}
+	return nil
+}
+
+// scaleDown cancels the array tasks of the GPU job above the replicas of the current usage, instead of restarting
+// the jobs. The CPU job keeps its cores until the next restart.
+//
+// It reports false when the jobs must be restarted instead, like when the replicas grow or the GPU job is not tracked.
+// The caller holds jobsMu.
+func scaleDown(ctx context.Context) (bool, error) {
+	st := getState()
+	if !st.desired || st.pauseErr != nil || st.usage <= 0 {
+		return false, nil
+	}
+	job, ok := jobStore.Get(jobstate.KindGPU)
+	if !ok {
+		return false, nil
+	}
+
+	slurm := newSlurm()
+	replicas, err := ComputeReplicas(slurm, ctx, st.usage/100)
+	if err != nil {
+		return false, err
+	}
+	if replicas.replicasGPU <= 0 || replicas.replicasGPU > job.Replicas {
+		return false, nil
+	}
+	if replicas.replicasGPU == job.Replicas {
+		return true, nil
+	}
+
+	taskIDs := make([]int, 0, job.Replicas-replicas.replicasGPU)
+	for id := replicas.replicasGPU + 1; id <= job.Replicas; id++ {
+		taskIDs = append(taskIDs, id)
+	}
+	log.Printf("scaling job %d down from %d to %d tasks", job.ID, job.Replicas, replicas.replicasGPU)
+	if err := slurm.CancelJob(ctx, &scheduler.CancelRequest{
+		Name:        job.Name,
+		User:        user,
+		JobID:       job.ID,
+		TaskIDs:     taskIDs,
+		Signal:      cancelSignal,
+		GracePeriod: cancelGracePeriod,
+	}); err != nil {
+		return false, err
+	}
+	job.Replicas = replicas.replicasGPU
+	return true, jobStore.Put(job)
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// logChunkSize is the maximum number of bytes read from a log per command.
+const logChunkSize = 256 * 1024
+
+// logPollInterval is the interval between two reads of a followed log.
+var logPollInterval = 2 * time.Second
+
+// logExecutor reads the logs, instead of the executor of the Slurm commands if set.
+var logExecutor scheduler.Executor
+
+// ConfigureLogs sets the executor reading the logs of the tasks.
+//
+// A followed log is read every few seconds, so it should not go through the audit, the metrics and the retries of
+// the Slurm commands. A nil executor reads the logs with the executor given to Configure.
+func ConfigureLogs(e scheduler.Executor) {
+	logExecutor = e
+}
+
+// newLogReader returns the Slurm client reading the logs.
+func newLogReader() *scheduler.Slurm {
+	if logExecutor == nil {
+		return newSlurm()
+	}
+	return scheduler.NewSlurm(logExecutor, user)
+}
+
+// TaskLogs returns the log of an array task.
+//
+// {id} is either the job array ID or the job ID of the task, as listed by the status. The last "tail" lines are returned
+// (100 by default). With follow=true, the log is streamed as Server-Sent Events if the client accepts
+// text/event-stream, and as a chunked plain text response otherwise.
+func TaskLogs(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid job id: " + err.Error()})
+		return
+	}
+	taskID, err := strconv.Atoi(chi.URLParam(r, "task"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid task id: " + err.Error()})
+		return
+	}
+	tail := 100
+	if v := r.URL.Query().Get("tail"); v != "" {
+		if tail, err = strconv.Atoi(v); err != nil || tail < 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: "invalid tail"})
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	slurm := newLogReader()
+	req := &scheduler.TailLogRequest{
+		User:   user,
+		ID:     resolveTaskJobID(id, taskID),
+		TaskID: taskID,
+	}
+
+	size, err := slurm.LogSize(r.Context(), req)
+	if err != nil {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, Error{Error: "log not found", Data: err.Error()})
+		return
+	}
+
+	// Read the last lines from the end of the file, without reading it whole.
+	start := size - logChunkSize
+	if start < 0 {
+		start = 0
+	}
+	out, err := slurm.ReadLog(r.Context(), &scheduler.ReadLogRequest{
+		User:   req.User,
+		ID:     req.ID,
+		TaskID: req.TaskID,
+		Offset: start,
+		Length: size - start,
+	})
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	lines := lastLines(out, tail)
+
+	if !follow {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(lines))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, Error{Error: "streaming unsupported"})
+		return
+	}
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	write := func(data string) error {
+		if sse {
+			data = toEvents(data)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	if err := write(lines); err != nil {
+		return
+	}
+
+	var heartbeat func() error
+	if sse {
+		// SSE comments detect the disconnected clients while the log is idle.
+		heartbeat = func() error {
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}
+	}
+	followLog(r.Context(), slurm, req, size, write, heartbeat)
+}
+
+// followLog polls the log from offset until ctx is done, writing the appended content.
+func followLog(
+	ctx context.Context,
+	slurm *scheduler.Slurm,
+	req *scheduler.TailLogRequest,
+	offset int64,
+	write func(string) error,
+	heartbeat func() error,
+) {
+	// Only complete lines are written, the rest is kept for the next poll.
+	var partial string
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			out, err := slurm.ReadLog(ctx, &scheduler.ReadLogRequest{
+				User:   req.User,
+				ID:     req.ID,
+				TaskID: req.TaskID,
+				Offset: offset,
+				Length: logChunkSize,
+			})
+			if err != nil {
+				log.Printf("failed to follow log: %s", err)
+				return
+			}
+			offset += int64(len(out))
+			partial += out
+
+			if i := strings.LastIndexByte(partial, '\n'); i >= 0 {
+				if err := write(partial[:i+1]); err != nil {
+					return
+				}
+				partial = partial[i+1:]
+			} else if heartbeat != nil {
+				if err := heartbeat(); err != nil {
+					return
+				}
+			}
+			if len(out) < logChunkSize {
+				break
+			}
+		}
+	}
+}
+
+// resolveTaskJobID returns the job ID of the array task taskID of the job array id, if known.
+func resolveTaskJobID(id int, taskID int) int {
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	for _, t := range lastTasks {
+		if t.JobID == id && t.TaskID == taskID {
+			return t.ID
+		}
+	}
+	return id
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	if n == 0 {
+		return ""
+	}
+	s = strings.TrimSuffix(s, "\n")
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// toEvents formats lines as Server-Sent Events, one event per line.
+func toEvents(lines string) string {
+	var b strings.Builder
+	if lines == "" {
+		return ""
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(lines, "\n"), "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+//go:build unit
+
+package api_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/squarefactory/miner-api/api"
+	"github.com/squarefactory/miner-api/mocks"
+	"github.com/stretchr/testify/mock"
+)
+
+// ddLog are the arguments reading a range of the log of the task 1 of the job 42.
+func ddLog(offset, length string) []string {
+	return []string{
+		"dd",
+		"if=/tmp/miner-42_1.log",
+		"iflag=skip_bytes,count_bytes",
+		"skip=" + offset,
+		"count=" + length,
+		"status=none",
+	}
+}
+
+func (suite *APITestSuite) serveLogs(req *http.Request) *httptest.ResponseRecorder {
+	router := chi.NewRouter()
+	router.Get("/api/v1/jobs/{id}/tasks/{task}/logs", api.TaskLogs)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (suite *APITestSuite) TestTaskLogsTail() {
+	// Arrange
+	logs := mocks.NewExecutor(suite.T())
+	api.ConfigureLogs(logs)
+	logs.On("Run", mock.Anything, user, []string{"stat", "--format=%s", "/tmp/miner-42_1.log"}, nil).
+		Return("12\n", nil)
+	logs.On("Run", mock.Anything, user, ddLog("0", "12"), nil).
+		Return("a\nb\nc\nd\ne\nf\n", nil)
+
+	// Act
+	rec := suite.serveLogs(httptest.NewRequest(http.MethodGet, "/api/v1/jobs/42/tasks/1/logs?tail=2", nil))
+
+	// Assert
+	suite.Equal(http.StatusOK, rec.Code)
+	suite.Equal("e\nf\n", rec.Body.String())
+	// The Slurm commands executor is not used.
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *APITestSuite) TestTaskLogsFollow() {
+	// Arrange
+	api.SetLogPollInterval(time.Millisecond)
+	suite.T().Cleanup(func() { api.SetLogPollInterval(2 * time.Second) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logs := mocks.NewExecutor(suite.T())
+	api.ConfigureLogs(logs)
+	logs.On("Run", mock.Anything, user, []string{"stat", "--format=%s", "/tmp/miner-42_1.log"}, nil).
+		Return("6\n", nil)
+	logs.On("Run", mock.Anything, user, ddLog("0", "6"), nil).
+		Return("a\nb\nc\n", nil)
+	// The appended lines are written once complete.
+	logs.On("Run", mock.Anything, user, ddLog("6", "262144"), nil).
+		Return("d\ne", nil).Once()
+	logs.On("Run", mock.Anything, user, ddLog("9", "262144"), nil).
+		Run(func(mock.Arguments) { cancel() }).
+		Return("\n", nil).Once()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/42/tasks/1/logs?tail=1&follow=true", nil).
+		WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// Act
+	rec := suite.serveLogs(req)
+
+	// Assert
+	suite.Equal("text/event-stream", rec.Header().Get("Content-Type"))
+	suite.Equal("data: c\n\ndata: d\n\ndata: e\n\n", rec.Body.String())
+}
+
+func (suite *APITestSuite) TestTaskLogsNotFound() {
+	// Arrange
+	logs := mocks.NewExecutor(suite.T())
+	api.ConfigureLogs(logs)
+	logs.On("Run", mock.Anything, user, []string{"stat", "--format=%s", "/tmp/miner-42_1.log"}, nil).
+		Return("", errors.New("exit status 1"))
+
+	// Act
+	rec := suite.serveLogs(httptest.NewRequest(http.MethodGet, "/api/v1/jobs/42/tasks/1/logs", nil))
+
+	// Assert
+	suite.Equal(http.StatusNotFound, rec.Code)
+}
@@ -9,13 +9,17 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"sync"
 	"text/template"
 	"time"
 
 	"github.com/go-chi/render"
 	"github.com/squarefactory/miner-api/autoswitch"
 	"github.com/squarefactory/miner-api/executor"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/metrics"
 	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/squarefactory/miner-api/wallet"
 )
 
 var AlgoGminer = map[string]string{
@@ -31,18 +35,58 @@ var AlgoGminer = map[string]string{
 	"zhash":       "equihash144_5",
 }
 
+// Names of the mining jobs, prefixed by the instance name if any.
 const (
 	GPUJobName = "gpu-auto-mining"
 	CPUJobName = "cpu-auto-mining"
-	user       = "root"
 )
 
 var (
-	lastWalletID string
-	lastUsage    float64
-	jobState     = false // Indicates if a job is supposed to be running or not. (True = Running)
+	slurmExecutor scheduler.Executor = &executor.Shell{}
+	user                             = "root" // UNIX user submitting the mining jobs.
 )
 
+// Configure sets the executor running the Slurm commands and the UNIX user submitting the mining jobs.
+func Configure(e scheduler.Executor, jobUser string) {
+	slurmExecutor = e
+	if jobUser != "" {
+		user = jobUser
+	}
+}
+
+// DefaultCancelSignal is trapped by the job templates to stop the miners.
+const DefaultCancelSignal = "TERM"
+
+var (
+	cancelSignal      = DefaultCancelSignal
+	cancelGracePeriod = 30 * time.Second
+)
+
+// ConfigureCancel sets the signal sent to the mining jobs before they are cancelled, and the grace period
+// given to the miners to stop before they are killed. An empty signal cancels the jobs right away.
+func ConfigureCancel(signal string, gracePeriod time.Duration) error {
+	if signal != "" {
+		if err := scheduler.ValidateSignal(signal); err != nil {
+			return err
+		}
+	}
+	cancelSignal = signal
+	cancelGracePeriod = gracePeriod
+	return nil
+}
+
+// trappedSignal returns the signal trapped by the job templates. Slurm sends TERM to the preempted jobs.
+func trappedSignal() string {
+	if cancelSignal == "" {
+		return DefaultCancelSignal
+	}
+	return cancelSignal
+}
+
+func newSlurm() *scheduler.Slurm {
+	return scheduler.NewSlurm(slurmExecutor, user)
+}
+
 type Replicas struct {
 	maxGPU      int
 	replicasGPU int
@@ -54,29 +98,105 @@ type Replicas struct {
 type JobData struct {
 	walletID string
 	algo     string
+	profit   autoswitch.Profit  // Estimated profit of the GPU job, if selected by the switcher.
+	pools    []*autoswitch.Pool // Pools of the GPU job, the primary one first and then the backups.
+	cpuPools []*autoswitch.Pool
 }
 
-func MineStart(w http.ResponseWriter, r *http.Request, s *autoswitch.Switcher) {
-	slurm := scheduler.NewSlurm(&executor.Shell{}, user)
+// maxBackupPools bounds the number of backup pools given to the miners.
+const maxBackupPools = 2
 
-	// Check if GPU job already running
-	if jobID, err := slurm.FindRunningJobByName(r.Context(), &scheduler.FindRunningJobByNameRequest{
-		Name: GPUJobName,
-		User: user,
-	}); err == nil {
-		render.Status(r, http.StatusBadRequest)
-		render.JSON(w, r, Error{Error: fmt.Sprintf("job %d is already running", jobID)})
-		return
+// newJobData selects the pools mining the chosen algorithm and the CPU algorithm.
+func newJobData(s *autoswitch.Switcher, walletID string, best autoswitch.Profit) (JobData, error) {
+	data := JobData{
+		walletID: walletID,
+		algo:     best.Algo,
+		profit:   best,
+	}
+	var primary *autoswitch.Pool
+	var err error
+	if best.Pool != "" {
+		primary, err = s.Config.GetPool(best.Pool)
+	} else if healthy := s.Pools(best.Algo); len(healthy) > 0 {
+		primary = healthy[0]
+	} else {
+		primary, err = s.Config.FindPool(best.Algo)
 	}
+	if err != nil {
+		return JobData{}, err
+	}
+	data.pools = withBackups(primary, s.Pools(best.Algo))
+
+	cpuPools := s.Pools(autoswitch.CPUAlgo)
+	if len(cpuPools) == 0 {
+		pool, err := s.Config.FindPool(autoswitch.CPUAlgo)
+		if err != nil {
+			return JobData{}, err
+		}
+		cpuPools = []*autoswitch.Pool{pool}
+	}
+	data.cpuPools = withBackups(cpuPools[0], cpuPools)
+	return data, nil
+}
 
-	// Check if CPU job already running
-	if jobID, err := slurm.FindRunningJobByName(r.Context(), &scheduler.FindRunningJobByNameRequest{
-		Name: CPUJobName,
-		User: user,
-	}); err == nil {
-		render.Status(r, http.StatusBadRequest)
-		render.JSON(w, r, Error{Error: fmt.Sprintf("job %d is already running", jobID)})
-		return
+// withBackups returns the primary pool followed by up to maxBackupPools other healthy pools.
+func withBackups(primary *autoswitch.Pool, healthy []*autoswitch.Pool) []*autoswitch.Pool {
+	pools := []*autoswitch.Pool{primary}
+	for _, p := range healthy {
+		if len(pools) > maxBackupPools {
+			break
+		}
+		if p.Name != primary.Name {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
+// endpoints renders the endpoints of the pools for a miner, in their best region, paying to the wallets of the
+// pools or else to walletID. The workers are named after the template of the wallet, or of the config.
+func endpoints(s *autoswitch.Switcher, pools []*autoswitch.Pool, algo, miner, walletID string) ([]autoswitch.Endpoint, error) {
+	endpoints := make([]autoswitch.Endpoint, 0, len(pools))
+	for _, p := range pools {
+		address, template := payout(p, algo, walletID)
+		// The address is given to the miners inside a "bash -c" command.
+		if err := wallet.ValidateAddress(address); err != nil {
+			return nil, fmt.Errorf("pool %s: %w", p.Name, err)
+		}
+		if template == "" {
+			template = s.Config.Workers.GetTemplate()
+		}
+		worker, err := p.Worker(template, autoswitch.NewWorkerData(s.Config.Workers.Cluster, algo, miner == autoswitch.MinerGminer))
+		if err != nil {
+			return nil, err
+		}
+		e, err := s.Endpoint(p, algo, miner, address, worker)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func MineStart(w http.ResponseWriter, r *http.Request, s *autoswitch.Switcher) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	slurm := newSlurm()
+
+	// Check if the GPU or CPU job of this instance is already running
+	for _, kind := range []string{jobstate.KindGPU, jobstate.KindCPU} {
+		jobs, err := instanceJobs(r.Context(), slurm, kind)
+		if err != nil {
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, Error{Error: err.Error()})
+			return
+		}
+		if len(jobs) > 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: fmt.Sprintf("job %d is already running", jobs[0].ID)})
+			return
+		}
 	}
 
 	walletID := r.FormValue("walletId")
@@ -86,7 +206,11 @@ func MineStart(w http.ResponseWriter, r *http.Request, s *autoswitch.Switcher) {
 		log.Printf("wallet not defined")
 		return
 	}
-	lastWalletID = walletID
+	if err := wallet.ValidateAddress(walletID); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
 
 	// Convert usage slider value to percentage
 	usage, err := strconv.ParseFloat(r.FormValue("usage"), 64)
@@ -96,7 +220,10 @@ func MineStart(w http.ResponseWriter, r *http.Request, s *autoswitch.Switcher) {
 		log.Printf("failed to parse usage value: %s", err)
 		return
 	}
-	lastUsage = usage
+	setState(func(st *miningState) {
+		st.walletID = walletID
+		st.usage = usage
+	})
 
 	// Compute Replicas
 	replicas, err := ComputeReplicas(slurm, r.Context(), usage/100)
@@ -108,50 +235,133 @@ func MineStart(w http.ResponseWriter, r *http.Request, s *autoswitch.Switcher) {
 	}
 
 	// get best algo and corresponding pool for gpu mining job
-	bestAlgo, err := s.GetBestAlgo(r.Context())
+	best, err := s.GetBest(r.Context())
+	if errors.Is(err, autoswitch.ErrUnprofitable) {
+		// The jobs are submitted by RestartMiners once mining is profitable again.
+		setState(func(st *miningState) {
+			st.pauseErr = err
+			st.desired = true
+			st.pinnedAlgo = ""
+		})
+		metrics.MiningDesired.Set(1)
+		render.JSON(w, r, OK{"Mining paused: " + err.Error()})
+		return
+	}
 	if err != nil {
 		render.Status(r, http.StatusInternalServerError)
 		render.JSON(w, r, Error{Error: err.Error()})
-		log.Printf("GetBestAlgo failed: %s", err)
+		log.Printf("GetBest failed: %s", err)
 		return
 	}
 
-	data := JobData{
-		algo:     bestAlgo,
-		walletID: walletID,
+	data, err := newJobData(s, walletID, best)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, Error{Error: err.Error()})
+		log.Printf("failed to select pools: %s", err)
+		return
 	}
 
-	out, err := StartJobs(slurm, r.Context(), replicas, data)
+	out, err := StartJobs(slurm, r.Context(), s, replicas, data)
 	if err != nil {
 		log.Printf("failed to start jobs: %s", err)
 		return
 	}
 
 	render.JSON(w, r, OK{fmt.Sprintf("Mining jobs %s started", out)})
-	jobState = true
+	setState(func(st *miningState) {
+		st.desired = true
+		st.pinnedAlgo = ""
+		st.pauseErr = nil
+	})
+	metrics.MiningDesired.Set(1)
 
 }
 
 func MineStop(w http.ResponseWriter, r *http.Request) {
-	slurm := scheduler.NewSlurm(&executor.Shell{}, user)
-	// cancelling GPU job
-	if err := StopJobs(slurm, r.Context()); err != nil {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if err := stopMining(r.Context()); err != nil {
 		log.Printf("failed to stop jobs: %s", err)
 		return
 	}
 
 	render.JSON(w, r, OK{"Mining job stopped"})
-	jobState = false
 }
 
+// stopMining cancels the mining jobs and records that they are not supposed to run anymore.
+// The caller holds jobsMu.
+func stopMining(ctx context.Context) error {
+	if err := StopJobs(newSlurm(), ctx); err != nil {
+		return err
+	}
+
+	setState(func(st *miningState) {
+		st.desired = false
+		st.pauseErr = nil
+		st.pinnedAlgo = ""
+	})
+	metrics.MiningDesired.Set(0)
+	metrics.ReplicasRequested.WithLabelValues("gpu").Set(0)
+	metrics.ReplicasRequested.WithLabelValues("cpu").Set(0)
+	return nil
+}
+
+// Autoswitch restarts the mining jobs on the most profitable algorithm, if they are supposed to run.
+func Autoswitch(ctx context.Context, s *autoswitch.Switcher) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if st := getState(); !st.desired || st.pinnedAlgo != "" {
+		return nil
+	}
+	log.Printf("autoswitch: restarting miners now")
+	return restartMiners(ctx, s)
+}
+
+// RestartMiners resubmits the mining jobs with the current state, if they are supposed to run.
 func RestartMiners(ctx context.Context, s *autoswitch.Switcher) error {
-	slurm := scheduler.NewSlurm(&executor.Shell{}, user)
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return restartMiners(ctx, s)
+}
+
+// stopDelay lets the cancelled jobs release their resources before they are submitted again.
+var stopDelay = 10 * time.Second
 
-	if !jobState {
+// restartMiners resubmits the mining jobs with the current state. The caller holds jobsMu.
+func restartMiners(ctx context.Context, s *autoswitch.Switcher) error {
+	slurm := newSlurm()
+
+	st := getState()
+	if !st.desired {
 		log.Printf("no jobs are currently running")
 		return errors.New("jobs are not running, unable to restart")
 	}
 
+	// Pause mining while there is no heat demand
+	if st.usage <= 0 {
+		log.Printf("pausing mining: %s", errNoHeatDemand)
+		setState(func(st *miningState) { st.pauseErr = errNoHeatDemand })
+		return StopJobs(slurm, ctx)
+	}
+
+	// Get best algo, or pause mining while the electricity costs more than it earns
+	best := autoswitch.Profit{Algo: st.pinnedAlgo}
+	if st.pinnedAlgo == "" {
+		var err error
+		best, err = s.GetBest(ctx)
+		if errors.Is(err, autoswitch.ErrUnprofitable) {
+			log.Printf("pausing mining: %s", err)
+			setState(func(st *miningState) { st.pauseErr = err })
+			return StopJobs(slurm, ctx)
+		}
+		if err != nil {
+			log.Printf("failed to get best algo")
+			return err
+		}
+	}
+	setState(func(st *miningState) { st.pauseErr = nil })
+
 	// Stop miners
 	if err := StopJobs(slurm, ctx); err != nil {
 		log.Printf("failed to stop jobs")
@@ -159,29 +369,23 @@ func RestartMiners(ctx context.Context, s *autoswitch.Switcher) error {
 	}
 
 	// Wait for jobs to stop completely
-	time.Sleep(time.Duration(10) * time.Second)
+	time.Sleep(stopDelay)
 
 	// Compute Replicas
-	replicas, err := ComputeReplicas(slurm, ctx, lastUsage/100)
+	replicas, err := ComputeReplicas(slurm, ctx, st.usage/100)
 	if err != nil {
 		log.Printf("failed to compute replicas")
 		return err
 	}
 
-	// Get best algo
-	bestAlgo, err := s.GetBestAlgo(ctx)
+	data, err := newJobData(s, st.walletID, best)
 	if err != nil {
-		log.Printf("failed to get best algo")
+		log.Printf("failed to select pools")
 		return err
 	}
 
-	data := JobData{
-		walletID: lastWalletID,
-		algo:     bestAlgo,
-	}
-
 	// Restart miners
-	if _, err := StartJobs(slurm, ctx, replicas, data); err != nil {
+	if _, err := StartJobs(slurm, ctx, s, replicas, data); err != nil {
 		log.Printf("failed to restart jobs")
 		return err
 	}
@@ -189,42 +393,75 @@ func RestartMiners(ctx context.Context, s *autoswitch.Switcher) error {
 	return nil
 }
 
+// CheckProfitability pauses the mining jobs when the electricity price makes mining unprofitable,
+// and resumes them once it is profitable again.
+func CheckProfitability(ctx context.Context, s *autoswitch.Switcher) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	st := getState()
+	if !st.desired || st.pinnedAlgo != "" {
+		return nil
+	}
+	err := s.CheckProfitable(ctx)
+	unprofitable := errors.Is(err, autoswitch.ErrUnprofitable)
+	if err != nil && !unprofitable {
+		return err
+	}
+	if paused := errors.Is(st.pauseErr, autoswitch.ErrUnprofitable); paused == unprofitable {
+		return nil
+	}
+	log.Printf("electricity price changed, restarting miners now")
+	return restartMiners(ctx, s)
+}
+
 func ComputeReplicas(slurm *scheduler.Slurm, ctx context.Context, percent float64) (Replicas, error) {
-	// Compute maxGPU
-	maxGPU, err := slurm.FindMaxGPU(ctx)
+	placement := getPlacement()
+
+	// Compute the GPUs of the nodes of the GPU job
+	gpuCapacity, err := slurm.FindCapacity(ctx, placement.GPU)
 	if err != nil {
 		log.Printf("failed to compute maxGPU: %s", err)
 		return Replicas{}, err
 	}
-
-	// Compute GPU replica numbers
-	GPUReplicas := int(math.Floor((percent) * float64(maxGPU)))
-	// make sure GPU replicas > 0
-	if GPUReplicas <= 0 {
-		log.Printf("usage not defined: %s", err)
-		return Replicas{}, err
+	maxGPU := gpuCapacity.GPUs
+	if percent <= 0 {
+		return Replicas{}, fmt.Errorf("invalid usage %.0f%%", percent*100)
+	}
+	if maxGPU == 0 {
+		return Replicas{}, errors.New("no GPU matches the placement of the gpu job")
 	}
 
-	// Compute maxNode
-	maxNode, err := slurm.FindMaxNode(ctx)
-	if err != nil {
-		log.Printf("failed to compute maxNode: %s", err)
-		return Replicas{}, err
+	// Compute GPU replica numbers, keeping at least one GPU for a low usage
+	GPUReplicas := int(math.Floor((percent) * float64(maxGPU)))
+	if GPUReplicas < 1 {
+		GPUReplicas = 1
 	}
 
-	maxCPU, err := slurm.FindMaxCPU(ctx)
+	// Compute the nodes and cores of the CPU job
+	cpuCapacity, err := slurm.FindCapacity(ctx, placement.CPU)
 	if err != nil {
 		log.Printf("failed to compute maxCPU: %s", err)
 		return Replicas{}, err
 	}
+	maxNode := cpuCapacity.Nodes
+	maxCPU := cpuCapacity.CPUs
+	if maxNode == 0 {
+		return Replicas{}, errors.New("no node matches the placement of the cpu job")
+	}
 
-	// Compute number of cores used by each miner, keeping 1 core per GPU miner
-	CPUPerTasks := int(math.Floor((percent) * float64((maxCPU-maxGPU)/maxNode)))
-	if CPUPerTasks <= 0 {
-		log.Printf("usage not defined: %s", err)
-		return Replicas{}, err
+	// Compute number of cores used by each miner, keeping 1 core per GPU miner, and at least one core for a low usage
+	coresPerNode := (maxCPU - cpuCapacity.GPUs) / maxNode
+	if coresPerNode <= 0 {
+		return Replicas{}, errors.New("no core is left for the cpu job on the nodes of its placement")
+	}
+	CPUPerTasks := int(math.Floor((percent) * float64(coresPerNode)))
+	if CPUPerTasks < 1 {
+		CPUPerTasks = 1
 	}
 
+	metrics.ReplicasRequested.WithLabelValues("gpu").Set(float64(GPUReplicas))
+	metrics.ReplicasRequested.WithLabelValues("cpu").Set(float64(maxNode))
+
 	return Replicas{
 		maxGPU:      maxGPU,
 		maxNode:     maxNode,
@@ -234,26 +471,28 @@ func ComputeReplicas(slurm *scheduler.Slurm, ctx context.Context, percent float6
 	}, nil
 }
 
+// StopJobs cancels the GPU and CPU jobs submitted by this instance together, signaling them first to let the miners
+// stop gracefully.
 func StopJobs(slurm *scheduler.Slurm, ctx context.Context) error {
-	// cancelling GPU job
-	err := slurm.CancelJob(ctx, &scheduler.CancelRequest{
-		Name: GPUJobName,
-		User: user,
-	})
-
+	jobs, err := jobsToCancel(ctx, slurm)
 	if err != nil {
-		log.Printf("GPU mine stop failed: %s", err)
 		return err
 	}
-
-	// cancelling CPU job
-	err = slurm.CancelJob(ctx, &scheduler.CancelRequest{
-		Name: CPUJobName,
-		User: user,
-	})
-
-	if err != nil {
-		log.Printf("CPU mine stop failed: %s", err)
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	for i, job := range jobs {
+		i, job := i, job
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = cancelJob(ctx, slurm, job)
+			if errs[i] != nil {
+				log.Printf("%s mine stop failed: %s", job.Kind, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
 		return err
 	}
 
@@ -261,70 +500,160 @@ func StopJobs(slurm *scheduler.Slurm, ctx context.Context) error {
 	return nil
 }
 
-func StartJobs(slurm *scheduler.Slurm, ctx context.Context, replicas Replicas, data JobData) (string, error) {
+func StartJobs(slurm *scheduler.Slurm, ctx context.Context, s *autoswitch.Switcher, replicas Replicas, data JobData) (string, error) {
+	// The miners of both jobs may listen on the same node.
+	if err := collector.CheckPorts(replicas.replicasGPU, replicas.maxNode); err != nil {
+		log.Printf("invalid miner API ports: %s", err)
+		return "", err
+	}
+
+	GPUout, GPUEndpoints, err := submitGPU(ctx, slurm, s, replicas.replicasGPU, data)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("successfully restarted gpu job: %s", GPUout)
 
+	CPUout, CPUEndpoints, err := submitCPU(ctx, slurm, s, replicas.maxNode, replicas.replicasCPU, data)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("successfully restarted cpu job: %s", CPUout)
+
+	log.Printf("successfully restarted jobs")
+	setState(func(st *miningState) {
+		st.algo = data.algo
+		st.pool = data.pools[0].Name
+		st.coin = data.pools[0].Coins[data.algo]
+		st.gpuEndpoints = GPUEndpoints
+		st.cpuEndpoints = CPUEndpoints
+	})
+	for _, e := range append(append([]autoswitch.Endpoint(nil), GPUEndpoints...), CPUEndpoints...) {
+		log.Printf("mining on %s (region %q, %s, latency %s)", e.Pool, e.Region, e.Address, e.Latency)
+	}
+	metrics.SetAlgorithm(data.algo)
+	metrics.SwitchSucceeded()
+	return fmt.Sprintf("%s"+"%s", GPUout, CPUout), nil
+}
+
+// submitGPU submits and records the GPU job with replicas tasks, returning the output of sbatch and the endpoints
+// given to the miners.
+func submitGPU(ctx context.Context, slurm *scheduler.Slurm, s *autoswitch.Switcher, replicas int, data JobData) (string, []autoswitch.Endpoint, error) {
 	// Templating gpu mining job
+	GPUEndpoints, err := endpoints(s, data.pools, data.algo, autoswitch.MinerGminer, data.walletID)
+	if err != nil {
+		log.Printf("templating failed: %s", err)
+		return "", nil, err
+	}
 	GPUtmpl := template.Must(template.New("jobTemplate").Parse(GPUTemplate))
 	var GPUJobScript bytes.Buffer
 	if err := GPUtmpl.Execute(&GPUJobScript, struct {
-		Wallet   string
-		Algo     string
-		Pool     string
-		Replicas int
+		Endpoints []autoswitch.Endpoint
+		Flags     []string
+		Algo      string
+		Replicas  int
+		APIPort   int
+		Signal    string
 	}{
-		Wallet:   data.walletID,
-		Algo:     AlgoGminer[data.algo],
-		Pool:     data.algo + ".auto.nicehash.com:443",
-		Replicas: replicas.replicasGPU,
+		Endpoints: GPUEndpoints,
+		Flags:     GPUEndpoints[0].Flags,
+		Algo:      AlgoGminer[data.algo],
+		Replicas:  replicas,
+		APIPort:   collector.GminerBasePort,
+		Signal:    trappedSignal(),
 	}); err != nil {
 		log.Printf("templating failed: %s", err)
-		return "", err
+		return "", nil, err
 	}
 
 	// submitting gpu mining job
 	GPUout, err := slurm.Submit(ctx, &scheduler.SubmitRequest{
-		Name: GPUJobName,
-		User: user,
-		Body: GPUJobScript.String(),
+		Name:      jobName(jobstate.KindGPU),
+		User:      user,
+		Body:      GPUJobScript.String(),
+		Comment:   comment(gpuTags(data)),
+		Placement: getPlacement().GPU,
+		Requeue:   true,
 	})
 	if err != nil {
 		log.Printf("submit failed: %s", err)
-		return "", err
+		return "", nil, err
 	}
-	log.Printf("successfully restarted gpu job: %s", GPUout)
+	if err := trackJob(jobstate.KindGPU, GPUout, replicas); err != nil {
+		log.Printf("failed to record gpu job %s: %s", GPUout, err)
+		return "", nil, err
+	}
+	return GPUout, GPUEndpoints, nil
+}
 
+// submitCPU submits and records the CPU job on nodes nodes using cores cores each, returning the output of sbatch
+// and the endpoints given to the miners.
+func submitCPU(ctx context.Context, slurm *scheduler.Slurm, s *autoswitch.Switcher, nodes, cores int, data JobData) (string, []autoswitch.Endpoint, error) {
 	// Templating cpu mining job
+	CPUEndpoints, err := endpoints(s, data.cpuPools, autoswitch.CPUAlgo, autoswitch.MinerXmrig, data.walletID)
+	if err != nil {
+		log.Printf("templating failed: %s", err)
+		return "", nil, err
+	}
 	CPUTmpl := template.Must(template.New("CPUTemplate").Parse(CPUTemplate))
 	var CPUJobScript bytes.Buffer
 	if err := CPUTmpl.Execute(&CPUJobScript, struct {
-		Wallet string
-		Algo   string
-		Pool   string
-		Node   int
-		Core   int
+		Endpoints []autoswitch.Endpoint
+		Algo      string
+		Node      int
+		Core      int
+		APIPort   int
+		APIToken  string
+		Signal    string
 	}{
-		Wallet: lastWalletID,
-		Algo:   "rx/0",
-		Pool:   "randomxmonero.auto.nicehash.com:443",
-		Node:   replicas.maxNode,
-		Core:   replicas.replicasCPU,
+		Endpoints: CPUEndpoints,
+		Algo:      "rx/0",
+		Node:      nodes,
+		Core:      cores,
+		APIPort:   collector.XmrigBasePort,
+		APIToken:  collector.XmrigAccessToken,
+		Signal:    trappedSignal(),
 	}); err != nil {
 		log.Printf("templating failed: %s", err)
-		return "", err
+		return "", nil, err
 	}
 
 	// submitting cpu mining job
 	CPUout, err := slurm.Submit(ctx, &scheduler.SubmitRequest{
-		Name: CPUJobName,
-		User: user,
-		Body: CPUJobScript.String(),
+		Name:      jobName(jobstate.KindCPU),
+		User:      user,
+		Body:      CPUJobScript.String(),
+		Comment:   comment(poolTags(data.cpuPools[0], autoswitch.CPUAlgo)),
+		Placement: getPlacement().CPU,
+		Requeue:   true,
 	})
 	if err != nil {
 		log.Printf("submit failed: %s", err)
-		return "", err
+		return "", nil, err
 	}
-	log.Printf("successfully restarted cpu job: %s", CPUout)
+	if err := trackJob(jobstate.KindCPU, CPUout, nodes); err != nil {
+		log.Printf("failed to record cpu job %s: %s", CPUout, err)
+		return "", nil, err
+	}
+	return CPUout, CPUEndpoints, nil
+}
 
-	log.Printf("successfully restarted jobs")
-	return fmt.Sprintf("%s"+"%s", GPUout, CPUout), nil
+// poolTags tags the comment of a job mining algo on a pool, with the coin of a coin pool.
+func poolTags(pool *autoswitch.Pool, algo string) map[string]string {
+	tags := map[string]string{"algo": algo, "pool": pool.Name}
+	if coin := pool.Coins[algo]; coin != "" {
+		tags["coin"] = coin
+	}
+	return tags
+}
+
+// gpuTags tags the comment of the GPU job. On a coin pool, the estimated rewards and revenue of each task per day
+// and the exchange rate of the coin are recorded for the profit reports, the coins not being paid through NiceHash.
+func gpuTags(data JobData) map[string]string {
+	tags := poolTags(data.pools[0], data.algo)
+	if p := data.profit; p.Coin != "" && p.Coin == tags["coin"] && p.Pool == data.pools[0].Name {
+		tags["rewards"] = strconv.FormatFloat(p.Rewards, 'g', -1, 64)
+		tags["rate"] = strconv.FormatFloat(p.ExchangeRate, 'g', -1, 64)
+		tags["revenue"] = strconv.FormatFloat(p.Revenue, 'g', -1, 64)
+	}
+	return tags
 }
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// Placements restrict the nodes running the mining jobs.
+type Placements struct {
+	GPU scheduler.Placement `json:"gpu"`
+	CPU scheduler.Placement `json:"cpu"`
+}
+
+// Validate checks the placements of both jobs.
+func (p Placements) Validate() error {
+	if err := p.GPU.Validate(); err != nil {
+		return fmt.Errorf("gpu: %w", err)
+	}
+	if err := p.CPU.Validate(); err != nil {
+		return fmt.Errorf("cpu: %w", err)
+	}
+	return nil
+}
+
+var (
+	placementMu sync.Mutex
+	placement   Placements
+)
+
+// ConfigurePlacement sets the placements of the mining jobs.
+func ConfigurePlacement(p Placements) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	placementMu.Lock()
+	defer placementMu.Unlock()
+	placement = p
+	return nil
+}
+
+func getPlacement() Placements {
+	placementMu.Lock()
+	defer placementMu.Unlock()
+	return placement
+}
+
+// GetPlacement returns the placements of the mining jobs.
+func GetPlacement(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, getPlacement())
+}
+
+// PutPlacement replaces the placements of the mining jobs from a JSON body like
+// {"gpu": {"partition": "gpu", "account": "mining", "constraint": "a100", "nice": 100}, "cpu": {"partition": "cpu"}}.
+//
+// They apply to the jobs submitted next, and are reset to the config on restart.
+func PutPlacement(w http.ResponseWriter, r *http.Request) {
+	var p Placements
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	if err := ConfigurePlacement(p); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, OK{"Placement saved, applied to the next mining jobs"})
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/stratum"
+)
+
+// PoolStatus returns the results of the last probes of the pools.
+func PoolStatus(w http.ResponseWriter, r *http.Request, checker *stratum.Checker) {
+	render.JSON(w, r, checker.Statuses())
+}
+
+// ProbePools probes the address of each pool and region for the configured algorithms and the CPU algorithm.
+//
+// The workers are authorized once the pool has a wallet, or a wallet has been given to /start.
+func ProbePools(ctx context.Context, s *autoswitch.Switcher, checker *stratum.Checker) {
+	algos := []string{autoswitch.CPUAlgo}
+	for algo := range s.Config.Algos {
+		algos = append(algos, algo)
+	}
+
+	seen := make(map[string]bool)
+	var targets []stratum.Target
+	walletID := getState().walletID
+	pools := s.Config.GetPools()
+	for i := range pools {
+		for _, algo := range algos {
+			if !pools[i].Supports(algo) {
+				continue
+			}
+			for _, region := range pools[i].GetRegions() {
+				address, _ := payout(&pools[i], algo, walletID)
+				e, err := pools[i].Endpoint(algo, region, autoswitch.MinerGminer, address, "probe")
+				if err != nil || seen[e.Address] {
+					continue
+				}
+				seen[e.Address] = true
+				target := stratum.Target{
+					Pool:    e.Pool,
+					Address: e.Address,
+					TLS:     e.TLS,
+				}
+				if address != "" {
+					target.Credentials = &stratum.Credentials{User: e.User, Password: e.Password}
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+	checker.Check(ctx, targets)
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/metrics"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+var (
+	preemptionMu  sync.Mutex
+	preemptionErr error // Why the mining jobs may not be preempted, found by CheckPreemption.
+)
+
+// CheckPreemption verifies that the jobs of the mining QoS can be preempted by the other jobs.
+//
+// The misconfiguration is returned, and reported by the health endpoint.
+func CheckPreemption(ctx context.Context) error {
+	slurm := newSlurm()
+	qos, err := slurm.ListQos(ctx)
+	if err != nil {
+		return err
+	}
+	// The mode of the cluster applies to the QoS with the "cluster" mode. It is reported as unverified if unknown.
+	clusterMode, err := slurm.ClusterPreemptMode(ctx)
+	if err != nil {
+		log.Printf("failed to read the preemption mode of the cluster: %s", err)
+	}
+	err = scheduler.CheckPreemption(qos, scheduler.QosName, clusterMode)
+	preemptionMu.Lock()
+	preemptionErr = err
+	preemptionMu.Unlock()
+	return err
+}
+
+func preemptionStatus() string {
+	preemptionMu.Lock()
+	defer preemptionMu.Unlock()
+	if preemptionErr == nil {
+		return ""
+	}
+	return preemptionErr.Error()
+}
+
+// Reconcile resubmits the mining jobs which are supposed to run but are gone, like after being preempted
+// with the cancel preemption mode, once the cluster has idle resources again.
+//
+// Only the missing jobs are resubmitted, the running ones being left alone. The requeued tasks are left to Slurm,
+// and nothing is resubmitted while the cluster is full.
+func Reconcile(ctx context.Context, s *autoswitch.Switcher) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if st := getState(); !st.desired || st.pauseErr != nil {
+		return nil
+	}
+
+	slurm := newSlurm()
+	placement := getPlacement()
+	var missing []string
+	for _, job := range []struct {
+		kind      string
+		placement scheduler.Placement
+		idle      func(c scheduler.Capacity) int
+	}{
+		{kind: jobstate.KindGPU, placement: placement.GPU, idle: func(c scheduler.Capacity) int { return c.IdleGPUs }},
+		{kind: jobstate.KindCPU, placement: placement.CPU, idle: func(c scheduler.Capacity) int { return c.IdleCPUs }},
+	} {
+		tasks, err := listTasks(ctx, slurm, job.kind)
+		if err != nil {
+			return err
+		}
+		if len(tasks) > 0 {
+			continue
+		}
+		capacity, err := slurm.FindCapacity(ctx, job.placement)
+		if err != nil {
+			return err
+		}
+		if job.idle(capacity) <= 0 {
+			log.Printf("job %s is gone, but the cluster is full: waiting to resubmit", jobName(job.kind))
+			continue
+		}
+		log.Printf("job %s is gone", jobName(job.kind))
+		missing = append(missing, job.kind)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	metrics.Resubmissions.Inc()
+	return resubmit(ctx, slurm, s, missing)
+}
+
+// resubmit submits the jobs of the missing kinds again, on the algorithm they were mining and with the replicas
+// they were last recorded with, like after a scale down. The caller holds jobsMu.
+func resubmit(ctx context.Context, slurm *scheduler.Slurm, s *autoswitch.Switcher, kinds []string) error {
+	st := getState()
+	best := autoswitch.Profit{Algo: st.pinnedAlgo}
+	if best.Algo == "" {
+		best.Algo = st.algo
+	}
+	if best.Algo == "" {
+		var err error
+		if best, err = s.GetBest(ctx); err != nil {
+			return err
+		}
+	}
+	data, err := newJobData(s, st.walletID, best)
+	if err != nil {
+		return err
+	}
+
+	// The cores of the CPU tasks are not recorded.
+	replicas, err := ComputeReplicas(slurm, ctx, st.usage/100)
+	if err != nil {
+		return err
+	}
+	if job, ok := jobStore.Get(jobstate.KindGPU); ok && job.Replicas > 0 {
+		replicas.replicasGPU = job.Replicas
+	}
+	if job, ok := jobStore.Get(jobstate.KindCPU); ok && job.Replicas > 0 {
+		replicas.maxNode = job.Replicas
+	}
+	if err := collector.CheckPorts(replicas.replicasGPU, replicas.maxNode); err != nil {
+		return err
+	}
+
+	for _, kind := range kinds {
+		log.Printf("resubmitting job %s", jobName(kind))
+		switch kind {
+		case jobstate.KindGPU:
+			out, endpoints, err := submitGPU(ctx, slurm, s, replicas.replicasGPU, data)
+			if err != nil {
+				return err
+			}
+			log.Printf("successfully resubmitted gpu job: %s", out)
+			setState(func(st *miningState) {
+				st.algo = data.algo
+				st.pool = data.pools[0].Name
+				st.coin = data.pools[0].Coins[data.algo]
+				st.gpuEndpoints = endpoints
+			})
+			metrics.ReplicasRequested.WithLabelValues("gpu").Set(float64(replicas.replicasGPU))
+		case jobstate.KindCPU:
+			out, endpoints, err := submitCPU(ctx, slurm, s, replicas.maxNode, replicas.replicasCPU, data)
+			if err != nil {
+				return err
+			}
+			log.Printf("successfully resubmitted cpu job: %s", out)
+			setState(func(st *miningState) { st.cpuEndpoints = endpoints })
+			metrics.ReplicasRequested.WithLabelValues("cpu").Set(float64(replicas.maxNode))
+		}
+	}
+	return nil
+}
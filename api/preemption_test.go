@@ -0,0 +1,119 @@
+//go:build unit
+
+package api_test
+
+import (
+	"context"
+
+	"github.com/squarefactory/miner-api/api"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/stretchr/testify/mock"
+)
+
+// scontrolNodes are the arguments listing the nodes.
+var scontrolNodes = []string{"scontrol", "show", "nodes", "--oneliner"}
+
+const (
+	// fullNodes are nodes with every CPU and GPU allocated.
+	fullNodes = "NodeName=cn1 CPUAlloc=16 CPUTot=16 State=ALLOCATED Partitions=gpu CfgTRES=cpu=16,gres/gpu=4 AllocTRES=cpu=16,gres/gpu=4\n"
+	// idleNodes are the same nodes without any job.
+	idleNodes = "NodeName=cn1 CPUAlloc=0 CPUTot=16 State=IDLE Partitions=gpu CfgTRES=cpu=16,gres/gpu=4 AllocTRES=\n"
+)
+
+// isSbatch matches the submissions of a job.
+func isSbatch(name string) interface{} {
+	return mock.MatchedBy(func(argv []string) bool {
+		return len(argv) > 1 && argv[0] == "sbatch" && argv[1] == "--job-name="+name
+	})
+}
+
+func (suite *APITestSuite) TestReconcileClusterFull() {
+	// Arrange
+	api.SetMining("BTCwallet", 100, "kawpow")
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-cpu-auto-mining"), nil).Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).Return(fullNodes, nil)
+	switcher := &autoswitch.Switcher{Config: &autoswitch.Config{}}
+
+	// Act
+	err := api.Reconcile(context.Background(), switcher)
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, user, isSbatch("lab-gpu-auto-mining"), mock.Anything)
+	suite.Empty(suite.jobs.List())
+}
+
+func (suite *APITestSuite) TestReconcileIdle() {
+	// Arrange
+	api.SetMining("BTCwallet", 100, "kawpow")
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-cpu-auto-mining"), nil).Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).Return(idleNodes, nil)
+	suite.executor.On("Run", mock.Anything, user, isSbatch("lab-gpu-auto-mining"), mock.Anything).
+		Return("500\n", nil).Once()
+	suite.executor.On("Run", mock.Anything, user, isSbatch("lab-cpu-auto-mining"), mock.Anything).
+		Return("501\n", nil).Once()
+	switcher := &autoswitch.Switcher{Config: &autoswitch.Config{}}
+
+	// Act
+	err := api.Reconcile(context.Background(), switcher)
+
+	// Assert
+	suite.NoError(err)
+	gpu, ok := suite.jobs.Get(jobstate.KindGPU)
+	suite.True(ok)
+	suite.Equal(500, gpu.ID)
+	suite.Equal(4, gpu.Replicas)
+	cpu, ok := suite.jobs.Get(jobstate.KindCPU)
+	suite.True(ok)
+	suite.Equal(501, cpu.ID)
+}
+
+func (suite *APITestSuite) TestReconcileOneMissing() {
+	// Arrange
+	api.SetMining("BTCwallet", 100, "kawpow")
+	suite.Require().NoError(suite.jobs.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 300, Name: "lab-gpu-auto-mining", Replicas: 2}))
+	suite.Require().NoError(suite.jobs.Put(jobstate.Job{Kind: jobstate.KindCPU, ID: 310, Name: "lab-cpu-auto-mining", Replicas: 1}))
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).
+		Return("300|1|301|RUNNING|cn1|None\n300|2|302|RUNNING|cn1|None\n", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-cpu-auto-mining"), nil).Return("", nil)
+	suite.executor.On("Run", mock.Anything, user, scontrolNodes, nil).Return(idleNodes, nil)
+	suite.executor.On("Run", mock.Anything, user, isSbatch("lab-cpu-auto-mining"), mock.Anything).
+		Return("501\n", nil).Once()
+	switcher := &autoswitch.Switcher{Config: &autoswitch.Config{}}
+
+	// Act
+	err := api.Reconcile(context.Background(), switcher)
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, user, isSbatch("lab-gpu-auto-mining"), mock.Anything)
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, user, []string{"scancel", "--me", "300"}, nil)
+	gpu, ok := suite.jobs.Get(jobstate.KindGPU)
+	suite.True(ok)
+	suite.Equal(300, gpu.ID)
+	suite.Equal(2, gpu.Replicas)
+	cpu, ok := suite.jobs.Get(jobstate.KindCPU)
+	suite.True(ok)
+	suite.Equal(501, cpu.ID)
+	suite.Equal(1, cpu.Replicas)
+}
+
+func (suite *APITestSuite) TestReconcileRunning() {
+	// Arrange
+	api.SetMining("BTCwallet", 100, "kawpow")
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-gpu-auto-mining"), nil).
+		Return("300|1|301|RUNNING|cn1|None\n", nil)
+	suite.executor.On("Run", mock.Anything, user, squeueTasks("lab-cpu-auto-mining"), nil).
+		Return("310|1|311|RUNNING|cn1|None\n", nil)
+	switcher := &autoswitch.Switcher{Config: &autoswitch.Config{}}
+
+	// Act
+	err := api.Reconcile(context.Background(), switcher)
+
+	// Assert
+	suite.NoError(err)
+	suite.executor.AssertNotCalled(suite.T(), "Run", mock.Anything, user, scontrolNodes, nil)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/report"
+)
+
+// ProfitReport returns the energy consumed by the mining jobs and the net profit.
+//
+// Query parameters: since and until (RFC3339 or 2006-01-02, default the last 30 days), format (json or csv, default json).
+func ProfitReport(w http.ResponseWriter, r *http.Request, generator *report.Generator) {
+	since, err := parseTime(r.URL.Query().Get("since"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid since: " + err.Error()})
+		return
+	}
+	until, err := parseTime(r.URL.Query().Get("until"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid until: " + err.Error()})
+		return
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if since.IsZero() {
+		since = until.AddDate(0, 0, -30)
+	}
+
+	rep, err := generator.Generate(r.Context(), since, until)
+	if err != nil {
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		render.JSON(w, r, rep)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="profit.csv"`)
+		if err := rep.WriteCSV(w); err != nil {
+			render.Status(r, http.StatusInternalServerError)
+			render.JSON(w, r, Error{Error: err.Error()})
+		}
+	default:
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: "invalid format: " + format})
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/calendar"
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// ListWindows returns the windows in which mining is allowed.
+func ListWindows(w http.ResponseWriter, r *http.Request, store *calendar.Store) {
+	render.JSON(w, r, store.List())
+}
+
+// PutWindow adds or replaces a window from a JSON body like
+// {"name": "weekend", "schedule": "* * * * 0,6", "usage": 80, "wallet": "...", "algo": "kawpow"}.
+func PutWindow(w http.ResponseWriter, r *http.Request, store *calendar.Store) {
+	var window calendar.Window
+	if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	if name := chi.URLParam(r, "name"); name != "" {
+		window.Name = name
+	}
+	if window.Algo != "" {
+		if _, ok := AlgoGminer[window.Algo]; !ok {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: "unknown algorithm " + window.Algo})
+			return
+		}
+	}
+
+	if err := store.Put(window); err != nil {
+		if errors.Is(err, calendar.ErrReadOnly) {
+			render.Status(r, http.StatusConflict)
+		} else {
+			render.Status(r, http.StatusBadRequest)
+		}
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, OK{"Window " + window.Name + " saved"})
+}
+
+// DeleteWindow removes a window added with the API.
+func DeleteWindow(w http.ResponseWriter, r *http.Request, store *calendar.Store) {
+	name := chi.URLParam(r, "name")
+	if err := store.Delete(name); err != nil {
+		switch {
+		case errors.Is(err, calendar.ErrNotFound):
+			render.Status(r, http.StatusNotFound)
+		case errors.Is(err, calendar.ErrReadOnly):
+			render.Status(r, http.StatusConflict)
+		default:
+			render.Status(r, http.StatusInternalServerError)
+		}
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, OK{"Window " + name + " deleted"})
+}
+
+// ApplySchedule starts, resizes or stops the mining jobs when the active window changes.
+//
+// Mining started or stopped manually is left as is until the next boundary of a window.
+func ApplySchedule(ctx context.Context, s *autoswitch.Switcher, store *calendar.Store) error {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	st := getState()
+	window, ok := store.Active(time.Now())
+	if !ok {
+		if st.window == nil {
+			return nil
+		}
+		log.Printf("window %s ended, stopping miners", st.window.Name)
+		if err := stopMining(ctx); err != nil {
+			return err
+		}
+		setState(func(st *miningState) { st.window = nil })
+		return nil
+	}
+	if st.window != nil && *st.window == window {
+		return nil
+	}
+
+	wallet := window.Wallet
+	if wallet == "" {
+		wallet = st.walletID
+	}
+	if wallet == "" {
+		return errors.New("window " + window.Name + " has no wallet")
+	}
+	log.Printf("window %s started, mining at %.0f%%", window.Name, window.Usage)
+	setState(func(st *miningState) {
+		st.walletID = wallet
+		st.usage = window.Usage
+		st.pinnedAlgo = window.Algo
+		st.desired = true
+	})
+	metrics.MiningDesired.Set(1)
+	if err := restartMiners(ctx, s); err != nil {
+		return err
+	}
+	setState(func(st *miningState) { st.window = &window })
+	return nil
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/calendar"
+)
+
+// miningState is the desired state of the mining jobs, and what they were last started with.
+type miningState struct {
+	desired      bool             // Indicates if the jobs are supposed to be running or not.
+	pauseErr     error            // Why the jobs are not running although they are desired.
+	walletID     string           // Wallet given to /start or by the active window.
+	usage        float64          // Percentage of the resources used by the jobs.
+	pinnedAlgo   string           // Algorithm of the GPU job set by the active window, instead of the most profitable one.
+	window       *calendar.Window // Window which started the mining jobs.
+	algo         string
+	pool         string
+	coin         string                // Coin mined by the GPU job on a coin pool.
+	gpuEndpoints []autoswitch.Endpoint // Pools given to the GPU miners, in their best region.
+	cpuEndpoints []autoswitch.Endpoint // Pools given to the CPU miners, in their best region.
+}
+
+var (
+	// jobsMu serializes the operations on the mining jobs, from the handlers and the background loops.
+	// Only these operations change the state, while holding it.
+	jobsMu sync.Mutex
+
+	stateMu sync.Mutex // Guards state, which is read by GetStatus during the operations.
+	state   miningState
+)
+
+// getState returns a copy of the state.
+func getState() miningState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state
+}
+
+// setState changes the state. The caller holds jobsMu.
+func setState(change func(s *miningState)) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	change(&state)
+}
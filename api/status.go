@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/metrics"
+	"github.com/squarefactory/miner-api/monitor"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+var collector = &monitor.Collector{
+	GminerBasePort: 10050,
+	XmrigBasePort:  10450,
+}
+
+var analyzer *monitor.LogAnalyzer
+
+// ConfigureMonitor sets the collector scraping the miners and the optional analyzer of their logs.
+//
+// It must be called after Configure: the logs are read with the configured executor, as the job user.
+func ConfigureMonitor(c *monitor.Collector, a *monitor.LogAnalyzer) {
+	collector = c
+	analyzer = a
+	if analyzer != nil && analyzer.Reader == nil {
+		analyzer.Reader = newSlurm()
+		analyzer.User = user
+	}
+}
+
+type Status struct {
+	Desired bool    `json:"desired"`
+	Running bool    `json:"running"`
+	Usage   float64 `json:"usage"`
+	Paused  string  `json:"paused,omitempty"`
+	Window  string  `json:"window,omitempty"`
+	Algo    string  `json:"algo,omitempty"`
+	Pool    string  `json:"pool,omitempty"`
+	Coin    string  `json:"coin,omitempty"`
+	// Endpoints are the pools given to the miners, the primary ones first, with their region and latency.
+	Endpoints []autoswitch.Endpoint `json:"endpoints,omitempty"`
+	// Preempted counts the tasks preempted and waiting to be requeued. They are not running.
+	Preempted int `json:"preempted"`
+	// Jobs are the jobs submitted by this instance, which are the only ones it cancels.
+	Jobs   []jobstate.Job       `json:"jobs"`
+	Tasks  []scheduler.Task     `json:"tasks"`
+	Miners []monitor.MinerStats `json:"miners"`
+	Health []monitor.TaskHealth `json:"health,omitempty"`
+}
+
+var (
+	tasksMu   sync.Mutex
+	lastTasks []scheduler.Task // Mining tasks listed by the last Refresh.
+)
+
+// GetStatus returns the desired and actual state of the mining jobs, and the statistics of the miners.
+func GetStatus(w http.ResponseWriter, r *http.Request) {
+	tasksMu.Lock()
+	tasks := lastTasks
+	tasksMu.Unlock()
+	st := getState()
+
+	status := Status{
+		Desired:   st.desired,
+		Running:   countRunning(tasks) > 0,
+		Preempted: countPreempted(tasks),
+		Usage:     st.usage,
+		Algo:      st.algo,
+		Pool:      st.pool,
+		Coin:      st.coin,
+		Endpoints: append(append([]autoswitch.Endpoint(nil), st.gpuEndpoints...), st.cpuEndpoints...),
+		Jobs:      jobStore.List(),
+		Tasks:     tasks,
+		Miners:    collector.Stats(),
+	}
+	if st.window != nil {
+		status.Window = st.window.Name
+	}
+	if st.pauseErr != nil {
+		status.Paused = st.pauseErr.Error()
+	}
+	if analyzer != nil {
+		status.Health = analyzer.Health()
+	}
+	render.JSON(w, r, status)
+}
+
+func countRunning(tasks []scheduler.Task) int {
+	running := 0
+	for _, t := range tasks {
+		if t.State == scheduler.TaskRunning {
+			running++
+		}
+	}
+	return running
+}
+
+func countPreempted(tasks []scheduler.Task) int {
+	preempted := 0
+	for _, t := range tasks {
+		if t.Preempted() {
+			preempted++
+		}
+	}
+	return preempted
+}
+
+// Refresh lists the mining tasks, scrapes the miners and updates the metrics.
+func Refresh(ctx context.Context) error {
+	slurm := newSlurm()
+
+	tasksByMiner := make(map[string][]scheduler.Task)
+	var tasks []scheduler.Task
+	for _, job := range []struct {
+		kind  string
+		miner string
+	}{
+		{kind: jobstate.KindGPU, miner: monitor.MinerGminer},
+		{kind: jobstate.KindCPU, miner: monitor.MinerXmrig},
+	} {
+		jobTasks, err := listTasks(ctx, slurm, job.kind)
+		if err != nil {
+			return err
+		}
+		metrics.ReplicasRunning.WithLabelValues(job.kind).Set(float64(countRunning(jobTasks)))
+		metrics.ReplicasPreempted.WithLabelValues(job.kind).Set(float64(countPreempted(jobTasks)))
+		tasksByMiner[job.miner] = jobTasks
+		tasks = append(tasks, jobTasks...)
+	}
+	tasksMu.Lock()
+	lastTasks = tasks
+	tasksMu.Unlock()
+	metrics.MiningActual.Set(metrics.BoolToFloat(countRunning(tasks) > 0))
+
+	collector.Collect(ctx, tasksByMiner)
+	if analyzer != nil {
+		analyzer.AnalyzeAll(ctx, tasksByMiner)
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package api
 
+import "github.com/squarefactory/miner-api/scheduler"
+
 type Error struct {
 	Error string `json:"error"`
 	Data  string `json:"data,omitempty"`
@@ -8,3 +10,11 @@ type Error struct {
 type OK struct {
 	Data string `json:"data"`
 }
+
+type HealthStatus struct {
+	Data      string                 `json:"data,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Scheduler scheduler.BreakerState `json:"scheduler"`
+	// Preemption is why the mining jobs may not be preempted by the other jobs.
+	Preemption string `json:"preemption,omitempty"`
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/wallet"
+)
+
+var walletBook *wallet.Book
+
+// ConfigureWallets sets the wallet book choosing the payout addresses of the pools.
+func ConfigureWallets(b *wallet.Book) {
+	walletBook = b
+}
+
+// payout returns the address and the worker name template of a miner mining algo on a pool: the wallet of the
+// book matching the pool and its coin, else the wallet of the coin in the pool config, else walletID.
+//
+// The template is empty unless the wallet of the book has one.
+func payout(p *autoswitch.Pool, algo, walletID string) (string, string) {
+	if walletBook != nil {
+		if w, ok := walletBook.Match(p.Name, p.Coin(algo)); ok {
+			return w.Address, w.Worker
+		}
+	}
+	return p.Wallet(algo, walletID), ""
+}
+
+// ListWallets returns the wallets of the book.
+func ListWallets(w http.ResponseWriter, r *http.Request, book *wallet.Book) {
+	render.JSON(w, r, book.List())
+}
+
+// GetWallet returns a wallet of the book.
+func GetWallet(w http.ResponseWriter, r *http.Request, book *wallet.Book) {
+	wal, err := book.Get(chi.URLParam(r, "name"))
+	if err != nil {
+		render.Status(r, http.StatusNotFound)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, wal)
+}
+
+// PutWallet adds or replaces a wallet from a JSON body like
+// {"coin": "RVN", "pool": "2miners-rvn", "address": "R...", "worker": "{{ .Cluster }}-{{ .Node }}-{{ .TaskID }}"}.
+func PutWallet(w http.ResponseWriter, r *http.Request, book *wallet.Book) {
+	var wal wallet.Wallet
+	if err := json.NewDecoder(r.Body).Decode(&wal); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	if name := chi.URLParam(r, "name"); name != "" {
+		wal.Name = name
+	}
+	if wal.Worker != "" {
+		if err := (autoswitch.Workers{Template: wal.Worker}).Validate(); err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, Error{Error: err.Error()})
+			return
+		}
+	}
+
+	if err := book.Put(wal); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, OK{"Wallet " + wal.Name + " saved"})
+}
+
+// DeleteWallet removes a wallet of the book.
+func DeleteWallet(w http.ResponseWriter, r *http.Request, book *wallet.Book) {
+	name := chi.URLParam(r, "name")
+	if err := book.Delete(name); err != nil {
+		if errors.Is(err, wallet.ErrNotFound) {
+			render.Status(r, http.StatusNotFound)
+		} else {
+			render.Status(r, http.StatusInternalServerError)
+		}
+		render.JSON(w, r, Error{Error: err.Error()})
+		return
+	}
+	render.JSON(w, r, OK{"Wallet " + name + " deleted"})
+}
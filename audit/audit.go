@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Record is an audit entry of a command executed against Slurm.
+type Record struct {
+	Time     time.Time     `json:"time"`
+	User     string        `json:"user"`
+	Argv     []string      `json:"argv"`
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exitCode"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Trigger is the API request or the tick which triggered the command.
+	Trigger string `json:"trigger,omitempty"`
+}
+
+type triggerKey struct{}
+
+// WithTrigger annotates ctx with what triggered the commands run with it.
+func WithTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, triggerKey{}, trigger)
+}
+
+// TriggerFromContext returns the trigger set by WithTrigger.
+func TriggerFromContext(ctx context.Context) string {
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	return trigger
+}
+
+// Log is an append-only JSONL file, rotated when it exceeds MaxBytes.
+type Log struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewLog opens or creates the audit log at path.
+//
+// The file is rotated to path.1, path.2, ... when it exceeds maxBytes, keeping at most maxBackups files.
+func NewLog(path string, maxBytes int64, maxBackups int) (*Log, error) {
+	l := &Log{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+// Write appends a record to the log.
+func (l *Log) Write(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	for i := l.maxBackups; i > 0; i-- {
+		src := l.backup(i - 1)
+		if err := os.Rename(src, l.backup(i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return l.open()
+}
+
+// backup returns the path of the i-th backup. The 0-th backup is the current file.
+func (l *Log) backup(i int) string {
+	if i == 0 {
+		return l.path
+	}
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Query filters the audit records.
+type Query struct {
+	Since   time.Time
+	Until   time.Time
+	User    string
+	Trigger string
+	// Limit keeps only the most recent records. Zero means unlimited.
+	Limit int
+}
+
+func (q *Query) match(rec *Record) bool {
+	if !q.Since.IsZero() && rec.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && rec.Time.After(q.Until) {
+		return false
+	}
+	if q.User != "" && rec.User != q.User {
+		return false
+	}
+	if q.Trigger != "" && rec.Trigger != q.Trigger {
+		return false
+	}
+	return true
+}
+
+// Query returns the records matching q, in chronological order.
+//
+// The files are read without blocking the writers: the records are only appended and the files renamed on rotation,
+// so a query racing with a rotation may at worst miss or repeat the records of the rotated file.
+func (l *Log) Query(q Query) ([]Record, error) {
+	var records ring
+	records.limit = q.Limit
+	for i := l.maxBackups; i >= 0; i-- {
+		f, err := os.Open(l.backup(i))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				continue
+			}
+			if q.match(&rec) {
+				records.push(rec)
+			}
+		}
+		err = scanner.Err()
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records.list(), nil
+}
+
+// ring keeps the last limit records pushed, or all of them if limit is zero.
+type ring struct {
+	limit   int
+	records []Record
+	next    int // Index of the oldest record once the ring is full.
+}
+
+func (r *ring) push(rec Record) {
+	if r.limit <= 0 || len(r.records) < r.limit {
+		r.records = append(r.records, rec)
+		return
+	}
+	r.records[r.next] = rec
+	r.next = (r.next + 1) % r.limit
+}
+
+// list returns the records in the order they were pushed.
+func (r *ring) list() []Record {
+	return append(append([]Record{}, r.records[r.next:]...), r.records[:r.next]...)
+}
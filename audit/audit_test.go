@@ -0,0 +1,171 @@
+//go:build unit
+
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/squarefactory/miner-api/audit"
+	"github.com/squarefactory/miner-api/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuditTestSuite struct {
+	suite.Suite
+	path string
+	log  *audit.Log
+}
+
+var start = time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func (suite *AuditTestSuite) open(maxBytes int64, maxBackups int) {
+	suite.path = filepath.Join(suite.T().TempDir(), "audit.jsonl")
+	var err error
+	suite.log, err = audit.NewLog(suite.path, maxBytes, maxBackups)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { _ = suite.log.Close() })
+}
+
+func (suite *AuditTestSuite) TestLogRotation() {
+	// Arrange
+	suite.open(300, 1)
+
+	// Act
+	for i := 0; i < 6; i++ {
+		suite.Require().NoError(suite.log.Write(audit.Record{
+			Time: start.Add(time.Duration(i) * time.Minute),
+			User: "miner",
+			Argv: []string{"squeue", fmt.Sprintf("--jobs=%d", i)},
+		}))
+	}
+	records, err := suite.log.Query(audit.Query{})
+	last, errLast := suite.log.Query(audit.Query{Limit: 2})
+
+	// Assert
+	suite.NoError(err)
+	suite.NoError(errLast)
+	suite.Equal(records[len(records)-2:], last)
+	suite.FileExists(suite.path + ".1")
+	suite.NoFileExists(suite.path + ".2")
+	info, err := os.Stat(suite.path)
+	suite.NoError(err)
+	suite.LessOrEqual(info.Size(), int64(300))
+	suite.NotEmpty(records)
+	suite.Less(len(records), 6)
+	for i, rec := range records {
+		// The oldest records were dropped with the second backup, and the others are in order.
+		suite.Equal(start.Add(time.Duration(6-len(records)+i)*time.Minute), rec.Time)
+	}
+}
+
+func (suite *AuditTestSuite) TestQuery() {
+	// Arrange
+	suite.open(0, 0)
+	for i, rec := range []audit.Record{
+		{User: "miner", Trigger: "tick:autoswitch"},
+		{User: "miner", Trigger: "api:POST /api/v1/start"},
+		{User: "root", Trigger: "tick:autoswitch"},
+		{User: "miner", Trigger: "tick:autoswitch"},
+	} {
+		rec.Time = start.Add(time.Duration(i) * time.Hour)
+		suite.Require().NoError(suite.log.Write(rec))
+	}
+
+	// Act
+	all, err1 := suite.log.Query(audit.Query{})
+	ticks, err2 := suite.log.Query(audit.Query{User: "miner", Trigger: "tick:autoswitch"})
+	window, err3 := suite.log.Query(audit.Query{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)})
+	last, err4 := suite.log.Query(audit.Query{Limit: 1})
+	recent, err5 := suite.log.Query(audit.Query{User: "miner", Limit: 2})
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.NoError(err3)
+	suite.NoError(err4)
+	suite.NoError(err5)
+	suite.Len(all, 4)
+	suite.Len(ticks, 2)
+	suite.Equal(start.Add(3*time.Hour), ticks[1].Time)
+	suite.Len(window, 2)
+	suite.Equal("api:POST /api/v1/start", window[0].Trigger)
+	suite.Equal("root", window[1].User)
+	suite.Len(last, 1)
+	suite.Equal(start.Add(3*time.Hour), last[0].Time)
+	suite.Require().Len(recent, 2)
+	suite.Equal(start.Add(time.Hour), recent[0].Time)
+	suite.Equal(start.Add(3*time.Hour), recent[1].Time)
+}
+
+// exitError is an error with an exit code, like *exec.ExitError.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+func (e exitError) ExitCode() int { return int(e) }
+
+func (suite *AuditTestSuite) TestExecutor() {
+	// Arrange
+	suite.open(0, 0)
+	next := mocks.NewExecutor(suite.T())
+	next.On("Run", mock.Anything, "miner", []string{"sbatch"}, nil).
+		Return("Submitted batch job 42", nil)
+	next.On("Run", mock.Anything, "miner", []string{"scancel", "42"}, nil).
+		Return("", fmt.Errorf("scancel: %w", exitError(3)))
+	exec := audit.NewExecutor(next, suite.log, 9)
+	ctx := audit.WithTrigger(context.Background(), "tick:reconcile")
+
+	// Act
+	out, err1 := exec.Run(ctx, "miner", []string{"sbatch"}, nil)
+	_, err2 := exec.Run(context.Background(), "miner", []string{"scancel", "42"}, nil)
+	records, err := suite.log.Query(audit.Query{})
+
+	// Assert
+	suite.NoError(err1)
+	suite.Equal("Submitted batch job 42", out)
+	suite.Error(err2)
+	suite.NoError(err)
+	suite.Require().Len(records, 2)
+	suite.Equal(0, records[0].ExitCode)
+	suite.Equal("Submitted...(truncated)", records[0].Output)
+	suite.Equal("tick:reconcile", records[0].Trigger)
+	suite.Equal([]string{"scancel", "42"}, records[1].Argv)
+	suite.Equal(3, records[1].ExitCode)
+	suite.Equal("scancel: exit status 3", records[1].Error)
+	suite.Empty(records[1].Trigger)
+}
+
+func (suite *AuditTestSuite) TestExitCode() {
+	suite.Equal(0, audit.ExitCode(nil))
+	suite.Equal(2, audit.ExitCode(exitError(2)))
+	suite.Equal(-1, audit.ExitCode(errors.New("connection refused")))
+}
+
+func (suite *AuditTestSuite) TestMiddleware() {
+	// Arrange
+	var trigger string
+	handler := middleware.RequestID(audit.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trigger = audit.TriggerFromContext(r.Context())
+	})))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/start?walletId=x", nil)
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert
+	suite.Regexp(`^api:POST /api/v1/start \(.+\)$`, trigger)
+}
+
+func TestAuditTestSuite(t *testing.T) {
+	suite.Run(t, new(AuditTestSuite))
+}
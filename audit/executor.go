@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// Executor records every command executed by the wrapped executor.
+type Executor struct {
+	next           scheduler.Executor
+	log            *Log
+	maxOutputBytes int
+}
+
+// NewExecutor wraps next. The output stored in the records is truncated to maxOutputBytes.
+func NewExecutor(next scheduler.Executor, log *Log, maxOutputBytes int) *Executor {
+	return &Executor{
+		next:           next,
+		log:            log,
+		maxOutputBytes: maxOutputBytes,
+	}
+}
+
+func (e *Executor) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	start := time.Now()
+	out, err := e.next.Run(ctx, user, argv, stdin)
+	e.record(ctx, start, user, argv, out, err)
+	return out, err
+}
+
+func (e *Executor) record(ctx context.Context, start time.Time, user string, argv []string, out string, err error) {
+	rec := Record{
+		Time:     start.UTC(),
+		User:     user,
+		Argv:     argv,
+		Duration: time.Since(start),
+		ExitCode: ExitCode(err),
+		Output:   truncate(out, e.maxOutputBytes),
+		Trigger:  TriggerFromContext(ctx),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := e.log.Write(rec); err != nil {
+		log.Printf("failed to write audit record: %s", err)
+	}
+}
+
+// ExitCode extracts the exit code of a command from its error. It returns 0 on success and -1 if unknown.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr interface{ ExitCode() int } // *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	var sshErr interface{ ExitStatus() int } // *ssh.ExitError
+	if errors.As(err, &sshErr) {
+		return sshErr.ExitStatus()
+	}
+	return -1
+}
+
+func truncate(s string, n int) string {
+	if n <= 0 || len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
@@ -0,0 +1,19 @@
+package audit
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// Middleware sets the audit trigger of the request context to the method, path and request ID of the request.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trigger := fmt.Sprintf("api:%s %s", r.Method, r.URL.Path)
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			trigger = fmt.Sprintf("%s (%s)", trigger, id)
+		}
+		next.ServeHTTP(w, r.WithContext(WithTrigger(r.Context(), trigger)))
+	})
+}
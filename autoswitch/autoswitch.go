@@ -1,16 +1,21 @@
 package autoswitch
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/squarefactory/miner-api/metrics"
 )
 
 // AlgoShortnames used to build the whattomine uri
@@ -52,17 +57,129 @@ var GpuShortnames = map[string]string{
 }
 
 type Config struct {
-	Gpus    map[string]int       `yaml:"gpus"`
-	Algos   map[string]Algorithm `yaml:"algos"`
-	General General              `yaml:"general"`
+	Gpus     map[string]int       `yaml:"gpus"`
+	Algos    map[string]Algorithm `yaml:"algos"`
+	General  General              `yaml:"general"`
+	Executor Executor             `yaml:"executor"`
+	Audit    Audit                `yaml:"audit"`
+	Retry    Retry                `yaml:"retry"`
+	Monitor  Monitor              `yaml:"monitor"`
+	NiceHash NiceHash             `yaml:"nicehash"`
+	Earnings Earnings             `yaml:"earnings"`
+	// Tariffs are the time-of-use electricity prices. The first matching tariff applies.
+	Tariffs []Tariff `yaml:"tariffs"`
+	Prices  Prices   `yaml:"prices"`
+	Heat    Heat     `yaml:"heat"`
+	// Schedules start and stop mining at the boundaries of their windows.
+	Schedules Schedules `yaml:"schedules"`
+	// Pools default to DefaultPools.
+	Pools     []Pool    `yaml:"pools"`
+	PoolProbe PoolProbe `yaml:"pool_probe"`
+	Wallets   Wallets   `yaml:"wallets"`
+	Workers   Workers   `yaml:"workers"`
+	Jobs      Jobs      `yaml:"jobs"`
+	Cancel    Cancel    `yaml:"cancel"`
+	Instance  Instance  `yaml:"instance"`
 }
 
+// WhatToMineURL is the whattomine API listing the coins.
+const WhatToMineURL = "https://whattomine.com/coins.json"
+
+// Decision reasons of the switcher.
+const (
+	ReasonInitial        = "initial"
+	ReasonMoreProfitable = "more_profitable"
+	ReasonUnchanged      = "unchanged"
+	ReasonUnprofitable   = "unprofitable"
+)
+
 type Switcher struct {
 	Config *Config
+	// URL overrides WhatToMineURL.
+	URL string
+	// Client is the HTTP client used to query the upstream APIs. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Rates converts the revenue to the currency of the electricity prices.
+	// The electricity cost is ignored if nil.
+	Rates RateSource
+	// RateTTL is the duration for which the exchange rate is cached. Defaults to DefaultRateTTL.
+	RateTTL time.Duration
+	// Prices overrides the tariffs with dynamic electricity prices.
+	Prices PriceSource
+	// Health excludes the unreachable pools.
+	Health PoolHealth
+
+	mu      sync.Mutex
+	current Profit
+
+	rateMu       sync.Mutex
+	rate         float64
+	rateCurrency string
+	rateAt       time.Time
+}
+
+// Coin is an entry of the whattomine coins API.
+type Coin struct {
+	Name             string `json:"-"`
+	Tag              string `json:"tag"`
+	Algorithm        string `json:"algorithm"`
+	ExchangeRate     Number `json:"exchange_rate"`
+	ExchangeRateCurr string `json:"exchange_rate_curr"`
+	EstimatedRewards Number `json:"estimated_rewards"`
+	BTCRevenue       Number `json:"btc_revenue"`
+	Profitability    Number `json:"profitability"`
+	Lagging          bool   `json:"lagging"`
+}
+
+// Number is a float which whattomine may encode either as a JSON number or as a string.
+type Number float64
+
+func (n *Number) UnmarshalJSON(b []byte) error {
+	str := strings.Trim(string(b), `"`)
+	str = strings.ReplaceAll(str, ",", "")
+	if str == "" || str == "null" {
+		*n = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return err
+	}
+	*n = Number(f)
+	return nil
+}
+
+// Profit is the estimated revenue of an algorithm.
+type Profit struct {
+	Algo string
+	Pool string
+	// Coin is the tag of the coin mined on a coin pool, empty on a NiceHash pool.
+	Coin string
+	// Rewards are the coins mined per day, after the pool fee.
+	Rewards float64
+	// ExchangeRate is the price of the coin in BTC.
+	ExchangeRate float64
+	// Revenue is in BTC per day.
+	Revenue float64
+	// Cost is the electricity cost at the current tariff, in BTC per day.
+	Cost float64
+	// Net is Revenue minus Cost.
+	Net float64
+}
+
+func (s *Switcher) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
 }
 
 func (s *Switcher) GetURI(c context.Context) (string, error) {
-	uri := "https://whattomine.com/coins?"
+	uri := s.URL
+	if uri == "" {
+		uri = WhatToMineURL
+	}
+	uri += "?"
 
 	for gpu, count := range s.Config.Gpus {
 		var gpuStr string
@@ -83,56 +200,231 @@ func (s *Switcher) GetURI(c context.Context) (string, error) {
 		uri = uri + algoStr
 	}
 
+	tariff := s.Tariff(c, time.Now())
 	costStr := "&factor%5Bcost%5D=" + fmt.Sprintf(
 		"%f",
-		s.Config.General.PowerCostPerKwh,
-	) + "&factor%5Bcost_currency%5D+USD&sort=Revenue&volume=0&revenue=24h&factor%5Bexchanges%5D%5B%5D=&factor%5Bexchanges%5D%5B%5D=binance&factor%5Bexchanges%5D%5B%5D=bitfinex&factor%5Bexchanges%5D%5B%5D=bitforex&factor%5Bexchanges%5D%5B%5D=bittrex&factor%5Bexchanges%5D%5B%5D=coinex&factor%5Bexchanges%5D%5B%5D=exmo&factor%5Bexchanges%5D%5B%5D=gate&factor%5Bexchanges%5D%5B%5D=graviex&factor%5Bexchanges%5D%5B%5D=hitbtc&factor%5Bexchanges%5D%5B%5D=ogre&factor%5Bexchanges%5D%5B%5D=poloniex&factor%5Bexchanges%5D%5B%5D=stex&dataset=Main&commit=Calculate"
+		tariff.PricePerKwh,
+	) + "&factor%5Bcost_currency%5D=" + url.QueryEscape(tariff.Currency) + "&sort=Revenue&volume=0&revenue=24h&factor%5Bexchanges%5D%5B%5D=&factor%5Bexchanges%5D%5B%5D=binance&factor%5Bexchanges%5D%5B%5D=bitfinex&factor%5Bexchanges%5D%5B%5D=bitforex&factor%5Bexchanges%5D%5B%5D=bittrex&factor%5Bexchanges%5D%5B%5D=coinex&factor%5Bexchanges%5D%5B%5D=exmo&factor%5Bexchanges%5D%5B%5D=gate&factor%5Bexchanges%5D%5B%5D=graviex&factor%5Bexchanges%5D%5B%5D=hitbtc&factor%5Bexchanges%5D%5B%5D=ogre&factor%5Bexchanges%5D%5B%5D=poloniex&factor%5Bexchanges%5D%5B%5D=stex&dataset=Main&commit=Calculate"
 	uri = uri + costStr
 
 	return uri, nil
 }
 
-func (s *Switcher) GetBestAlgo(c context.Context) (string, error) {
-	uri, err := s.GetURI(c)
+// FetchCoins queries whattomine for the coins minable with the configured GPUs.
+func (s *Switcher) FetchCoins(ctx context.Context) (coins []Coin, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveUpstream("whattomine", start, err) }()
+
+	uri, err := s.GetURI(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	resp, err := http.Get(uri)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client().Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("whattomine returned %s", resp.Status)
+	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+	var body struct {
+		Coins map[string]Coin `json:"coins"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
 	}
 
-	// write output to file
-	file, err := os.Create("output.txt")
+	coins = make([]Coin, 0, len(body.Coins))
+	for name, coin := range body.Coins {
+		coin.Name = name
+		coins = append(coins, coin)
+	}
+	return coins, nil
+}
+
+// Profitability returns the revenue of the configured algorithms on each pool, minus the pool fee and their
+// electricity cost at the current tariff, most profitable first.
+//
+// On a coin pool, the revenue is the one of the whattomine coin with the tag and the algorithm of the pool.
+func (s *Switcher) Profitability(ctx context.Context) ([]Profit, error) {
+	coins, err := s.FetchCoins(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer file.Close()
-	_, err = fmt.Fprint(file, doc.Text())
+	costs, err := s.powerCost(ctx, s.Tariff(ctx, time.Now()))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	nicehash := make(map[string]Coin)
+	type coinKey struct{ tag, algo string }
+	byCoin := make(map[coinKey]Coin)
+	for _, coin := range coins {
+		if algo, ok := nicehashAlgo(coin.Name); ok {
+			nicehash[algo] = coin
+			continue
+		}
+		key := coinKey{tag: coin.Tag, algo: CoinAlgo(coin.Algorithm)}
+		if _, ok := byCoin[key]; !ok {
+			byCoin[key] = coin
+		}
+	}
+
+	var profits []Profit
+	for _, pool := range s.Config.GetPools() {
+		if !s.Config.General.compares(&pool) {
+			continue
+		}
+		for algo := range s.Config.Algos {
+			if !pool.Supports(algo) || !s.healthy(&pool, algo) {
+				continue
+			}
+			var coin Coin
+			var ok bool
+			if pool.IsNiceHash() {
+				coin, ok = nicehash[algo]
+			} else {
+				coin, ok = byCoin[coinKey{tag: pool.Coins[algo], algo: algo}]
+			}
+			if !ok {
+				continue
+			}
+			share := 1 - pool.Fee/100
+			revenue := coin.Revenue() * share
+			profit := Profit{
+				Algo:    algo,
+				Pool:    pool.Name,
+				Revenue: revenue,
+				Cost:    costs[algo],
+				Net:     revenue - costs[algo],
+			}
+			if !pool.IsNiceHash() {
+				profit.Coin = coin.Tag
+				profit.Rewards = float64(coin.EstimatedRewards) * share
+				profit.ExchangeRate = float64(coin.ExchangeRate)
+			}
+			profits = append(profits, profit)
+			metrics.Profitability.WithLabelValues(algo, pool.Name).Set(revenue)
+		}
 	}
-	f, _ := os.Open("output.txt")
+	sort.SliceStable(profits, func(i, j int) bool {
+		if profits[i].Net != profits[j].Net {
+			return profits[i].Net > profits[j].Net
+		}
+		return profits[i].Algo+profits[i].Pool < profits[j].Algo+profits[j].Pool
+	})
+	return profits, nil
+}
 
-	// Scan file for 1st nicehash occurence.
-	scanner := bufio.NewScanner(f)
-	found := false
-	var line string
+// Revenue returns the BTC revenue of the coin per day, converting its rewards at its exchange rate if whattomine
+// does not provide it.
+func (c Coin) Revenue() float64 {
+	if c.BTCRevenue == 0 && (c.ExchangeRateCurr == "" || c.ExchangeRateCurr == "BTC") {
+		return float64(c.EstimatedRewards * c.ExchangeRate)
+	}
+	return float64(c.BTCRevenue)
+}
+
+// coinAlgos maps the whattomine algorithms to the configured algorithms whose names differ.
+var coinAlgos = map[string]string{
+	"beamhashiii":  "beamv3",
+	"equihash1254": "zelhash",
+	"equihash1445": "zhash",
+}
 
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), "Nicehash-") && !found {
-			found = true
-			line = scanner.Text()
+// CoinAlgo returns the configured algorithm of a whattomine algorithm, like "zelhash" for "Equihash (125,4)".
+func CoinAlgo(algorithm string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
 		}
+		return -1
+	}, algorithm)
+	if algo, ok := coinAlgos[name]; ok {
+		return algo
+	}
+	return name
+}
+
+// nicehashAlgo extracts the algorithm of a NiceHash coin named "Nicehash-<Algorithm>".
+func nicehashAlgo(name string) (string, bool) {
+	algo, ok := strings.CutPrefix(name, "Nicehash-")
+	if !ok {
+		return "", false
+	}
+	return strings.ToLower(algo), true
+}
+
+// GetBest returns the most profitable algorithm and its pool.
+//
+// It returns ErrUnprofitable if no algorithm covers its electricity cost.
+func (s *Switcher) GetBest(c context.Context) (Profit, error) {
+	profits, err := s.profitable(c)
+	if err != nil {
+		return Profit{}, err
+	}
+	best := profits[0]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reason := ReasonMoreProfitable
+	switch {
+	case s.current == (Profit{}):
+		reason = ReasonInitial
+	case s.current.same(best):
+		reason = ReasonUnchanged
+	}
+
+	if best.Coin != "" {
+		log.Printf("autoswitch: selected %s (%s) on %s (%s, %.4f %s/day at %.8f BTC, %.8f BTC/day net)",
+			best.Coin, best.Algo, best.Pool, reason, best.Rewards, best.Coin, best.ExchangeRate, best.Net)
+	} else {
+		log.Printf("autoswitch: selected %s on %s (%s, %.8f BTC/day net)", best.Algo, best.Pool, reason, best.Net)
+	}
+	metrics.SwitchDecisions.WithLabelValues(best.Algo, reason).Inc()
+	s.current = best
+	return best, nil
+}
+
+// same reports whether p and o mine the same coin with the same algorithm on the same pool.
+func (p Profit) same(o Profit) bool {
+	return p.Algo == o.Algo && p.Pool == o.Pool && p.Coin == o.Coin
+}
+
+// CheckProfitable returns ErrUnprofitable if mining must be paused at the current electricity price.
+func (s *Switcher) CheckProfitable(ctx context.Context) error {
+	_, err := s.profitable(ctx)
+	return err
+}
+
+// profitable returns the profitability of the algorithms, or ErrUnprofitable if the electricity price
+// is above General.MaxPricePerKwh or costs more than any algorithm earns.
+func (s *Switcher) profitable(ctx context.Context) ([]Profit, error) {
+	tariff := s.Tariff(ctx, time.Now())
+	if max := s.Config.General.MaxPricePerKwh; max > 0 && tariff.PricePerKwh > max {
+		log.Printf("autoswitch: %s (%s tariff at %.4f %s/kWh)", ErrUnprofitable, tariff.Name, tariff.PricePerKwh, tariff.Currency)
+		metrics.SwitchDecisions.WithLabelValues("", ReasonUnprofitable).Inc()
+		return nil, fmt.Errorf("%w: %.4f %s/kWh is above %.4f", ErrUnprofitable, tariff.PricePerKwh, tariff.Currency, max)
+	}
+
+	profits, err := s.Profitability(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(profits) == 0 {
+		return nil, errors.New("no profitable algorithm found")
+	}
+	if best := profits[0]; best.Net < 0 {
+		log.Printf("autoswitch: %s (best %s, %.8f BTC/day net)", ErrUnprofitable, best.Algo, best.Net)
+		metrics.SwitchDecisions.WithLabelValues(best.Algo, ReasonUnprofitable).Inc()
+		return nil, ErrUnprofitable
 	}
-	splittedLine := strings.Split(line, "-")
-	algo := strings.ToLower(strings.TrimSuffix(splittedLine[1], "<br>"))
-	return algo, nil
+	return profits, nil
 }
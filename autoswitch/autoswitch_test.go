@@ -0,0 +1,414 @@
+//go:build unit
+
+package autoswitch_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/cron"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/yaml.v3"
+)
+
+type SwitcherTestSuite struct {
+	suite.Suite
+	revenues map[string]string
+	server   *httptest.Server
+	impl     *autoswitch.Switcher
+}
+
+func (suite *SwitcherTestSuite) BeforeTest(suiteName, testName string) {
+	suite.revenues = map[string]string{
+		"Nicehash-KawPow":  "0.00002000",
+		"Nicehash-Octopus": "0.00001000",
+	}
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"coins":{
+  "Nicehash-KawPow":{"tag":"NICEHASH","algorithm":"KawPow","btc_revenue":"` + suite.revenues["Nicehash-KawPow"] + `","profitability":100},
+  "Nicehash-Octopus":{"tag":"NICEHASH","algorithm":"Octopus","btc_revenue":"` + suite.revenues["Nicehash-Octopus"] + `","profitability":50},
+  "Nicehash-Ethash":{"tag":"NICEHASH","algorithm":"Ethash","btc_revenue":"0.1","profitability":1000},
+  "Ravencoin":{"tag":"RVN","algorithm":"KawPow","exchange_rate":3.5e-7,"exchange_rate_curr":"BTC","estimated_rewards":"85.7","btc_revenue":"0.00003","profitability":120},
+  "Ergo":{"tag":"ERG","algorithm":"Autolykos","exchange_rate":0.00005,"exchange_rate_curr":"BTC","estimated_rewards":"0.5","profitability":90}
+}}`))
+	}))
+	suite.T().Cleanup(suite.server.Close)
+	suite.impl = &autoswitch.Switcher{
+		Config: &autoswitch.Config{
+			Algos: map[string]autoswitch.Algorithm{
+				"kawpow":  {HashRate: 83, Power: 450},
+				"octopus": {HashRate: 158, Power: 540},
+			},
+		},
+		URL: suite.server.URL,
+	}
+}
+
+func (suite *SwitcherTestSuite) TestProfitability() {
+	// Act
+	profits, err := suite.impl.Profitability(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]autoswitch.Profit{
+		{Algo: "kawpow", Pool: "nicehash", Revenue: 0.00002, Net: 0.00002},
+		{Algo: "octopus", Pool: "nicehash", Revenue: 0.00001, Net: 0.00001},
+	}, profits)
+}
+
+func (suite *SwitcherTestSuite) TestGetBestAlgo() {
+	ctx := context.Background()
+
+	// Act
+	first, err1 := suite.impl.GetBest(ctx)
+	suite.revenues["Nicehash-Octopus"] = "0.00002100"
+	second, err2 := suite.impl.GetBest(ctx)
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Equal("kawpow", first.Algo)
+	suite.Equal("octopus", second.Algo)
+}
+
+func (suite *SwitcherTestSuite) TestGetBestCoinPool() {
+	// Arrange
+	suite.impl.Config.Pools = append(autoswitch.DefaultPools, autoswitch.Pool{
+		Name:  "2miners-rvn",
+		URL:   "rvn.2miners.com:16060",
+		Coins: map[string]string{"kawpow": "RVN"},
+		TLS:   true,
+		Fee:   1,
+	})
+
+	// Act
+	best, err := suite.impl.GetBest(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("kawpow", best.Algo)
+	suite.Equal("2miners-rvn", best.Pool)
+	suite.InDelta(0.0000297, best.Revenue, 1e-12)
+}
+
+func (suite *SwitcherTestSuite) TestGetBestCoinMode() {
+	// Arrange
+	suite.impl.Config.General.Mode = autoswitch.ModeCoin
+	suite.impl.Config.Algos["autolykos"] = autoswitch.Algorithm{HashRate: 480, Power: 390}
+	suite.impl.Config.Pools = append(autoswitch.DefaultPools,
+		autoswitch.Pool{
+			Name:    "2miners-rvn",
+			URL:     "rvn.2miners.com:16060",
+			Coins:   map[string]string{"kawpow": "RVN"},
+			Wallets: map[string]string{"RVN": "RVNwallet"},
+		},
+		autoswitch.Pool{
+			Name:  "2miners-erg",
+			URL:   "erg.2miners.com:8888",
+			Coins: map[string]string{"autolykos": "ERG"},
+		},
+	)
+
+	// Act
+	profits, err := suite.impl.Profitability(context.Background())
+	best, errBest := suite.impl.GetBest(context.Background())
+	pool, errPool := suite.impl.Config.GetPool(best.Pool)
+	suite.Require().NoError(errPool)
+	endpoint, errEndpoint := pool.Endpoint(best.Algo, "", autoswitch.MinerGminer, pool.Wallet(best.Algo, "BTCwallet"), "worker")
+
+	// Assert
+	suite.NoError(err)
+	suite.NoError(errBest)
+	suite.NoError(errEndpoint)
+	// The NiceHash pool is not compared in coin mode.
+	suite.Len(profits, 2)
+	suite.Equal(autoswitch.Profit{
+		Algo:         "kawpow",
+		Pool:         "2miners-rvn",
+		Coin:         "RVN",
+		Rewards:      85.7,
+		ExchangeRate: 3.5e-7,
+		Revenue:      0.00003,
+		Net:          0.00003,
+	}, best)
+	// The revenue of ERG is converted at its exchange rate.
+	suite.Equal("ERG", profits[1].Coin)
+	suite.InDelta(0.000025, profits[1].Revenue, 1e-12)
+	suite.Equal("RVNwallet.worker", endpoint.User)
+}
+
+func (suite *SwitcherTestSuite) TestCoinAlgo() {
+	suite.Equal("kawpow", autoswitch.CoinAlgo("KawPow"))
+	suite.Equal("kheavyhash", autoswitch.CoinAlgo("KHeavyHash"))
+	suite.Equal("zelhash", autoswitch.CoinAlgo("Equihash (125,4)"))
+	suite.Equal("beamv3", autoswitch.CoinAlgo("BeamHashIII"))
+}
+
+type fakeHealth struct {
+	down      map[string]bool
+	latencies map[string]time.Duration
+}
+
+func (f fakeHealth) Healthy(address string) bool {
+	return !f.down[address]
+}
+
+func (f fakeHealth) Latency(address string) (time.Duration, bool) {
+	latency, ok := f.latencies[address]
+	return latency, ok
+}
+
+func (suite *SwitcherTestSuite) TestGetBestExcludesUnhealthyPools() {
+	// Arrange
+	suite.impl.Health = fakeHealth{down: map[string]bool{"kawpow.auto.nicehash.com:443": true}}
+
+	// Act
+	best, err := suite.impl.GetBest(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("octopus", best.Algo)
+	suite.Empty(suite.impl.Pools("kawpow"))
+	suite.Len(suite.impl.Pools("octopus"), 1)
+}
+
+func (suite *SwitcherTestSuite) TestRegion() {
+	// Arrange
+	pool := &autoswitch.Pool{
+		Name:    "regional",
+		URL:     "{{ .Algo }}.{{ .Region }}.example.com:3333",
+		Regions: []string{"eu", "us", "asia"},
+	}
+	suite.impl.Health = fakeHealth{
+		down: map[string]bool{"kawpow.eu.example.com:3333": true},
+		latencies: map[string]time.Duration{
+			"kawpow.us.example.com:3333":   80 * time.Millisecond,
+			"kawpow.asia.example.com:3333": 200 * time.Millisecond,
+		},
+	}
+
+	// Act
+	region, latency, ok := suite.impl.Region(pool, "kawpow")
+	endpoint, err := suite.impl.Endpoint(pool, "kawpow", autoswitch.MinerGminer, "wallet", "worker")
+
+	// Assert
+	suite.True(ok)
+	suite.Equal("us", region)
+	suite.Equal(80*time.Millisecond, latency)
+	suite.NoError(err)
+	suite.Equal("us", endpoint.Region)
+	suite.Equal("kawpow.us.example.com:3333", endpoint.Address)
+}
+
+func (suite *SwitcherTestSuite) TestEndpoint() {
+	// Arrange
+	pool := autoswitch.Pool{
+		Name:     "private",
+		URL:      "{{ .Algo }}.pool.example.com:3333",
+		URLs:     map[string]string{"randomx": "xmr.pool.example.com:3333"},
+		User:     "{{ .Wallet }}",
+		Password: "{{ .Worker }}",
+		Flags:    map[string][]string{autoswitch.MinerGminer: {"--intensity", "90"}},
+	}
+
+	// Act
+	gpu, errGPU := pool.Endpoint("kawpow", "", autoswitch.MinerGminer, "wallet", "worker")
+	cpu, errCPU := pool.Endpoint("randomx", "", autoswitch.MinerXmrig, "wallet", "worker")
+	_, errQuote := pool.Endpoint("kawpow", "", autoswitch.MinerGminer, "wal'let", "worker")
+
+	// Assert
+	suite.NoError(errGPU)
+	suite.NoError(errCPU)
+	suite.Equal(autoswitch.Endpoint{
+		Pool:     "private",
+		Address:  "kawpow.pool.example.com:3333",
+		User:     "wallet",
+		Password: "worker",
+		Flags:    []string{"--intensity", "90"},
+	}, gpu)
+	suite.Equal("xmr.pool.example.com:3333", cpu.Address)
+	suite.Empty(cpu.Flags)
+	suite.Error(errQuote)
+}
+
+func (suite *SwitcherTestSuite) TestWorker() {
+	// Arrange
+	pool := autoswitch.Pool{
+		Name:         "nicehash",
+		WorkerLimits: autoswitch.WorkerLimits{MaxLength: 15, Charset: "A-Za-z0-9"},
+	}
+	data := autoswitch.NewWorkerData("", "kawpow", false)
+
+	// Act
+	worker, err := pool.Worker("{{ .Cluster }}-{{ .Partition }}-{{ .TaskID }}{{ .Algo }}", data)
+	suite.Require().NoError(err)
+	cmd := exec.Command("bash", "-c", "echo "+worker)
+	cmd.Env = append(os.Environ(), "SLURM_CLUSTER_NAME=my-cluster", "SLURM_JOB_PARTITION=gpu_a100", "SLURM_ARRAY_TASK_ID=12")
+	out, errRun := cmd.Output()
+	_, errQuote := pool.Worker(`{{ .Node }}"`, data)
+	_, errField := pool.Worker("{{ .Rack }}", data)
+	_, errExpansion := pool.Worker("{{ .Node }}$(id)", data)
+	_, errPrinted := pool.Worker(`{{ printf "%c(id)" 36 }}`, data)
+	_, errRendered := pool.Worker("{{ .Algo }}", autoswitch.NewWorkerData("", "x;id", false))
+
+	// Assert
+	suite.NoError(errRun)
+	suite.Equal("myclustergpua10\n", string(out))
+	suite.Error(errQuote)
+	suite.Error(errField)
+	suite.Error(errExpansion)
+	suite.Error(errPrinted)
+	suite.Error(errRendered)
+	suite.Error(autoswitch.WorkerLimits{Charset: "*"}.Validate())
+}
+
+type fakeRates float64
+
+func (f fakeRates) ExchangeRate(ctx context.Context, from string, to string) (float64, error) {
+	return float64(f), nil
+}
+
+func (suite *SwitcherTestSuite) TestGetBestAlgoUnprofitable() {
+	ctx := context.Background()
+	// 1 BTC = 25000 USD: kawpow uses 10.8 kWh/day and earns 0.5 USD/day.
+	suite.impl.Rates = fakeRates(25000)
+	suite.impl.Config.Tariffs = []autoswitch.Tariff{
+		{Name: "peak", Schedule: *cron.MustParse("* * * * *"), PricePerKwh: 0.04},
+	}
+
+	// Act
+	profitable, err1 := suite.impl.GetBest(ctx)
+	suite.impl.Config.Tariffs[0].PricePerKwh = 0.5
+	_, err2 := suite.impl.GetBest(ctx)
+
+	// Assert
+	suite.NoError(err1)
+	suite.Equal("kawpow", profitable.Algo)
+	suite.ErrorIs(err2, autoswitch.ErrUnprofitable)
+}
+
+// countingRates counts the requests of the exchange rate, and fails once err is set.
+type countingRates struct {
+	calls int
+	err   error
+}
+
+func (r *countingRates) ExchangeRate(ctx context.Context, from string, to string) (float64, error) {
+	r.calls++
+	return 25000, r.err
+}
+
+func (suite *SwitcherTestSuite) TestExchangeRateCached() {
+	ctx := context.Background()
+	rates := &countingRates{}
+	suite.impl.Rates = rates
+	suite.impl.Config.Tariffs = []autoswitch.Tariff{
+		{Name: "peak", Schedule: *cron.MustParse("* * * * *"), PricePerKwh: 0.04},
+	}
+
+	// Act
+	_, err1 := suite.impl.GetBest(ctx)
+	_, err2 := suite.impl.GetBest(ctx)
+	callsCached := rates.calls
+	suite.impl.RateTTL = time.Nanosecond
+	rates.err = errors.New("rate limited")
+	stale, err3 := suite.impl.GetBest(ctx)
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Equal(1, callsCached)
+	suite.NoError(err3)
+	suite.Equal("kawpow", stale.Algo)
+	suite.Equal(2, rates.calls)
+}
+
+type fakePrices float64
+
+func (f fakePrices) PriceAt(ctx context.Context, t time.Time) (float64, bool, error) {
+	return float64(f), true, nil
+}
+
+func (suite *SwitcherTestSuite) TestGetBestAlgoPriceSpike() {
+	ctx := context.Background()
+	suite.impl.Config.General.MaxPricePerKwh = 0.4
+	suite.impl.Config.Prices.SurchargePerKwh = 0.1
+
+	// Act
+	suite.impl.Prices = fakePrices(0.2)
+	normal, err1 := suite.impl.GetBest(ctx)
+	suite.impl.Prices = fakePrices(0.35)
+	spike := suite.impl.Tariff(ctx, time.Now())
+	_, err2 := suite.impl.GetBest(ctx)
+
+	// Assert
+	suite.NoError(err1)
+	suite.Equal("kawpow", normal.Algo)
+	suite.Equal(autoswitch.SpotTariff, spike.Name)
+	suite.InDelta(0.45, spike.PricePerKwh, 1e-9)
+	suite.ErrorIs(err2, autoswitch.ErrUnprofitable)
+}
+
+func (suite *SwitcherTestSuite) TestTariffAt() {
+	// Arrange
+	var config autoswitch.Config
+	err := yaml.Unmarshal([]byte(`
+general:
+  power_cost_per_kwh: 0.13
+  currency: EUR
+tariffs:
+  - name: peak
+    schedule: '* 8-19 * * 1-5'
+    price_per_kwh: 0.22
+  - name: weekend
+    schedule: '* * * * 0,6'
+    price_per_kwh: 0.09
+`), &config)
+	suite.Require().NoError(err)
+
+	// Act
+	peak := config.TariffAt(time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local))
+	night := config.TariffAt(time.Date(2023, 5, 1, 22, 0, 0, 0, time.Local))
+	weekend := config.TariffAt(time.Date(2023, 5, 6, 12, 0, 0, 0, time.Local))
+
+	// Assert
+	suite.Equal(autoswitch.ActiveTariff{Name: "peak", PricePerKwh: 0.22, Currency: "EUR"}, peak)
+	suite.Equal(autoswitch.ActiveTariff{Name: autoswitch.DefaultTariff, PricePerKwh: 0.13, Currency: "EUR"}, night)
+	suite.Equal("weekend", weekend.Name)
+}
+
+func (suite *SwitcherTestSuite) TestTariffSpan() {
+	// Arrange
+	suite.impl.Config.General.PowerCostPerKwh = 0.13
+	suite.impl.Config.Tariffs = []autoswitch.Tariff{
+		{Name: "peak", Schedule: *cron.MustParse("* 8-19 * * 1-5"), PricePerKwh: 0.22},
+	}
+	monday := time.Date(2023, 5, 1, 0, 0, 0, 0, time.Local)
+	ctx := context.Background()
+
+	// Act
+	peak, peakEnd := suite.impl.TariffSpan(ctx, monday.Add(12*time.Hour), monday.AddDate(0, 0, 1))
+	night, nightEnd := suite.impl.TariffSpan(ctx, monday.Add(21*time.Hour), monday.AddDate(0, 0, 1))
+	suite.impl.Prices = fakePrices(0.2)
+	spot, spotEnd := suite.impl.TariffSpan(ctx, monday.Add(12*time.Hour+30*time.Minute), monday.AddDate(0, 0, 1))
+
+	// Assert
+	suite.Equal("peak", peak.Name)
+	suite.Equal(monday.Add(20*time.Hour), peakEnd)
+	suite.Equal(autoswitch.DefaultTariff, night.Name)
+	suite.Equal(monday.AddDate(0, 0, 1), nightEnd)
+	suite.Equal(autoswitch.SpotTariff, spot.Name)
+	suite.Equal(monday.Add(13*time.Hour), spotEnd)
+}
+
+func TestSwitcherTestSuite(t *testing.T) {
+	suite.Run(t, &SwitcherTestSuite{})
+}
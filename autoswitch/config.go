@@ -1,12 +1,295 @@
 package autoswitch
 
+import (
+	"time"
+
+	"github.com/squarefactory/miner-api/cron"
+)
+
 type Algorithm struct {
 	HashRate int `yaml:"hash-rate"`
 	Power    int `yaml:"power"`
 }
 
 type General struct {
-	PollingFrequency int     `yaml:"polling_frequency"`
-	PowerCostPerKwh  float64 `yaml:"power_cost_per_kwh"`
-	Threshold        float64 `yaml:"threshold"`
+	PollingFrequency int `yaml:"polling_frequency"`
+	// PowerCostPerKwh is the electricity price outside of the tariff windows.
+	PowerCostPerKwh float64 `yaml:"power_cost_per_kwh"`
+	// Currency of every electricity price, tariffs included, used to convert the revenue. Defaults to USD.
+	Currency string `yaml:"currency"`
+	// MaxPricePerKwh pauses mining while the electricity price is higher. Ignored if zero.
+	MaxPricePerKwh float64 `yaml:"max_price_per_kwh"`
+	// Mode restricts the pools compared by the switcher: "nicehash" for the NiceHash algorithms, "coin" for the
+	// coins of the coin pools, or "auto" (the default) for both.
+	Mode string `yaml:"mode"`
+}
+
+// Executor configures how the Slurm commands are executed.
+type Executor struct {
+	// Type is either "shell" to run the commands locally, or "ssh" to run them on a remote login node.
+	Type string `yaml:"type"`
+	// User is the UNIX account used to submit and manage the mining jobs.
+	User string `yaml:"user"`
+	// WorkDir is the working directory of the commands.
+	WorkDir string `yaml:"work_dir"`
+	// Path is the PATH given to the commands.
+	Path string `yaml:"path"`
+	// Timeout bounds the duration of each command.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxOutputBytes bounds the size of the output of each command.
+	MaxOutputBytes int `yaml:"max_output_bytes"`
+	// SSH is used when Type is "ssh".
+	SSH SSH `yaml:"ssh"`
+}
+
+// SSH configures the connection to a remote Slurm login node.
+type SSH struct {
+	Address         string        `yaml:"address"`
+	User            string        `yaml:"user"`
+	PrivateKeyPath  string        `yaml:"private_key"`
+	CertificatePath string        `yaml:"certificate"`
+	KnownHostsPath  string        `yaml:"known_hosts"`
+	DialTimeout     time.Duration `yaml:"dial_timeout"`
+}
+
+// Audit configures the audit log of the commands executed against Slurm.
+type Audit struct {
+	// Path of the JSONL audit log. The audit log is disabled if empty.
+	Path           string `yaml:"path"`
+	MaxSizeBytes   int64  `yaml:"max_size_bytes"`
+	MaxBackups     int    `yaml:"max_backups"`
+	MaxOutputBytes int    `yaml:"max_output_bytes"`
+}
+
+// Retry configures the retries of the transient Slurm failures.
+type Retry struct {
+	MaxAttempts    int            `yaml:"max_attempts"`
+	InitialBackoff time.Duration  `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration  `yaml:"max_backoff"`
+	Multiplier     float64        `yaml:"multiplier"`
+	Jitter         float64        `yaml:"jitter"`
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+}
+
+// CircuitBreaker marks the scheduler unhealthy after repeated failures.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures opening the breaker.
+	Threshold int `yaml:"threshold"`
+	// Cooldown is the duration during which the commands are rejected once the breaker is open.
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// Monitor configures the scraping of the miners.
+type Monitor struct {
+	// Interval between two scrapes.
+	Interval time.Duration `yaml:"interval"`
+	// GminerBasePort is the base port of the gminer API. Each task listens on base port + array task ID.
+	GminerBasePort int `yaml:"gminer_base_port"`
+	// XmrigBasePort is the base port of the xmrig API. Each task listens on base port + array task ID.
+	XmrigBasePort int `yaml:"xmrig_base_port"`
+	// XmrigAccessToken authenticates the requests to the xmrig API. A random token is generated if empty.
+	XmrigAccessToken string `yaml:"xmrig_access_token"`
+	// Logs configures the analysis of the miner logs.
+	Logs MonitorLogs `yaml:"logs"`
+}
+
+// MonitorLogs configures the analysis of the miner logs.
+type MonitorLogs struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxBytes is the number of bytes read from the end of each log.
+	MaxBytes int `yaml:"max_bytes"`
+	// RestartLoopThreshold is the number of restarts within the tailed log flagged as a restart loop.
+	RestartLoopThreshold int `yaml:"restart_loop_threshold"`
+}
+
+// NiceHash configures the access to the NiceHash account API.
+type NiceHash struct {
+	URL            string `yaml:"url"`
+	APIKey         string `yaml:"api_key"`
+	APISecret      string `yaml:"api_secret"`
+	OrganizationID string `yaml:"organization_id"`
+	// Wallet is the mining address of the organization.
+	Wallet string `yaml:"wallet"`
+}
+
+// Earnings configures the earnings ledger.
+type Earnings struct {
+	// Path of the directory storing the ledger. The ledger is disabled if empty.
+	Path string `yaml:"path"`
+	// Interval between two synchronizations with NiceHash.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Tariff is the price per kWh, in General.Currency, during the minutes matched by Schedule, like "* 8-19 * * 1-5".
+type Tariff struct {
+	Name        string        `yaml:"name"`
+	Schedule    cron.Schedule `yaml:"schedule"`
+	PricePerKwh float64       `yaml:"price_per_kwh"`
+}
+
+// Prices configures the feed of the hourly electricity prices, which overrides the tariffs when it covers the current hour.
+type Prices struct {
+	// URL of the feed. Path is used if empty.
+	URL string `yaml:"url"`
+	// Path of a local file holding the feed. The feed is disabled if both URL and Path are empty.
+	Path string `yaml:"path"`
+	// Format is either "json" or "csv".
+	Format string `yaml:"format"`
+	// ListField is the field of the JSON object holding the prices. The feed is an array if empty.
+	ListField string `yaml:"list_field"`
+	// TimeField is the field or column of the start time of each price. Defaults to "start".
+	TimeField string `yaml:"time_field"`
+	// PriceField is the field or column of the price. Defaults to "price".
+	PriceField string `yaml:"price_field"`
+	// Unit of the prices of the feed, either "kWh" or "MWh". Defaults to "kWh".
+	Unit string `yaml:"unit"`
+	// SurchargePerKwh is added to the prices of the feed, like the grid fees and taxes.
+	SurchargePerKwh float64 `yaml:"surcharge_per_kwh"`
+	// CacheTTL is the duration for which the feed is cached.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Interval between two checks pausing or resuming mining as the price changes.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Heat configures the heat-demand mode, in which the heat demand sets the usage of the mining jobs.
+type Heat struct {
+	// URL of the JSON document holding the temperature, like a thermostat or a Modbus/BACnet gateway.
+	// The mode is disabled if empty.
+	URL string `yaml:"url"`
+	// TemperatureField is the dot-separated path of the temperature in the document.
+	TemperatureField string `yaml:"temperature_field"`
+	// SetpointField is the path of the setpoint in the document. Setpoint is used if empty.
+	SetpointField string  `yaml:"setpoint_field"`
+	Setpoint      float64 `yaml:"setpoint"`
+	// Curve maps the heat demand (setpoint minus temperature) to the usage percentage.
+	Curve []HeatPoint `yaml:"curve"`
+	// MaxStep bounds the change of usage per interval, in percentage points. Unlimited if zero.
+	MaxStep float64 `yaml:"max_step"`
+	// MinChange ignores the smaller changes of usage, as each change restarts the jobs.
+	MinChange float64 `yaml:"min_change"`
+	// Interval between two readings of the heat demand.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// HeatPoint maps a heat demand to a usage percentage.
+type HeatPoint struct {
+	Demand float64 `yaml:"demand"`
+	Usage  float64 `yaml:"usage"`
+}
+
+// Schedules configures the windows in which mining is allowed.
+type Schedules struct {
+	// Path of the JSON file persisting the windows added with the API. They are kept in memory if empty.
+	Path string `yaml:"path"`
+	// Windows are matched in order, before the windows added with the API.
+	Windows []Window `yaml:"windows"`
+}
+
+// Window allows mining during the minutes matched by Schedule, like "* 20-23,0-6 * * 1-5" for the weeknights.
+type Window struct {
+	Name     string        `yaml:"name"`
+	Schedule cron.Schedule `yaml:"schedule"`
+	// Usage is the percentage of the cluster used while the window is active.
+	Usage float64 `yaml:"usage"`
+	// Wallet receives the rewards. The last wallet given to /start is used if empty.
+	Wallet string `yaml:"wallet"`
+	// Algo pins the algorithm of the GPU job instead of switching to the most profitable one.
+	Algo string `yaml:"algo"`
+}
+
+// Pool is a mining pool. The switcher mines the most profitable algorithm on the most profitable pool supporting it.
+type Pool struct {
+	Name string `yaml:"name"`
+	// URL is the template of the stratum address, like "{{ .Algo }}.auto.nicehash.com:443".
+	URL string `yaml:"url"`
+	// Regions of the pool, given to the templates as .Region, like "{{ .Algo }}.{{ .Region }}.example.com:3333".
+	// The healthy region with the lowest latency is used.
+	Regions []string `yaml:"regions"`
+	// URLs override URL per algorithm, like {"randomx": "randomxmonero.auto.nicehash.com:443"}.
+	URLs map[string]string `yaml:"urls"`
+	// Coins maps the algorithms mined on the pool to the tags of their whattomine coins, like {"kawpow": "RVN"}.
+	// If empty, the pool mines any algorithm with the revenue of the whattomine "Nicehash-<Algorithm>" entries.
+	Coins map[string]string `yaml:"coins"`
+	// Wallets are the addresses receiving the coins mined on the pool, by coin tag, like {"RVN": "R..."}.
+	// The coins without an address are paid to the wallet of the mining request.
+	Wallets map[string]string `yaml:"wallets"`
+	TLS     bool              `yaml:"tls"`
+	// User is the template of the login, like "{{ .Wallet }}.{{ .Worker }}" (the default).
+	User string `yaml:"user"`
+	// Password is a template like User, defaulting to "x".
+	Password string `yaml:"password"`
+	// Fee is the fee of the pool in percent, deducted from the revenue.
+	Fee float64 `yaml:"fee"`
+	// Flags are the extra arguments of each miner on the pool, like {"xmrig": ["--nicehash"]}.
+	Flags map[string][]string `yaml:"flags"`
+	// WorkerLimits are enforced on the worker names.
+	WorkerLimits WorkerLimits `yaml:"worker"`
+}
+
+// WorkerLimits restrict the worker names accepted by a pool.
+type WorkerLimits struct {
+	// MaxLength truncates the worker names. Unlimited if zero.
+	MaxLength int `yaml:"max_length"`
+	// Charset is the tr set of the characters kept in the worker names, like "A-Za-z0-9".
+	// Defaults to DefaultWorkerCharset.
+	Charset string `yaml:"charset"`
+}
+
+// Workers configures the names of the workers, shown by the pools.
+type Workers struct {
+	// Template of the names, with the .Cluster, .Partition, .Node, .GPU (model), .TaskID and .Algo fields.
+	// Defaults to DefaultWorkerTemplate.
+	Template string `yaml:"template"`
+	// Cluster overrides the name of the Slurm cluster.
+	Cluster string `yaml:"cluster"`
+}
+
+// PoolProbe configures the stratum handshakes checking the health of the pools.
+type PoolProbe struct {
+	// Interval between two probes. Defaults to 1m.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of each probe. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Wallets configures the wallet book, managed with the API.
+type Wallets struct {
+	// Path of the JSON file persisting the wallets. They are kept in memory if empty.
+	Path string `yaml:"path"`
+}
+
+// Jobs configures the placement of the mining jobs. It can be changed with the API until the next restart.
+type Jobs struct {
+	GPU Placement `yaml:"gpu"`
+	CPU Placement `yaml:"cpu"`
+}
+
+// Placement restricts the nodes running a mining job, and the account billed for it.
+// The options are given to sbatch, like --partition.
+type Placement struct {
+	Partition   string `yaml:"partition"`
+	Account     string `yaml:"account"`
+	Reservation string `yaml:"reservation"`
+	Constraint  string `yaml:"constraint"`
+	Exclude     string `yaml:"exclude"`
+	NodeList    string `yaml:"nodelist"`
+	// Time is the time limit of the job, like "1-00:00:00".
+	Time string `yaml:"time"`
+	Nice int    `yaml:"nice"`
+}
+
+// Cancel configures how the mining jobs are stopped.
+type Cancel struct {
+	// Signal is sent to the miners first, like "TERM" (the default). "none" cancels the jobs right away.
+	Signal string `yaml:"signal"`
+	// GracePeriod is how long the miners have to stop after Signal, before they are killed. Defaults to 30s.
+	GracePeriod time.Duration `yaml:"grace_period"`
+}
+
+// Instance tells the mining jobs of this service apart from the ones of other instances on the same cluster.
+type Instance struct {
+	// Name prefixes the names of the mining jobs and tags their comments, like "lab" for "lab-gpu-auto-mining".
+	Name string `yaml:"name"`
+	// StatePath is the JSON file persisting the IDs of the submitted jobs. They are kept in memory if empty.
+	StatePath string `yaml:"state_path"`
 }
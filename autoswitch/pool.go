@@ -0,0 +1,301 @@
+package autoswitch
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Miners using the pools.
+const (
+	MinerGminer = "gminer"
+	MinerXmrig  = "xmrig"
+)
+
+// Modes of the switcher.
+const (
+	ModeAuto     = "auto"
+	ModeNiceHash = "nicehash"
+	ModeCoin     = "coin"
+)
+
+// CPUAlgo is the algorithm of the CPU job.
+const CPUAlgo = "randomx"
+
+// DefaultPools mine on the NiceHash auto stratum.
+var DefaultPools = []Pool{
+	{
+		Name: "nicehash",
+		URL:  "{{ .Algo }}.auto.nicehash.com:443",
+		URLs: map[string]string{
+			CPUAlgo: "randomxmonero.auto.nicehash.com:443",
+		},
+		TLS: true,
+		Flags: map[string][]string{
+			MinerXmrig: {"--nicehash"},
+		},
+	},
+}
+
+// Endpoint is the address and the credentials of a pool, given to a miner.
+type Endpoint struct {
+	Pool     string   `json:"pool"`
+	Region   string   `json:"region,omitempty"`
+	Address  string   `json:"address"`
+	TLS      bool     `json:"tls"`
+	User     string   `json:"user"`
+	Password string   `json:"password"`
+	Flags    []string `json:"flags,omitempty"`
+	// Latency of the last stratum probe of the address, if known.
+	Latency time.Duration `json:"latency,omitempty"`
+}
+
+// GetPools returns the configured pools, or DefaultPools.
+func (c *Config) GetPools() []Pool {
+	if len(c.Pools) == 0 {
+		return DefaultPools
+	}
+	return c.Pools
+}
+
+// GetPool returns the pool named name.
+func (c *Config) GetPool(name string) (*Pool, error) {
+	pools := c.GetPools()
+	for i := range pools {
+		if pools[i].Name == name {
+			return &pools[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown pool %s", name)
+}
+
+// FindPool returns the first pool supporting algo.
+func (c *Config) FindPool(algo string) (*Pool, error) {
+	pools := c.GetPools()
+	for i := range pools {
+		if pools[i].Supports(algo) {
+			return &pools[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no pool supports %s", algo)
+}
+
+// ValidateMode returns an error if the mode is unknown.
+func (g General) ValidateMode() error {
+	switch g.Mode {
+	case "", ModeAuto, ModeNiceHash, ModeCoin:
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q", g.Mode)
+	}
+}
+
+// compares reports whether the switcher compares the pool in the mode.
+func (g General) compares(p *Pool) bool {
+	switch g.Mode {
+	case ModeNiceHash:
+		return p.IsNiceHash()
+	case ModeCoin:
+		return !p.IsNiceHash()
+	default:
+		return true
+	}
+}
+
+// IsNiceHash reports whether the revenue of the pool is the one of the NiceHash algorithms.
+func (p *Pool) IsNiceHash() bool {
+	return len(p.Coins) == 0
+}
+
+// Supports reports whether the pool mines algo: the algorithm has an address, or any with a NiceHash pool.
+func (p *Pool) Supports(algo string) bool {
+	if _, ok := p.URLs[algo]; ok {
+		return true
+	}
+	if p.IsNiceHash() {
+		return p.URL != ""
+	}
+	_, ok := p.Coins[algo]
+	return ok && p.URL != ""
+}
+
+// PoolHealth reports whether the pool addresses are reachable, and their latency.
+type PoolHealth interface {
+	Healthy(address string) bool
+	Latency(address string) (time.Duration, bool)
+}
+
+// GetRegions returns the regions of the pool, or a single unnamed region.
+func (p *Pool) GetRegions() []string {
+	if len(p.Regions) == 0 {
+		return []string{""}
+	}
+	return p.Regions
+}
+
+// Address renders the stratum address of the pool for algo in a region.
+func (p *Pool) Address(algo, region string) (string, error) {
+	if !p.Supports(algo) {
+		return "", fmt.Errorf("pool %s does not support %s", p.Name, algo)
+	}
+	url, ok := p.URLs[algo]
+	if !ok {
+		url = p.URL
+	}
+	address, err := render(url, p.templateData(algo, region, "", ""))
+	if err != nil {
+		return "", fmt.Errorf("pool %s: %w", p.Name, err)
+	}
+	return address, nil
+}
+
+// Pools returns the healthy pools supporting algo, in the order of the config.
+func (s *Switcher) Pools(algo string) []*Pool {
+	var healthy []*Pool
+	pools := s.Config.GetPools()
+	for i := range pools {
+		if _, _, ok := s.Region(&pools[i], algo); ok && pools[i].Supports(algo) {
+			healthy = append(healthy, &pools[i])
+		}
+	}
+	return healthy
+}
+
+// healthy reports whether a region of the pool is reachable for algo.
+func (s *Switcher) healthy(p *Pool, algo string) bool {
+	_, _, ok := s.Region(p, algo)
+	return ok
+}
+
+// Region returns the healthy region of the pool with the lowest latency for algo, and its latency.
+//
+// Without PoolHealth, or before the regions are probed, the first region is returned.
+// It returns false if every region is unreachable.
+func (s *Switcher) Region(p *Pool, algo string) (string, time.Duration, bool) {
+	regions := p.GetRegions()
+	if s.Health == nil {
+		return regions[0], 0, true
+	}
+	best, found := "", false
+	var bestLatency time.Duration
+	for _, region := range regions {
+		address, err := p.Address(algo, region)
+		if err != nil || !s.Health.Healthy(address) {
+			continue
+		}
+		latency, measured := s.Health.Latency(address)
+		switch {
+		case !found:
+			best, bestLatency, found = region, latency, true
+		case measured && (bestLatency == 0 || latency < bestLatency):
+			best, bestLatency = region, latency
+		}
+	}
+	return best, bestLatency, found
+}
+
+// Endpoint renders the endpoint of the pool in its best region.
+func (s *Switcher) Endpoint(p *Pool, algo, miner, wallet, worker string) (Endpoint, error) {
+	region, latency, ok := s.Region(p, algo)
+	if !ok {
+		// Every region is down: let the miner retry the first one.
+		region = p.GetRegions()[0]
+	}
+	e, err := p.Endpoint(algo, region, miner, wallet, worker)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	e.Latency = latency
+	return e, nil
+}
+
+type templateData struct {
+	Algo   string
+	Coin   string
+	Region string
+	Wallet string
+	Worker string
+}
+
+func (p *Pool) templateData(algo, region, wallet, worker string) templateData {
+	return templateData{
+		Algo:   algo,
+		Coin:   p.Coins[algo],
+		Region: region,
+		Wallet: wallet,
+		Worker: worker,
+	}
+}
+
+// NiceHashCoin is the coin paid by the NiceHash pools.
+const NiceHashCoin = "BTC"
+
+// Coin returns the tag of the coin paid for mining algo on the pool.
+func (p *Pool) Coin(algo string) string {
+	if p.IsNiceHash() {
+		return NiceHashCoin
+	}
+	return p.Coins[algo]
+}
+
+// Wallet returns the address receiving the coin mined with algo on the pool, or wallet if none is configured.
+func (p *Pool) Wallet(algo, wallet string) string {
+	if address, ok := p.Wallets[p.Coins[algo]]; ok && address != "" {
+		return address
+	}
+	return wallet
+}
+
+// Endpoint renders the address and the credentials of the pool for a miner mining algo in a region.
+//
+// Worker identifies the miner, and may contain shell expansions evaluated by the job.
+func (p *Pool) Endpoint(algo, region, miner, wallet, worker string) (Endpoint, error) {
+	address, err := p.Address(algo, region)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	user := p.User
+	if user == "" {
+		user = "{{ .Wallet }}.{{ .Worker }}"
+	}
+	password := p.Password
+	if password == "" {
+		password = "x"
+	}
+
+	data := p.templateData(algo, region, wallet, worker)
+	e := Endpoint{
+		Pool:    p.Name,
+		Region:  region,
+		Address: address,
+		TLS:     p.TLS,
+		Flags:   p.Flags[miner],
+	}
+	if e.User, err = render(user, data); err != nil {
+		return Endpoint{}, fmt.Errorf("pool %s: %w", p.Name, err)
+	}
+	if e.Password, err = render(password, data); err != nil {
+		return Endpoint{}, fmt.Errorf("pool %s: %w", p.Name, err)
+	}
+	// The endpoint is given to the miner inside a single-quoted "bash -c" command.
+	for _, v := range append([]string{e.Address, e.User, e.Password}, e.Flags...) {
+		if strings.ContainsRune(v, '\'') {
+			return Endpoint{}, fmt.Errorf("pool %s: quote in %q", p.Name, v)
+		}
+	}
+	return e, nil
+}
+
+func render(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,197 @@
+package autoswitch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/squarefactory/miner-api/cron"
+)
+
+// DefaultCurrency is the currency of the electricity prices if General.Currency is empty.
+const DefaultCurrency = "USD"
+
+// DefaultTariff is the name of the tariff applying outside of the configured windows.
+const DefaultTariff = "default"
+
+// SpotTariff is the name of the tariff of the prices given by the PriceSource.
+const SpotTariff = "spot"
+
+// DefaultRateTTL is the duration for which the BTC exchange rate is cached if Switcher.RateTTL is zero.
+const DefaultRateTTL = 10 * time.Minute
+
+// ErrUnprofitable is returned by GetBest when the electricity costs more than any algorithm earns.
+var ErrUnprofitable = errors.New("mining is not profitable at the current electricity price")
+
+// RateSource converts the revenue in BTC to the currency of the electricity prices.
+type RateSource interface {
+	ExchangeRate(ctx context.Context, from string, to string) (float64, error)
+}
+
+// PriceSource provides the dynamic electricity prices per kWh, like the hourly prices of the day-ahead market.
+type PriceSource interface {
+	// PriceAt returns the price at t, and false if the source does not cover t.
+	PriceAt(ctx context.Context, t time.Time) (float64, bool, error)
+}
+
+// PriceSpanSource is a PriceSource which also tells when the price at a given time ends.
+type PriceSpanSource interface {
+	PriceSource
+	// PriceSpan is PriceAt also returning the end of the price covering t.
+	PriceSpan(ctx context.Context, t time.Time) (float64, time.Time, bool, error)
+}
+
+// ActiveTariff is the electricity price applying at a given time.
+type ActiveTariff struct {
+	Name        string  `json:"name"`
+	PricePerKwh float64 `json:"pricePerKwh"`
+	Currency    string  `json:"currency"`
+}
+
+// Currency returns the currency of the electricity prices.
+func (c *Config) Currency() string {
+	if c.General.Currency == "" {
+		return DefaultCurrency
+	}
+	return c.General.Currency
+}
+
+// TariffAt returns the tariff applying at t: the first matching tariff, or General.PowerCostPerKwh.
+func (c *Config) TariffAt(t time.Time) ActiveTariff {
+	for _, tariff := range c.Tariffs {
+		if tariff.Schedule.Match(t) {
+			return ActiveTariff{
+				Name:        tariff.Name,
+				PricePerKwh: tariff.PricePerKwh,
+				Currency:    c.Currency(),
+			}
+		}
+	}
+	return ActiveTariff{
+		Name:        DefaultTariff,
+		PricePerKwh: c.General.PowerCostPerKwh,
+		Currency:    c.Currency(),
+	}
+}
+
+// TariffSpan returns the tariff applying at t, and until when it applies, at most until limit.
+func (c *Config) TariffSpan(t, limit time.Time) (ActiveTariff, time.Time) {
+	until := limit
+	for _, tariff := range c.Tariffs {
+		if next, _ := tariff.Schedule.NextBefore(t, until); !next.IsZero() {
+			until = next
+		}
+	}
+	return c.TariffAt(t), until
+}
+
+// PricePerKwh returns the electricity price at t.
+func (c *Config) PricePerKwh(t time.Time) float64 {
+	return c.TariffAt(t).PricePerKwh
+}
+
+// Tariff returns the electricity price at t: the price of the PriceSource plus Prices.SurchargePerKwh,
+// or the configured tariff if the source does not cover t.
+func (s *Switcher) Tariff(ctx context.Context, t time.Time) ActiveTariff {
+	if s.Prices != nil {
+		price, ok, err := s.Prices.PriceAt(ctx, t)
+		if err != nil {
+			log.Printf("failed to fetch the electricity prices, using the tariffs: %s", err)
+		}
+		if ok {
+			return s.spot(price)
+		}
+	}
+	return s.Config.TariffAt(t)
+}
+
+// TariffSpan is Tariff also returning until when the price applies, at most until limit.
+//
+// The spot prices are assumed to change every hour if the source does not tell when they end,
+// and to start covering the times after the configured tariffs at the next hour.
+func (s *Switcher) TariffSpan(ctx context.Context, t, limit time.Time) (ActiveTariff, time.Time) {
+	if s.Prices == nil {
+		return s.Config.TariffSpan(t, limit)
+	}
+	hour := cron.NextHour(t)
+	if hour.After(limit) {
+		hour = limit
+	}
+	if spans, ok := s.Prices.(PriceSpanSource); ok {
+		price, end, ok, err := spans.PriceSpan(ctx, t)
+		if err != nil {
+			log.Printf("failed to fetch the electricity prices, using the tariffs: %s", err)
+		}
+		if ok {
+			if end.After(limit) {
+				end = limit
+			}
+			return s.spot(price), end
+		}
+		return s.Config.TariffSpan(t, hour)
+	}
+	return s.Tariff(ctx, t), hour
+}
+
+func (s *Switcher) spot(price float64) ActiveTariff {
+	return ActiveTariff{
+		Name:        SpotTariff,
+		PricePerKwh: price + s.Config.Prices.SurchargePerKwh,
+		Currency:    s.Config.Currency(),
+	}
+}
+
+// powerCost returns the daily electricity cost in BTC of each algorithm at the given tariff.
+//
+// The cost is unknown, and zero, if no RateSource is configured.
+func (s *Switcher) powerCost(ctx context.Context, tariff ActiveTariff) (map[string]float64, error) {
+	costs := make(map[string]float64, len(s.Config.Algos))
+	if tariff.PricePerKwh == 0 {
+		return costs, nil
+	}
+	rate := 1.0
+	if tariff.Currency != "BTC" {
+		if s.Rates == nil {
+			return costs, nil
+		}
+		var err error
+		if rate, err = s.exchangeRate(ctx, tariff.Currency); err != nil {
+			return nil, err
+		}
+	}
+	for algo, a := range s.Config.Algos {
+		kwhPerDay := float64(a.Power) * 24 / 1000
+		costs[algo] = kwhPerDay * tariff.PricePerKwh / rate
+	}
+	return costs, nil
+}
+
+// exchangeRate returns the BTC exchange rate to currency, cached for RateTTL. The cached rate is kept if the
+// refresh fails.
+func (s *Switcher) exchangeRate(ctx context.Context, currency string) (float64, error) {
+	s.rateMu.Lock()
+	defer s.rateMu.Unlock()
+
+	ttl := s.RateTTL
+	if ttl == 0 {
+		ttl = DefaultRateTTL
+	}
+	cached := s.rateCurrency == currency && s.rate > 0
+	if cached && time.Since(s.rateAt) < ttl {
+		return s.rate, nil
+	}
+	rate, err := s.Rates.ExchangeRate(ctx, "BTC", currency)
+	if err == nil && rate <= 0 {
+		err = errors.New("invalid BTC exchange rate")
+	}
+	if err != nil {
+		if cached {
+			log.Printf("using the cached BTC exchange rate: %s", err)
+			return s.rate, nil
+		}
+		return 0, err
+	}
+	s.rate, s.rateCurrency, s.rateAt = rate, currency, time.Now()
+	return rate, nil
+}
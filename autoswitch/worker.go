@@ -0,0 +1,131 @@
+package autoswitch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/squarefactory/miner-api/wallet"
+)
+
+// DefaultWorkerTemplate names the workers after the node and the array task.
+const DefaultWorkerTemplate = "{{ .Node }}-{{ .TaskID }}"
+
+// DefaultWorkerCharset are the characters allowed in the worker names by default.
+const DefaultWorkerCharset = "A-Za-z0-9_-"
+
+// Shell expansions of the worker name fields, evaluated by each task of the mining jobs.
+const (
+	clusterExpansion   = "$SLURM_CLUSTER_NAME"
+	partitionExpansion = "$SLURM_JOB_PARTITION"
+	nodeExpansion      = "$(hostname -s)"
+	taskIDExpansion    = "$SLURM_ARRAY_TASK_ID"
+	gpuExpansion       = "$(nvidia-smi --query-gpu=name --format=csv,noheader 2>/dev/null | head -n 1)"
+)
+
+// WorkerData are the fields of the worker name templates.
+//
+// Except Algo, they are shell expansions evaluated by the job, not their values.
+type WorkerData struct {
+	Cluster   string
+	Partition string
+	Node      string
+	// GPU is the model of the first GPU of the task, empty on the CPU job.
+	GPU    string
+	TaskID string
+	Algo   string
+}
+
+// NewWorkerData returns the fields of the worker names of a job mining algo.
+//
+// The cluster is the one of Slurm if empty.
+func NewWorkerData(cluster, algo string, gpu bool) WorkerData {
+	if cluster == "" {
+		cluster = clusterExpansion
+	}
+	data := WorkerData{
+		Cluster:   cluster,
+		Partition: partitionExpansion,
+		Node:      nodeExpansion,
+		TaskID:    taskIDExpansion,
+		Algo:      algo,
+	}
+	if gpu {
+		data.GPU = gpuExpansion
+	}
+	return data
+}
+
+// GetTemplate returns the worker name template, or DefaultWorkerTemplate.
+func (w Workers) GetTemplate() string {
+	if w.Template == "" {
+		return DefaultWorkerTemplate
+	}
+	return w.Template
+}
+
+// Validate checks the worker name template.
+func (w Workers) Validate() error {
+	_, err := renderWorker(w.GetTemplate(), NewWorkerData("", "", true))
+	return err
+}
+
+// expansions are the shell expansions which the worker names may hold, the other shell syntax being rejected.
+var expansions = []string{clusterExpansion, partitionExpansion, nodeExpansion, taskIDExpansion, gpuExpansion}
+
+// renderWorker renders the worker name template, and checks that the name holds no shell syntax besides the
+// expansions of the fields. The rendered name is checked, since the template functions can produce any character.
+func renderWorker(template string, data WorkerData) (string, error) {
+	if err := wallet.ValidateWorker(template); err != nil {
+		return "", err
+	}
+	name, err := render(template, data)
+	if err != nil {
+		return "", err
+	}
+	rest := name
+	for _, e := range expansions {
+		rest = strings.ReplaceAll(rest, e, "")
+	}
+	if err := wallet.ValidateWorker(rest); err != nil {
+		return "", fmt.Errorf("invalid worker %q", name)
+	}
+	return name, nil
+}
+
+// charsetPattern matches the tr character sets which are safe to give unquoted to the shell.
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9_.][A-Za-z0-9_.-]*$`)
+
+// Validate checks the limits of the worker names.
+func (l WorkerLimits) Validate() error {
+	if l.MaxLength < 0 {
+		return errors.New("negative worker max_length")
+	}
+	if l.Charset != "" && !charsetPattern.MatchString(l.Charset) {
+		return fmt.Errorf("invalid worker charset %q", l.Charset)
+	}
+	return nil
+}
+
+// Worker renders the worker name template into a shell expansion evaluated by the job, which removes the
+// characters outside of the charset of the pool and truncates the name to its max length.
+func (p *Pool) Worker(template string, data WorkerData) (string, error) {
+	if err := p.WorkerLimits.Validate(); err != nil {
+		return "", fmt.Errorf("pool %s: %w", p.Name, err)
+	}
+	name, err := renderWorker(template, data)
+	if err != nil {
+		return "", fmt.Errorf("pool %s: worker: %w", p.Name, err)
+	}
+	charset := p.WorkerLimits.Charset
+	if charset == "" {
+		charset = DefaultWorkerCharset
+	}
+	expansion := `$(printf %s "` + name + `" | tr -cd ` + charset
+	if p.WorkerLimits.MaxLength > 0 {
+		expansion += " | cut -c 1-" + strconv.Itoa(p.WorkerLimits.MaxLength)
+	}
+	return expansion + ")", nil
+}
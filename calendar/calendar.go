@@ -0,0 +1,191 @@
+// Package calendar holds the windows in which mining is allowed.
+package calendar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/cron"
+	"github.com/squarefactory/miner-api/fileutil"
+	"github.com/squarefactory/miner-api/wallet"
+)
+
+// Sources of the windows.
+const (
+	SourceConfig = "config"
+	SourceAPI    = "api"
+)
+
+var (
+	// ErrNotFound is returned when deleting an unknown window.
+	ErrNotFound = errors.New("window not found")
+	// ErrReadOnly is returned when modifying a window of the config.
+	ErrReadOnly = errors.New("window is defined in the config")
+)
+
+// Window allows mining during the minutes matched by Schedule.
+type Window struct {
+	Name     string        `json:"name"`
+	Schedule cron.Schedule `json:"schedule"`
+	// Usage is the percentage of the cluster used while the window is active.
+	Usage float64 `json:"usage"`
+	// Wallet receives the rewards. The last wallet given to /start is used if empty.
+	Wallet string `json:"wallet,omitempty"`
+	// Algo pins the algorithm of the GPU job instead of switching to the most profitable one.
+	Algo   string `json:"algo,omitempty"`
+	Source string `json:"source"`
+}
+
+// Validate checks the window.
+func (w *Window) Validate() error {
+	if w.Name == "" {
+		return errors.New("window name is required")
+	}
+	if w.Schedule.String() == "" {
+		return fmt.Errorf("window %s: schedule is required", w.Name)
+	}
+	if w.Usage <= 0 || w.Usage > 100 {
+		return fmt.Errorf("window %s: usage %g out of range 1-100", w.Name, w.Usage)
+	}
+	if w.Wallet != "" {
+		if err := wallet.ValidateAddress(w.Wallet); err != nil {
+			return fmt.Errorf("window %s: %w", w.Name, err)
+		}
+	}
+	return nil
+}
+
+// Store holds the windows of the config, followed by the windows added with the API.
+//
+// The windows added with the API are persisted in a JSON file, if a path is given.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	windows []Window
+}
+
+// Open loads the windows added with the API from path and appends them to the windows of the config.
+func Open(path string, config []Window) (*Store, error) {
+	s := &Store{path: path}
+	for _, w := range config {
+		w.Source = SourceConfig
+		if err := w.Validate(); err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var windows []Window
+	if err := json.Unmarshal(b, &windows); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, w := range windows {
+		w.Source = SourceAPI
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// List returns the windows.
+func (s *Store) List() []Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Window(nil), s.windows...)
+}
+
+// Put adds a window, or replaces the window added with the API with the same name.
+func (s *Store) Put(w Window) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	w.Source = SourceAPI
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	windows := append([]Window(nil), s.windows...)
+	replaced := false
+	for i, existing := range windows {
+		if existing.Name != w.Name {
+			continue
+		}
+		if existing.Source == SourceConfig {
+			return ErrReadOnly
+		}
+		windows[i] = w
+		replaced = true
+	}
+	if !replaced {
+		windows = append(windows, w)
+	}
+	if err := s.save(windows); err != nil {
+		return err
+	}
+	s.windows = windows
+	return nil
+}
+
+// Delete removes a window added with the API.
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range s.windows {
+		if w.Name != name {
+			continue
+		}
+		if w.Source == SourceConfig {
+			return ErrReadOnly
+		}
+		windows := append(append([]Window(nil), s.windows[:i]...), s.windows[i+1:]...)
+		if err := s.save(windows); err != nil {
+			return err
+		}
+		s.windows = windows
+		return nil
+	}
+	return ErrNotFound
+}
+
+// Active returns the first window matching t.
+func (s *Store) Active(t time.Time) (Window, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range s.windows {
+		if w.Schedule.Match(t) {
+			return w, true
+		}
+	}
+	return Window{}, false
+}
+
+// save writes the windows added with the API atomically.
+func (s *Store) save(windows []Window) error {
+	if s.path == "" {
+		return nil
+	}
+	var persisted []Window
+	for _, w := range windows {
+		if w.Source == SourceAPI {
+			persisted = append(persisted, w)
+		}
+	}
+	b, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.path, b)
+}
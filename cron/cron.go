@@ -0,0 +1,211 @@
+// Package cron parses the cron expressions of the tariffs and of the mining schedules.
+//
+// An expression has five fields: minute (0-59), hour (0-23), day of month (1-31), month (1-12)
+// and day of week (0-7, 0 and 7 being Sunday). A field is "*", a value, a range "a-b", a step
+// "*/n" or "a-b/n", or a comma-separated list of those. Like cron, when both the day of month and
+// the day of week are restricted, a day matching either matches.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. It matches the minutes of its window.
+type Schedule struct {
+	expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domStar and dowStar are set if the day fields are unrestricted.
+	domStar bool
+	dowStar bool
+}
+
+type bounds struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]bounds{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	s := &Schedule{}
+	if err := s.parse(expr); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustParse is like Parse but panics if the expression is invalid.
+func MustParse(expr string) *Schedule {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (s *Schedule) parse(expr string) error {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return fmt.Errorf("cron: expected 5 fields in %q, got %d", expr, len(parts))
+	}
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return fmt.Errorf("cron: %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	*s = Schedule{
+		expr:    strings.Join(parts, " "),
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: parts[2] == "*",
+		dowStar: parts[4] == "*",
+	}
+	// Sunday is both 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return nil
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if r, st, ok := strings.Cut(item, "/"); ok {
+			n, err := strconv.Atoi(st)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %s %q", b.name, item)
+			}
+			rng, step = r, n
+		}
+
+		lo, hi := b.min, b.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			l, h, _ := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(l); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", b.name, item)
+			}
+			if hi, err = strconv.Atoi(h); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", b.name, item)
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s %q", b.name, item)
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = b.max
+			}
+		}
+		if lo < b.min || hi > b.max || lo > hi {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", b.name, item, b.min, b.max)
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// String returns the expression of the schedule.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Schedule) MarshalText() ([]byte, error) {
+	return []byte(s.expr), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, so that schedules can be read from the config and the API.
+func (s *Schedule) UnmarshalText(text []byte) error {
+	return s.parse(string(text))
+}
+
+// Match reports whether the minute of t is in the schedule.
+func (s *Schedule) Match(t time.Time) bool {
+	return s.minute&(1<<t.Minute()) != 0 &&
+		s.hour&(1<<t.Hour()) != 0 &&
+		s.month&(1<<t.Month()) != 0 &&
+		s.matchDay(t)
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// maxSearch bounds the search of Next: a valid expression like "0 0 29 2 *" matches at least every 8 years.
+const maxSearch = 9 * 366 * 24 * time.Hour
+
+// Next returns the first minute after t at which the schedule starts or stops matching,
+// and whether the schedule matches from then. It returns the zero time if the schedule never changes.
+func (s *Schedule) Next(t time.Time) (time.Time, bool) {
+	return s.NextBefore(t, t.Truncate(time.Minute).Add(maxSearch))
+}
+
+// NextBefore is Next with the search stopping at limit. It returns the zero time if the schedule does not change
+// before limit.
+func (s *Schedule) NextBefore(t, limit time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	current := s.Match(t)
+	for t = t.Add(time.Minute); t.Before(limit); {
+		if s.Match(t) != current {
+			return t, !current
+		}
+		t = s.skip(t, current)
+	}
+	return time.Time{}, current
+}
+
+// NextHour returns the start of the wall-clock hour following t, in the location of t.
+//
+// Unlike t.Truncate(time.Hour), it follows the hours of the location, which are not aligned on UTC hours in the
+// time zones with a fractional offset.
+func NextHour(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+}
+
+// skip advances t to the next minute which may change the result of Match.
+// While looking for a matching minute, whole days and hours which cannot match are skipped.
+func (s *Schedule) skip(t time.Time, current bool) time.Time {
+	if !current {
+		if s.month&(1<<t.Month()) == 0 || !s.matchDay(t) {
+			y, m, d := t.Date()
+			return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		}
+		if s.hour&(1<<t.Hour()) == 0 {
+			return NextHour(t)
+		}
+	}
+	return t.Add(time.Minute)
+}
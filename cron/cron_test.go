@@ -0,0 +1,117 @@
+//go:build unit
+
+package cron_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/cron"
+	"github.com/stretchr/testify/suite"
+)
+
+type CronTestSuite struct {
+	suite.Suite
+}
+
+// 2023-05-01 is a Monday.
+func at(day, hour, minute int) time.Time {
+	return time.Date(2023, 5, day, hour, minute, 0, 0, time.UTC)
+}
+
+func (suite *CronTestSuite) TestMatch() {
+	tests := []struct {
+		expr     string
+		time     time.Time
+		expected bool
+	}{
+		{"* * * * *", at(1, 12, 30), true},
+		{"* 8-19 * * 1-5", at(1, 8, 0), true},
+		{"* 8-19 * * 1-5", at(1, 20, 0), false},
+		{"* 8-19 * * 1-5", at(6, 12, 0), false},
+		{"* * * * 0,6", at(7, 12, 0), true},
+		{"* * * * 7", at(7, 12, 0), true},
+		{"*/15 * * * *", at(1, 12, 45), true},
+		{"*/15 * * * *", at(1, 12, 46), false},
+		{"0 0 1 * 0", at(7, 0, 0), true}, // Day of month or day of week.
+		{"0 0 1 * 0", at(2, 0, 0), false},
+	}
+	for _, tt := range tests {
+		suite.Run(tt.expr, func() {
+			// Act
+			s, err := cron.Parse(tt.expr)
+
+			// Assert
+			suite.NoError(err)
+			suite.Equal(tt.expected, s.Match(tt.time), tt.time)
+		})
+	}
+}
+
+func (suite *CronTestSuite) TestParseInvalid() {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *", "a * * * *"} {
+		// Act
+		_, err := cron.Parse(expr)
+
+		// Assert
+		suite.Error(err, expr)
+	}
+}
+
+func (suite *CronTestSuite) TestNext() {
+	// Arrange
+	s := cron.MustParse("* 8-19 * * 1-5")
+
+	// Act
+	start, startMatch := s.Next(at(5, 20, 0))
+	stop, stopMatch := s.Next(at(8, 9, 0))
+
+	// Assert
+	suite.Equal(at(8, 8, 0), start)
+	suite.True(startMatch)
+	suite.Equal(at(8, 20, 0), stop)
+	suite.False(stopMatch)
+}
+
+func (suite *CronTestSuite) TestNextNever() {
+	// Act
+	next, match := cron.MustParse("* * * * *").Next(at(1, 0, 0))
+
+	// Assert
+	suite.True(next.IsZero())
+	suite.True(match)
+}
+
+func (suite *CronTestSuite) TestNextBefore() {
+	// Arrange
+	s := cron.MustParse("* 8-19 * * 1-5")
+
+	// Act
+	before, beforeMatch := s.NextBefore(at(8, 9, 0), at(8, 20, 0))
+	stop, stopMatch := s.NextBefore(at(8, 9, 0), at(8, 21, 0))
+
+	// Assert
+	suite.True(before.IsZero())
+	suite.True(beforeMatch)
+	suite.Equal(at(8, 20, 0), stop)
+	suite.False(stopMatch)
+}
+
+func (suite *CronTestSuite) TestNextHalfHourOffset() {
+	// Arrange
+	india := time.FixedZone("IST", 5*3600+1800)
+	s := cron.MustParse("* 8-19 * * 1-5")
+
+	// Act
+	next, match := s.Next(time.Date(2023, 5, 8, 6, 10, 0, 0, india))
+	hour := cron.NextHour(time.Date(2023, 5, 8, 10, 45, 0, 0, india))
+
+	// Assert
+	suite.Equal(time.Date(2023, 5, 8, 8, 0, 0, 0, india), next)
+	suite.True(match)
+	suite.Equal(time.Date(2023, 5, 8, 11, 0, 0, 0, india), hour)
+}
+
+func TestCronTestSuite(t *testing.T) {
+	suite.Run(t, new(CronTestSuite))
+}
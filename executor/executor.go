@@ -1,46 +1,162 @@
 package executor
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
 	"syscall"
+	"time"
 )
 
-type Shell struct{}
+// DefaultPath is the PATH given to the commands when Shell.Path is empty.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 
-func (*Shell) ExecAs(ctx context.Context, user string, cmd string) (string, error) {
-	// Get the user ID
-	uid, err := lookupUserID(user)
+// ErrOutputTruncated is returned when the output of a command exceeds Shell.MaxOutputBytes.
+var ErrOutputTruncated = errors.New("command output truncated")
+
+// Shell runs commands locally, impersonating the requested UNIX user.
+type Shell struct {
+	// WorkDir is the working directory of the commands. Defaults to /tmp.
+	WorkDir string
+	// Path is the PATH of the commands. Defaults to DefaultPath.
+	Path string
+	// Timeout bounds the duration of each command. Zero means no timeout.
+	Timeout time.Duration
+	// MaxOutputBytes bounds the size of the combined output. Zero means unlimited.
+	MaxOutputBytes int
+}
+
+// Run executes argv as user. The arguments are passed as-is to the process and are never parsed by a shell.
+//
+// The process runs with the UID, primary GID and supplementary groups of the user, and with a clean environment.
+func (s *Shell) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	if len(argv) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	id, err := lookupIdentity(user)
 	if err != nil {
 		return "", err
 	}
 
-	c := exec.CommandContext(ctx, "sh", "-c", fmt.Sprintf("cd /tmp && %s", cmd))
-	fmt.Printf("exec: %+v\n", c.Args)
-	// Set the user ID for the command
-	c.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{
-			Uid: uid,
-		},
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	c := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	c.Dir = s.WorkDir
+	if c.Dir == "" {
+		c.Dir = "/tmp"
 	}
+	c.Stdin = stdin
+	c.Env = s.environ(id)
+	// Impersonate the user, unless we are already running as this user without privileges.
+	if os.Geteuid() == 0 || uint32(os.Geteuid()) != id.credential.Uid {
+		c.SysProcAttr = &syscall.SysProcAttr{
+			Credential: id.credential,
+		}
+	}
+
+	out := &limitedBuffer{limit: s.MaxOutputBytes}
+	c.Stdout = out
+	c.Stderr = out
+	err = c.Run()
+	if err == nil && out.truncated {
+		err = fmt.Errorf("%w: more than %d bytes", ErrOutputTruncated, s.MaxOutputBytes)
+	}
+	return out.String(), err
+}
+
+func (s *Shell) environ(id *identity) []string {
+	path := s.Path
+	if path == "" {
+		path = DefaultPath
+	}
+	return []string{
+		"HOME=" + id.home,
+		"USER=" + id.name,
+		"LOGNAME=" + id.name,
+		"PATH=" + path,
+	}
+}
 
-	out, err := c.CombinedOutput()
-	return string(out), err
+// identity is a UNIX user resolved for impersonation.
+type identity struct {
+	name       string
+	home       string
+	credential *syscall.Credential
 }
 
-func lookupUserID(username string) (uint32, error) {
+func lookupIdentity(username string) (*identity, error) {
 	u, err := user.Lookup(username)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	uid, err := strconv.ParseUint(u.Uid, 10, 32)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return uint32(uid), nil
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIDs))
+	for _, g := range groupIDs {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(id))
+	}
+
+	return &identity{
+		name: u.Username,
+		home: u.HomeDir,
+		credential: &syscall.Credential{
+			Uid:    uint32(uid),
+			Gid:    uint32(gid),
+			Groups: groups,
+		},
+	}, nil
+}
+
+// limitedBuffer is a buffer which discards everything written after limit bytes.
+//
+// The buffer is not embedded so that io.Copy cannot bypass Write through ReadFrom.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit <= 0 {
+		return b.buf.Write(p)
+	}
+	n := len(p)
+	if remaining := b.limit - b.buf.Len(); len(p) > remaining {
+		p = p[:remaining]
+		b.truncated = true
+	}
+	_, err := b.buf.Write(p)
+	return n, err
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
 }
@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// Metered records the latency and the errors of the commands run by the wrapped executor.
+type Metered struct {
+	next scheduler.Executor
+}
+
+func NewMetered(next scheduler.Executor) *Metered {
+	return &Metered{next: next}
+}
+
+func (m *Metered) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	start := time.Now()
+	out, err := m.next.Run(ctx, user, argv, stdin)
+	if len(argv) > 0 {
+		observe(filepath.Base(argv[0]), start, err)
+	}
+	return out, err
+}
+
+func observe(command string, start time.Time, err error) {
+	metrics.SlurmCommandDuration.WithLabelValues(command).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.SlurmCommandErrors.WithLabelValues(command).Inc()
+	}
+}
@@ -0,0 +1,284 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHConfig configures an SSH executor.
+type SSHConfig struct {
+	// Address of the Slurm login node (host:port).
+	Address string
+	// User is the SSH user. Commands for other users are run through sudo.
+	User string
+	// PrivateKeyPath is the path to the private key of User.
+	PrivateKeyPath string
+	// CertificatePath is the optional path to an OpenSSH certificate signed for the private key.
+	CertificatePath string
+	// KnownHostsPath is the path to the known_hosts file used to verify the login node.
+	KnownHostsPath string
+	// WorkDir is the remote working directory of the commands. Defaults to /tmp.
+	WorkDir string
+	// DialTimeout bounds the time to establish the SSH connection.
+	DialTimeout time.Duration
+	// Timeout bounds the duration of each command. Zero means no timeout.
+	Timeout time.Duration
+	// MaxOutputBytes bounds the size of the combined output. Zero means unlimited.
+	MaxOutputBytes int
+}
+
+// SSH runs commands on a remote Slurm login node.
+//
+// The connection is established lazily and reused across commands.
+type SSH struct {
+	config       SSHConfig
+	clientConfig *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func NewSSH(config SSHConfig) (*SSH, error) {
+	key, err := os.ReadFile(config.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	if config.CertificatePath != "" {
+		certBytes, err := os.ReadFile(config.CertificatePath)
+		if err != nil {
+			return nil, err
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a certificate", config.CertificatePath)
+		}
+		signer, err = ssh.NewCertSigner(cert, signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hostKeyCallback, err := knownhosts.New(config.KnownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSH{
+		config: config,
+		clientConfig: &ssh.ClientConfig{
+			User:            config.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeyCallback,
+		},
+	}, nil
+}
+
+// Run executes argv on the login node as user.
+//
+// Each argument is quoted before being sent to the remote shell. If user is not the SSH user, the command is run with
+// `sudo -n -u <user>`.
+func (s *SSH) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	if len(argv) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	if s.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.Timeout)
+		defer cancel()
+	}
+
+	cmd := s.command(user, argv)
+
+	session, err := s.newSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	out := &limitedBuffer{limit: s.config.MaxOutputBytes}
+	session.Stdin = stdin
+	session.Stdout = out
+	session.Stderr = out
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = session.Signal(ssh.SIGKILL)
+			_ = session.Close()
+		case <-done:
+		}
+	}()
+
+	err = session.Run(cmd)
+	if ctx.Err() != nil {
+		return out.String(), ctx.Err()
+	}
+	if err == nil && out.truncated {
+		err = fmt.Errorf("%w: more than %d bytes", ErrOutputTruncated, s.config.MaxOutputBytes)
+	}
+	return out.String(), err
+}
+
+// Close closes the underlying SSH connection.
+func (s *SSH) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+func (s *SSH) command(user string, argv []string) string {
+	workDir := s.config.WorkDir
+	if workDir == "" {
+		workDir = "/tmp"
+	}
+
+	var b strings.Builder
+	b.WriteString("cd ")
+	b.WriteString(shellQuote(workDir))
+	b.WriteString(" && exec")
+	if user != "" && user != s.config.User {
+		b.WriteString(" sudo -n -H -u ")
+		b.WriteString(shellQuote(user))
+		b.WriteString(" --")
+	}
+	for _, arg := range argv {
+		b.WriteByte(' ')
+		b.WriteString(shellQuote(arg))
+	}
+	return b.String()
+}
+
+// newSession opens a session on the cached connection, redialing once if the connection is broken.
+func (s *SSH) newSession(ctx context.Context) (*ssh.Session, error) {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		client, err := s.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		session, err := client.NewSession()
+		if err == nil {
+			return session, nil
+		}
+		s.drop(client)
+		if !isConnectionError(err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// connect returns the cached connection, or dials a new one. The lock is not held while dialing, so that the other
+// commands are not blocked by an unreachable login node.
+func (s *SSH) connect(ctx context.Context) (*ssh.Client, error) {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		// Another command connected meanwhile.
+		_ = client.Close()
+		return s.client, nil
+	}
+	s.client = client
+	return client, nil
+}
+
+// dial connects to the login node, giving up when ctx is done or after DialTimeout.
+func (s *SSH) dial(ctx context.Context) (*ssh.Client, error) {
+	if s.config.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.DialTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake does not take a context: the connection is closed to interrupt it.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.config.Address, s.clientConfig)
+	close(stop)
+	<-stopped
+	if err == nil && ctx.Err() != nil {
+		_ = c.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// drop closes a broken connection, and forgets it unless it was already replaced.
+func (s *SSH) drop(client *ssh.Client) {
+	_ = client.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == client {
+		s.client = nil
+	}
+}
+
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.As(err, &netErr)
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,348 @@
+//go:build unit
+
+package executor_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/executor"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSSHServer is an in-process SSH server which echoes the received command and stdin.
+type fakeSSHServer struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	hostKey     ssh.PublicKey
+	connections atomic.Int32
+
+	mu        sync.Mutex
+	commands  []string
+	authority ssh.PublicKey // Signs the accepted user certificates, if set.
+}
+
+func newFakeSSHServer(t *testing.T, authorized ssh.PublicKey) *fakeSSHServer {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSSHServer{hostKey: hostSigner.PublicKey()}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if _, ok := key.(*ssh.Certificate); ok {
+				checker := &ssh.CertChecker{IsUserAuthority: s.trusts}
+				return checker.Authenticate(conn, key)
+			}
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	s.config.AddHostKey(hostSigner)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.listener.Close() })
+	go s.serve()
+	return s
+}
+
+// trusts reports whether key is the authority of the user certificates.
+func (s *fakeSSHServer) trusts(key ssh.PublicKey) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authority != nil && string(key.Marshal()) == string(s.authority.Marshal())
+}
+
+func (s *fakeSSHServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *fakeSSHServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.connections.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSSHServer) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleSession(channel, requests)
+	}
+}
+
+func (s *fakeSSHServer) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, payload.Command)
+		s.mu.Unlock()
+
+		stdin, _ := io.ReadAll(channel)
+		status := uint32(0)
+		if strings.Contains(payload.Command, "'false'") {
+			status = 1
+		}
+		_, _ = fmt.Fprintf(channel, "%s\n%s", payload.Command, stdin)
+		exit := make([]byte, 4)
+		binary.BigEndian.PutUint32(exit, status)
+		_, _ = channel.SendRequest("exit-status", false, exit)
+		return
+	}
+}
+
+type SSHTestSuite struct {
+	suite.Suite
+	dir    string
+	server *fakeSSHServer
+	config executor.SSHConfig
+}
+
+func (suite *SSHTestSuite) BeforeTest(suiteName, testName string) {
+	suite.dir = suite.T().TempDir()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	suite.Require().NoError(err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	suite.Require().NoError(err)
+	keyPath := filepath.Join(suite.dir, "id_ed25519")
+	suite.Require().NoError(os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600))
+	clientKey, err := ssh.NewPublicKey(pub)
+	suite.Require().NoError(err)
+
+	suite.server = newFakeSSHServer(suite.T(), clientKey)
+
+	knownHostsPath := filepath.Join(suite.dir, "known_hosts")
+	line := knownhosts.Line([]string{suite.server.listener.Addr().String()}, suite.server.hostKey)
+	suite.Require().NoError(os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600))
+
+	suite.config = executor.SSHConfig{
+		Address:        suite.server.listener.Addr().String(),
+		User:           "slurm",
+		PrivateKeyPath: keyPath,
+		KnownHostsPath: knownHostsPath,
+	}
+}
+
+func (suite *SSHTestSuite) TestRun() {
+	// Arrange
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx := context.Background()
+
+	// Act
+	out, err := impl.Run(ctx, "slurm", []string{"sbatch", "--job-name=it's $(reboot)"}, strings.NewReader("#!/bin/sh\n"))
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("cd '/tmp' && exec 'sbatch' '--job-name=it'\\''s $(reboot)'\n#!/bin/sh\n", out)
+}
+
+func (suite *SSHTestSuite) TestRunImpersonatesWithSudo() {
+	// Arrange
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx := context.Background()
+
+	// Act
+	out, err := impl.Run(ctx, "miner", []string{"squeue"}, nil)
+
+	// Assert
+	suite.NoError(err)
+	suite.Contains(out, "exec sudo -n -H -u 'miner' -- 'squeue'")
+}
+
+func (suite *SSHTestSuite) TestRunReusesConnection() {
+	// Arrange
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx := context.Background()
+
+	// Act
+	_, err1 := impl.Run(ctx, "slurm", []string{"squeue"}, nil)
+	_, err2 := impl.Run(ctx, "slurm", []string{"sinfo"}, nil)
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.Equal(int32(1), suite.server.connections.Load())
+	suite.Len(suite.server.received(), 2)
+}
+
+func (suite *SSHTestSuite) TestRunExitStatus() {
+	// Arrange
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx := context.Background()
+
+	// Act
+	_, err = impl.Run(ctx, "slurm", []string{"false"}, nil)
+
+	// Assert
+	var exitErr *ssh.ExitError
+	suite.ErrorAs(err, &exitErr)
+	suite.Equal(1, exitErr.ExitStatus())
+}
+
+func (suite *SSHTestSuite) TestRejectsUnknownHost() {
+	// Arrange
+	suite.Require().NoError(os.WriteFile(suite.config.KnownHostsPath, nil, 0o600))
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx := context.Background()
+
+	// Act
+	_, err = impl.Run(ctx, "slurm", []string{"squeue"}, nil)
+
+	// Assert
+	suite.ErrorContains(err, "knownhosts: key is unknown")
+	suite.Empty(suite.server.received())
+}
+
+func (suite *SSHTestSuite) TestCertificate() {
+	// Arrange
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	suite.Require().NoError(err)
+	ca, err := ssh.NewSignerFromKey(caKey)
+	suite.Require().NoError(err)
+	suite.server.mu.Lock()
+	suite.server.authority = ca.PublicKey()
+	suite.server.mu.Unlock()
+
+	// The key of the certificate is not authorized by itself.
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	suite.Require().NoError(err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	suite.Require().NoError(err)
+	suite.config.PrivateKeyPath = filepath.Join(suite.dir, "id_cert")
+	suite.Require().NoError(os.WriteFile(suite.config.PrivateKeyPath, pem.EncodeToMemory(block), 0o600))
+	key, err := ssh.NewPublicKey(pub)
+	suite.Require().NoError(err)
+	cert := &ssh.Certificate{
+		Key:             key,
+		KeyId:           "miner-api",
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"slurm"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	suite.Require().NoError(cert.SignCert(rand.Reader, ca))
+	suite.config.CertificatePath = filepath.Join(suite.dir, "id_cert-cert.pub")
+	suite.Require().NoError(os.WriteFile(suite.config.CertificatePath, ssh.MarshalAuthorizedKey(cert), 0o600))
+
+	withoutCert := suite.config
+	withoutCert.CertificatePath = ""
+	notCert := suite.config
+	notCert.CertificatePath = filepath.Join(suite.dir, "id_cert.pub")
+	suite.Require().NoError(os.WriteFile(notCert.CertificatePath, ssh.MarshalAuthorizedKey(key), 0o600))
+
+	// Act
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	_, errRun := impl.Run(context.Background(), "slurm", []string{"squeue"}, nil)
+	keyOnly, err := executor.NewSSH(withoutCert)
+	suite.Require().NoError(err)
+	defer keyOnly.Close()
+	_, errKeyOnly := keyOnly.Run(context.Background(), "slurm", []string{"squeue"}, nil)
+	_, errNotCert := executor.NewSSH(notCert)
+
+	// Assert
+	suite.NoError(errRun)
+	suite.ErrorContains(errKeyOnly, "unable to authenticate")
+	suite.ErrorContains(errNotCert, "is not a certificate")
+	suite.Len(suite.server.received(), 1)
+}
+
+func (suite *SSHTestSuite) TestDialCancelled() {
+	// Arrange
+	// The login node accepts the connection but never completes the handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	suite.config.Address = listener.Addr().String()
+	impl, err := executor.NewSSH(suite.config)
+	suite.Require().NoError(err)
+	defer impl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Act
+	start := time.Now()
+	_, err = impl.Run(ctx, "slurm", []string{"squeue"}, nil)
+
+	// Assert
+	suite.ErrorIs(err, context.DeadlineExceeded)
+	suite.Less(time.Since(start), 5*time.Second)
+}
+
+func TestSSHTestSuite(t *testing.T) {
+	suite.Run(t, &SSHTestSuite{})
+}
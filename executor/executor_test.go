@@ -0,0 +1,124 @@
+//go:build unit
+
+package executor_test
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/squarefactory/miner-api/executor"
+	"github.com/stretchr/testify/suite"
+)
+
+type ShellTestSuite struct {
+	suite.Suite
+	current *user.User
+}
+
+func (suite *ShellTestSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.current, err = user.Current()
+	suite.Require().NoError(err)
+}
+
+func (suite *ShellTestSuite) TestLookupIdentity() {
+	// Act
+	uid, gid, groups, home, err := executor.Identity(suite.current.Username)
+	_, _, _, _, errUnknown := executor.Identity("no-such-user-miner-api")
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(suite.current.Uid, strconv.FormatUint(uint64(uid), 10))
+	suite.Equal(suite.current.Gid, strconv.FormatUint(uint64(gid), 10))
+	suite.Equal(suite.current.HomeDir, home)
+	groupIDs, err := suite.current.GroupIds()
+	suite.Require().NoError(err)
+	suite.Len(groups, len(groupIDs))
+	suite.Contains(groups, gid)
+	suite.Error(errUnknown)
+}
+
+func (suite *ShellTestSuite) TestEnviron() {
+	// Arrange
+	shell := &executor.Shell{}
+	custom := &executor.Shell{Path: "/opt/slurm/bin:/usr/bin:/bin"}
+
+	// Act
+	env, err := shell.Environ(suite.current.Username)
+	customEnv, errCustom := custom.Environ(suite.current.Username)
+
+	// Assert
+	suite.NoError(err)
+	suite.NoError(errCustom)
+	suite.Equal([]string{
+		"HOME=" + suite.current.HomeDir,
+		"USER=" + suite.current.Username,
+		"LOGNAME=" + suite.current.Username,
+		"PATH=" + executor.DefaultPath,
+	}, env)
+	suite.Equal("PATH=/opt/slurm/bin:/usr/bin:/bin", customEnv[3])
+}
+
+func (suite *ShellTestSuite) TestLimitedBuffer() {
+	// Act
+	unlimited, unlimitedTruncated, err1 := executor.LimitedCopy(0, "0123", "456789")
+	exact, exactTruncated, err2 := executor.LimitedCopy(10, "0123", "456789")
+	limited, limitedTruncated, err3 := executor.LimitedCopy(6, "0123", "456789", "abc")
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.NoError(err3)
+	suite.Equal("0123456789", unlimited)
+	suite.False(unlimitedTruncated)
+	suite.Equal("0123456789", exact)
+	suite.False(exactTruncated)
+	suite.Equal("012345", limited)
+	suite.True(limitedTruncated)
+}
+
+func (suite *ShellTestSuite) TestRun() {
+	// Arrange
+	shell := &executor.Shell{MaxOutputBytes: 4}
+
+	// Act
+	out, err := (&executor.Shell{}).Run(context.Background(), suite.current.Username, []string{"env"}, nil)
+	truncated, errTruncated := shell.Run(context.Background(), suite.current.Username, []string{"printf", "%s", "0123456789"}, nil)
+
+	// Assert
+	suite.NoError(err)
+	suite.ElementsMatch([]string{
+		"HOME=" + suite.current.HomeDir,
+		"USER=" + suite.current.Username,
+		"LOGNAME=" + suite.current.Username,
+		"PATH=" + executor.DefaultPath,
+	}, strings.Fields(out))
+	suite.Equal("0123", truncated)
+	suite.ErrorIs(errTruncated, executor.ErrOutputTruncated)
+}
+
+func (suite *ShellTestSuite) TestRunImpersonates() {
+	if os.Geteuid() != 0 {
+		suite.T().Skip("impersonating another user requires root")
+	}
+	// Arrange
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		suite.T().Skip("no nobody user")
+	}
+
+	// Act
+	out, err := (&executor.Shell{}).Run(context.Background(), "nobody", []string{"id", "-u"}, nil)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(nobody.Uid, strings.TrimSpace(out))
+}
+
+func TestShellTestSuite(t *testing.T) {
+	suite.Run(t, new(ShellTestSuite))
+}
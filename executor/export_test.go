@@ -0,0 +1,38 @@
+//go:build unit
+
+package executor
+
+import (
+	"io"
+	"strings"
+)
+
+// Identity returns the credential and the home of a user, as resolved to impersonate it.
+func Identity(username string) (uid uint32, gid uint32, groups []uint32, home string, err error) {
+	id, err := lookupIdentity(username)
+	if err != nil {
+		return 0, 0, nil, "", err
+	}
+	return id.credential.Uid, id.credential.Gid, id.credential.Groups, id.home, nil
+}
+
+// Environ returns the environment of the commands run as a user.
+func (s *Shell) Environ(username string) ([]string, error) {
+	id, err := lookupIdentity(username)
+	if err != nil {
+		return nil, err
+	}
+	return s.environ(id), nil
+}
+
+// LimitedCopy copies each chunk into a buffer limited to limit bytes, and returns its content and whether it was
+// truncated.
+func LimitedCopy(limit int, chunks ...string) (string, bool, error) {
+	b := &limitedBuffer{limit: limit}
+	for _, chunk := range chunks {
+		if _, err := io.Copy(b, strings.NewReader(chunk)); err != nil {
+			return "", false, err
+		}
+	}
+	return b.String(), b.truncated, nil
+}
@@ -0,0 +1,43 @@
+// Package fileutil persists the stores of the API in files.
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteAtomic writes data to path through a temporary file renamed over it,
+// so that readers see either the old or the new content.
+func WriteAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// CopyOnWrite applies edit to a copy of m and saves the copy.
+// It returns the copy to replace m with, or the error of save, in which case m is left unchanged.
+func CopyOnWrite[K comparable, V any](
+	m map[K]V,
+	edit func(map[K]V),
+	save func(map[K]V) error,
+) (map[K]V, error) {
+	edited := make(map[K]V, len(m)+1)
+	for k, v := range m {
+		edited[k] = v
+	}
+	edit(edited)
+	if err := save(edited); err != nil {
+		return nil, err
+	}
+	return edited, nil
+}
@@ -0,0 +1,85 @@
+//go:build unit
+
+package fileutil_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/squarefactory/miner-api/fileutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type FileUtilTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *FileUtilTestSuite) BeforeTest(suiteName, testName string) {
+	suite.path = filepath.Join(suite.T().TempDir(), "store.json")
+}
+
+func (suite *FileUtilTestSuite) TestWriteAtomic() {
+	// Arrange
+	suite.Require().NoError(os.WriteFile(suite.path, []byte("old"), 0o600))
+
+	// Act
+	err := fileutil.WriteAtomic(suite.path, []byte("new"))
+
+	// Assert
+	suite.NoError(err)
+	data, errRead := os.ReadFile(suite.path)
+	suite.NoError(errRead)
+	suite.Equal("new", string(data))
+	entries, errDir := os.ReadDir(filepath.Dir(suite.path))
+	suite.NoError(errDir)
+	suite.Len(entries, 1, "the temporary file must be removed")
+}
+
+func (suite *FileUtilTestSuite) TestWriteAtomicMissingDir() {
+	// Act
+	err := fileutil.WriteAtomic(filepath.Join(suite.path, "missing", "store.json"), []byte("new"))
+
+	// Assert
+	suite.Error(err)
+}
+
+func (suite *FileUtilTestSuite) TestCopyOnWrite() {
+	// Arrange
+	m := map[string]int{"a": 1}
+	var saved map[string]int
+
+	// Act
+	edited, err := fileutil.CopyOnWrite(m, func(c map[string]int) { c["b"] = 2 }, func(c map[string]int) error {
+		saved = c
+		return nil
+	})
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(map[string]int{"a": 1, "b": 2}, edited)
+	suite.Equal(edited, saved)
+	suite.Equal(map[string]int{"a": 1}, m)
+}
+
+func (suite *FileUtilTestSuite) TestCopyOnWriteSaveError() {
+	// Arrange
+	m := map[string]int{"a": 1}
+	errSave := errors.New("disk full")
+
+	// Act
+	edited, err := fileutil.CopyOnWrite(m, func(c map[string]int) { delete(c, "a") }, func(map[string]int) error {
+		return errSave
+	})
+
+	// Assert
+	suite.ErrorIs(err, errSave)
+	suite.Nil(edited)
+	suite.Equal(map[string]int{"a": 1}, m)
+}
+
+func TestFileUtilTestSuite(t *testing.T) {
+	suite.Run(t, &FileUtilTestSuite{})
+}
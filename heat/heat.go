@@ -0,0 +1,213 @@
+// Package heat derives the mining usage from the heat demand of the building heated by the cluster.
+package heat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// Reading is a measure of the heat demand.
+type Reading struct {
+	Setpoint    float64   `json:"setpoint"`
+	Temperature float64   `json:"temperature"`
+	Time        time.Time `json:"time"`
+}
+
+// Demand is the temperature missing to reach the setpoint. It is negative when the setpoint is exceeded.
+func (r Reading) Demand() float64 {
+	return r.Setpoint - r.Temperature
+}
+
+// Signal reads the heat demand.
+type Signal interface {
+	Read(ctx context.Context) (Reading, error)
+}
+
+// HTTP reads the heat demand from a JSON document, like the HTTP API of a thermostat or of a Modbus/BACnet gateway.
+type HTTP struct {
+	URL string
+	// TemperatureField is the dot-separated path of the temperature in the document, like "zone.temperature".
+	TemperatureField string
+	// SetpointField is the path of the setpoint in the document. Setpoint is used if empty.
+	SetpointField string
+	Setpoint      float64
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Read implements Signal.
+func (h *HTTP) Read(ctx context.Context) (reading Reading, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveUpstream("heat", start, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return Reading{}, err
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Reading{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Reading{}, fmt.Errorf("heat signal returned %s", resp.Status)
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return Reading{}, err
+	}
+	reading = Reading{Setpoint: h.Setpoint, Time: time.Now()}
+	if reading.Temperature, err = lookup(doc, h.TemperatureField); err != nil {
+		return Reading{}, err
+	}
+	if h.SetpointField != "" {
+		if reading.Setpoint, err = lookup(doc, h.SetpointField); err != nil {
+			return Reading{}, err
+		}
+	}
+	return reading, nil
+}
+
+// lookup returns the number at the dot-separated path of a JSON document. Numbers given as strings are accepted.
+func lookup(doc interface{}, path string) (float64, error) {
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, fmt.Errorf("no %q field in the heat signal", path)
+		}
+		if v, ok = obj[key]; !ok {
+			return 0, fmt.Errorf("no %q field in the heat signal", path)
+		}
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	default:
+		return 0, fmt.Errorf("%q is not a number in the heat signal", path)
+	}
+}
+
+// Point maps a heat demand to a usage percentage.
+type Point struct {
+	Demand float64 `json:"demand"`
+	Usage  float64 `json:"usage"`
+}
+
+// Curve maps the heat demand to the usage by linear interpolation between its points.
+// The usage is constant beyond the first and the last points.
+type Curve []Point
+
+// Validate checks that the curve has points and usages between 0 and 100.
+func (c Curve) Validate() error {
+	if len(c) == 0 {
+		return errors.New("empty heat curve")
+	}
+	for _, p := range c {
+		if p.Usage < 0 || p.Usage > 100 {
+			return fmt.Errorf("heat curve usage %g out of range 0-100", p.Usage)
+		}
+	}
+	return nil
+}
+
+// Usage returns the usage percentage for a heat demand.
+func (c Curve) Usage(demand float64) float64 {
+	points := append(Curve(nil), c...)
+	sort.Slice(points, func(i, j int) bool { return points[i].Demand < points[j].Demand })
+
+	if demand <= points[0].Demand {
+		return points[0].Usage
+	}
+	for i := 1; i < len(points); i++ {
+		lo, hi := points[i-1], points[i]
+		if demand <= hi.Demand {
+			return lo.Usage + (demand-lo.Demand)/(hi.Demand-lo.Demand)*(hi.Usage-lo.Usage)
+		}
+	}
+	return points[len(points)-1].Usage
+}
+
+// State is the last decision of the Controller.
+type State struct {
+	Reading Reading `json:"reading"`
+	Demand  float64 `json:"demand"`
+	// Target is the usage given by the curve.
+	Target float64 `json:"target"`
+	// Usage is the rate-limited usage applied to the jobs.
+	Usage float64   `json:"usage"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Controller sets the usage following the heat demand.
+type Controller struct {
+	Signal Signal
+	Curve  Curve
+	// MaxStep bounds the change of usage per update, in percentage points. Unlimited if zero.
+	MaxStep float64
+	// MinChange ignores the smaller changes of usage, as each change restarts the jobs.
+	// Stopping and starting mining are never ignored.
+	MinChange float64
+
+	mu    sync.Mutex
+	state State
+}
+
+// Update reads the heat demand and returns the usage to apply, and whether it differs from current.
+func (c *Controller) Update(ctx context.Context, current float64) (float64, bool, error) {
+	reading, err := c.Signal.Read(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.state.Error = err.Error()
+		c.state.Time = time.Now()
+		return current, false, err
+	}
+
+	target := c.Curve.Usage(reading.Demand())
+	usage := target
+	if c.MaxStep > 0 {
+		usage = current + math.Max(-c.MaxStep, math.Min(c.MaxStep, target-current))
+	}
+	changed := usage != current &&
+		(math.Abs(usage-current) >= c.MinChange || usage == 0 || current == 0)
+	if !changed {
+		usage = current
+	}
+
+	c.state = State{
+		Reading: reading,
+		Demand:  reading.Demand(),
+		Target:  target,
+		Usage:   usage,
+		Time:    time.Now(),
+	}
+	return usage, changed, nil
+}
+
+// State returns the last decision.
+func (c *Controller) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
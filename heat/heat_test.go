@@ -0,0 +1,111 @@
+//go:build unit
+
+package heat_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/squarefactory/miner-api/heat"
+	"github.com/stretchr/testify/suite"
+)
+
+type HeatTestSuite struct {
+	suite.Suite
+}
+
+var curve = heat.Curve{
+	{Demand: 0, Usage: 0},
+	{Demand: 1, Usage: 30},
+	{Demand: 4, Usage: 100},
+}
+
+func (suite *HeatTestSuite) TestCurve() {
+	tests := []struct {
+		demand   float64
+		expected float64
+	}{
+		{-2, 0},
+		{0.5, 15},
+		{2.5, 65},
+		{10, 100},
+	}
+	for _, tt := range tests {
+		// Act
+		usage := curve.Usage(tt.demand)
+
+		// Assert
+		suite.InDelta(tt.expected, usage, 1e-9, tt.demand)
+	}
+}
+
+type fakeSignal struct {
+	reading heat.Reading
+}
+
+func (f *fakeSignal) Read(ctx context.Context) (heat.Reading, error) {
+	return f.reading, nil
+}
+
+func (suite *HeatTestSuite) TestControllerRateLimit() {
+	// Arrange
+	signal := &fakeSignal{reading: heat.Reading{Setpoint: 21, Temperature: 17}}
+	controller := &heat.Controller{
+		Signal:    signal,
+		Curve:     curve,
+		MaxStep:   40,
+		MinChange: 10,
+	}
+	ctx := context.Background()
+
+	// Act
+	first, changed1, err1 := controller.Update(ctx, 0)
+	second, changed2, err2 := controller.Update(ctx, first)
+	signal.reading.Temperature = 17.15 // 96.5%
+	third, changed3, err3 := controller.Update(ctx, 100)
+	signal.reading.Temperature = 22
+	fourth, changed4, err4 := controller.Update(ctx, 100)
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.NoError(err3)
+	suite.NoError(err4)
+	suite.Equal(40.0, first)
+	suite.True(changed1)
+	suite.Equal(80.0, second)
+	suite.True(changed2)
+	suite.Equal(100.0, third)
+	suite.False(changed3)
+	suite.Equal(60.0, fourth)
+	suite.True(changed4)
+	suite.Equal(0.0, controller.State().Target)
+}
+
+func (suite *HeatTestSuite) TestHTTP() {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"zone":{"temperature":19.5,"setpoint":"21"}}`))
+	}))
+	defer server.Close()
+	signal := &heat.HTTP{
+		URL:              server.URL,
+		TemperatureField: "zone.temperature",
+		SetpointField:    "zone.setpoint",
+	}
+
+	// Act
+	reading, err := signal.Read(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(21.0, reading.Setpoint)
+	suite.Equal(19.5, reading.Temperature)
+	suite.Equal(1.5, reading.Demand())
+}
+
+func TestHeatTestSuite(t *testing.T) {
+	suite.Run(t, new(HeatTestSuite))
+}
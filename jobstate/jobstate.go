@@ -0,0 +1,140 @@
+// Package jobstate remembers the mining jobs submitted by this instance, so that only those are cancelled.
+package jobstate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/fileutil"
+)
+
+// Kinds of the mining jobs.
+const (
+	KindGPU = "gpu"
+	KindCPU = "cpu"
+)
+
+// Job is a job array submitted to Slurm.
+type Job struct {
+	// Kind is KindGPU or KindCPU.
+	Kind string `json:"kind"`
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Replicas is the number of array tasks not cancelled, numbered from 1.
+	Replicas  int       `json:"replicas"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// Store holds the last job of each kind, persisted in a JSON file if a path is given.
+// The zero Store keeps the jobs in memory.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// Open loads the jobs from path.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		jobs: make(map[string]Job),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, j := range jobs {
+		s.jobs[j.Kind] = j
+	}
+	return s, nil
+}
+
+// List returns the jobs sorted by kind.
+func (s *Store) List() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.jobs)
+}
+
+func sorted(jobs map[string]Job) []Job {
+	list := make([]Job, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, j)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Kind < list[j].Kind
+	})
+	return list
+}
+
+// Get returns the job of a kind.
+func (s *Store) Get(kind string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[kind]
+	return j, ok
+}
+
+// Put records a job, replacing the job of the same kind.
+func (s *Store) Put(j Job) error {
+	if j.Kind == "" || j.ID <= 0 {
+		return fmt.Errorf("invalid job %d of kind %q", j.ID, j.Kind)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs, err := fileutil.CopyOnWrite(s.jobs, func(jobs map[string]Job) {
+		jobs[j.Kind] = j
+	}, s.save)
+	if err != nil {
+		return err
+	}
+	s.jobs = jobs
+	return nil
+}
+
+// Delete forgets the job of a kind, if any.
+func (s *Store) Delete(kind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[kind]; !ok {
+		return nil
+	}
+	jobs, err := fileutil.CopyOnWrite(s.jobs, func(jobs map[string]Job) {
+		delete(jobs, kind)
+	}, s.save)
+	if err != nil {
+		return err
+	}
+	s.jobs = jobs
+	return nil
+}
+
+// save writes the jobs atomically.
+func (s *Store) save(jobs map[string]Job) error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sorted(jobs), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.path, data)
+}
@@ -0,0 +1,48 @@
+//go:build unit
+
+package jobstate_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/stretchr/testify/suite"
+)
+
+type JobStateTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *JobStateTestSuite) BeforeTest(suiteName, testName string) {
+	suite.path = filepath.Join(suite.T().TempDir(), "jobs.json")
+}
+
+func (suite *JobStateTestSuite) TestPersistence() {
+	// Arrange
+	store, err := jobstate.Open(suite.path)
+	suite.Require().NoError(err)
+	suite.Require().NoError(store.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 10, Name: "a-gpu-auto-mining", Replicas: 8}))
+	suite.Require().NoError(store.Put(jobstate.Job{Kind: jobstate.KindCPU, ID: 11, Name: "a-cpu-auto-mining", Replicas: 4}))
+	suite.Require().NoError(store.Put(jobstate.Job{Kind: jobstate.KindGPU, ID: 12, Name: "a-gpu-auto-mining", Replicas: 6}))
+
+	// Act
+	errInvalid := store.Put(jobstate.Job{Kind: jobstate.KindGPU})
+	errDelete := store.Delete(jobstate.KindCPU)
+	errDeleteAgain := store.Delete(jobstate.KindCPU)
+	reopened, errOpen := jobstate.Open(suite.path)
+
+	// Assert
+	suite.Error(errInvalid)
+	suite.NoError(errDelete)
+	suite.NoError(errDeleteAgain)
+	suite.NoError(errOpen)
+	suite.Equal([]jobstate.Job{{Kind: jobstate.KindGPU, ID: 12, Name: "a-gpu-auto-mining", Replicas: 6}}, reopened.List())
+	_, ok := reopened.Get(jobstate.KindCPU)
+	suite.False(ok)
+}
+
+func TestJobStateTestSuite(t *testing.T) {
+	suite.Run(t, &JobStateTestSuite{})
+}
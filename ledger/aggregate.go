@@ -0,0 +1,109 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Periods of the aggregates.
+const (
+	Daily   = "daily"
+	Monthly = "monthly"
+)
+
+// Aggregate sums the earnings over a period. Amounts are in BTC.
+type Aggregate struct {
+	// Period is formatted as 2006-01-02 for daily aggregates and 2006-01 for monthly aggregates.
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	// Earned is the amount mined during the period: the change of the unpaid amount plus the payouts and their fees.
+	Earned float64 `json:"earned"`
+	Paid   float64 `json:"paid"`
+	Fees   float64 `json:"fees"`
+	// Unpaid is the unpaid amount at the end of the period.
+	Unpaid float64 `json:"unpaid"`
+	// Profitability is the average profitability of each algorithm during the period, in BTC per day.
+	Profitability map[string]float64 `json:"profitability,omitempty"`
+}
+
+// PeriodStart truncates t to the start of its period, in the location of t.
+func PeriodStart(period string, t time.Time) (time.Time, error) {
+	switch period {
+	case Daily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), nil
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown period %q", period)
+}
+
+func periodName(period string, start time.Time) string {
+	if period == Monthly {
+		return start.Format("2006-01")
+	}
+	return start.Format("2006-01-02")
+}
+
+// Aggregate computes the earnings of each period between since and until.
+func (s *Store) Aggregate(period string, since, until time.Time) ([]Aggregate, error) {
+	if _, err := PeriodStart(period, time.Time{}); err != nil {
+		return nil, err
+	}
+
+	byStart := make(map[time.Time]*Aggregate)
+	get := func(t time.Time) *Aggregate {
+		start, _ := PeriodStart(period, t)
+		agg, ok := byStart[start]
+		if !ok {
+			agg = &Aggregate{
+				Period:        periodName(period, start),
+				Start:         start,
+				Profitability: make(map[string]float64),
+			}
+			byStart[start] = agg
+		}
+		return agg
+	}
+
+	// The unpaid amount increases while mining and decreases on payouts.
+	counts := make(map[time.Time]map[string]int)
+	var previous *Snapshot
+	for _, snap := range s.Snapshots(time.Time{}, until) {
+		snap := snap
+		if previous != nil && inRange(snap.Time, since, until) {
+			get(snap.Time).Earned += snap.Unpaid - previous.Unpaid
+		}
+		previous = &snap
+		if !inRange(snap.Time, since, until) {
+			continue
+		}
+		agg := get(snap.Time)
+		agg.Unpaid = snap.Unpaid
+		if counts[agg.Start] == nil {
+			counts[agg.Start] = make(map[string]int)
+		}
+		for algo, e := range snap.Algorithms {
+			agg.Profitability[algo] += e.Profitability
+			counts[agg.Start][algo]++
+		}
+	}
+	for _, p := range s.Payouts(since, until) {
+		agg := get(p.Time)
+		agg.Paid += p.Amount
+		agg.Fees += p.Fee
+		agg.Earned += p.Amount + p.Fee
+	}
+
+	aggs := make([]Aggregate, 0, len(byStart))
+	for start, agg := range byStart {
+		for algo, n := range counts[start] {
+			agg.Profitability[algo] /= float64(n)
+		}
+		aggs = append(aggs, *agg)
+	}
+	sort.Slice(aggs, func(i, j int) bool {
+		return aggs[i].Start.Before(aggs[j].Start)
+	})
+	return aggs, nil
+}
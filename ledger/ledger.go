@@ -0,0 +1,194 @@
+// Package ledger stores the mining earnings and aggregates them over time.
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot is the state of the mining account at a point in time. Amounts are in BTC.
+type Snapshot struct {
+	Time   time.Time `json:"time"`
+	Wallet string    `json:"wallet,omitempty"`
+	// Unpaid is the mined amount not paid out yet.
+	Unpaid float64 `json:"unpaid"`
+	// Balance is the total balance of the account.
+	Balance float64 `json:"balance"`
+	// Profitability is the current profitability of all the rigs, in BTC per day.
+	Profitability float64       `json:"profitability"`
+	Rigs          []RigEarnings `json:"rigs,omitempty"`
+	// Algorithms is indexed by NiceHash algorithm.
+	Algorithms map[string]AlgoEarnings `json:"algorithms,omitempty"`
+}
+
+type RigEarnings struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Unpaid        float64 `json:"unpaid"`
+	Profitability float64 `json:"profitability"`
+}
+
+type AlgoEarnings struct {
+	Unpaid        float64 `json:"unpaid"`
+	Profitability float64 `json:"profitability"`
+}
+
+// Payout is an amount paid out to the wallet. Amounts are in BTC.
+type Payout struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Amount float64   `json:"amount"`
+	Fee    float64   `json:"fee"`
+}
+
+// Store persists the snapshots and the payouts as JSONL files in a directory.
+type Store struct {
+	dir string
+
+	mu        sync.Mutex
+	snapshots []Snapshot
+	payouts   map[string]Payout
+}
+
+// Open loads the store in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:     dir,
+		payouts: make(map[string]Payout),
+	}
+	if err := readLines(s.path("snapshots.jsonl"), func(b []byte) error {
+		var snap Snapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return err
+		}
+		s.snapshots = append(s.snapshots, snap)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := readLines(s.path("payouts.jsonl"), func(b []byte) error {
+		var p Payout
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+		s.payouts[p.ID] = p
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(s.snapshots, func(i, j int) bool {
+		return s.snapshots[i].Time.Before(s.snapshots[j].Time)
+	})
+	return s, nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// AddSnapshot stores a snapshot.
+func (s *Store) AddSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := appendLine(s.path("snapshots.jsonl"), snap); err != nil {
+		return err
+	}
+	s.snapshots = append(s.snapshots, snap)
+	return nil
+}
+
+// AddPayouts stores the payouts which are not known yet.
+func (s *Store) AddPayouts(payouts []Payout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range payouts {
+		if _, ok := s.payouts[p.ID]; ok {
+			continue
+		}
+		if err := appendLine(s.path("payouts.jsonl"), p); err != nil {
+			return err
+		}
+		s.payouts[p.ID] = p
+	}
+	return nil
+}
+
+// Snapshots returns the snapshots taken in [since, until).
+func (s *Store) Snapshots(since, until time.Time) []Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Snapshot
+	for _, snap := range s.snapshots {
+		if inRange(snap.Time, since, until) {
+			out = append(out, snap)
+		}
+	}
+	return out
+}
+
+// Payouts returns the payouts made in [since, until), in chronological order.
+func (s *Store) Payouts(since, until time.Time) []Payout {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Payout
+	for _, p := range s.payouts {
+		if inRange(p.Time, since, until) {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+func inRange(t, since, until time.Time) bool {
+	return (since.IsZero() || !t.Before(since)) && (until.IsZero() || t.Before(until))
+}
+
+func appendLine(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readLines(path string, fn func([]byte) error) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
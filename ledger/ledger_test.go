@@ -0,0 +1,158 @@
+//go:build unit
+
+package ledger_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/ledger"
+	"github.com/squarefactory/miner-api/nicehash"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	apiKey         = "key"
+	apiSecret      = "secret"
+	organizationID = "org"
+)
+
+// fakeNiceHash is a stand-in of the NiceHash API which verifies the HMAC authentication.
+type fakeNiceHash struct {
+	unpaid  string
+	payouts string
+}
+
+func (f *fakeNiceHash) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, signature, _ := strings.Cut(r.Header.Get("X-Auth"), ":")
+	expected := nicehash.Signature(
+		apiSecret,
+		apiKey,
+		r.Header.Get("X-Time"),
+		r.Header.Get("X-Nonce"),
+		r.Header.Get("X-Organization-Id"),
+		r.Method,
+		r.URL.Path,
+		r.URL.RawQuery,
+	)
+	if key != apiKey || signature != expected || r.Header.Get("X-Organization-Id") != organizationID {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/main/api/v2/mining/rigs2":
+		_, _ = w.Write([]byte(`{"unpaidAmount":"` + f.unpaid + `","totalProfitability":0.0002,"miningRigs":[{"rigId":"rig-1","name":"cn1","unpaidAmount":"` + f.unpaid + `","profitability":0.0002}]}`))
+	case "/main/api/v2/mining/algo/stats":
+		_, _ = w.Write([]byte(`{"algorithms":{"KAWPOW":{"unpaid":"` + f.unpaid + `","profitability":0.0002}}}`))
+	case "/main/api/v2/accounting/account2/BTC":
+		_, _ = w.Write([]byte(`{"currency":"BTC","totalBalance":"0.01","available":"0.01","pending":"0"}`))
+	case "/main/api/v2/mining/rigs/payouts":
+		_, _ = w.Write([]byte(`{"list":[` + f.payouts + `]}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+type LedgerTestSuite struct {
+	suite.Suite
+	nicehash *fakeNiceHash
+	client   *nicehash.Client
+	impl     *ledger.Store
+}
+
+func (suite *LedgerTestSuite) BeforeTest(suiteName, testName string) {
+	suite.nicehash = &fakeNiceHash{unpaid: "0.001"}
+	server := httptest.NewServer(suite.nicehash)
+	suite.T().Cleanup(server.Close)
+	suite.client = &nicehash.Client{
+		URL:            server.URL,
+		APIKey:         apiKey,
+		APISecret:      apiSecret,
+		OrganizationID: organizationID,
+	}
+
+	var err error
+	suite.impl, err = ledger.Open(suite.T().TempDir())
+	suite.Require().NoError(err)
+}
+
+func (suite *LedgerTestSuite) TestSync() {
+	// Arrange
+	ctx := context.Background()
+	created := time.Now().UnixMilli()
+	suite.nicehash.payouts = `{"id":"p1","created":` + strconv.FormatInt(created, 10) + `,"amount":"0.0009","feeAmount":"0.0001"}`
+
+	// Act
+	err1 := suite.impl.Sync(ctx, suite.client, "wallet")
+	// The payout is deduced from the unpaid amount.
+	suite.nicehash.unpaid = "0.0005"
+	err2 := suite.impl.Sync(ctx, suite.client, "wallet")
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	snapshots := suite.impl.Snapshots(time.Time{}, time.Time{})
+	suite.Len(snapshots, 2)
+	suite.Equal(0.001, snapshots[0].Unpaid)
+	suite.Equal(0.01, snapshots[0].Balance)
+	suite.Equal("rig-1", snapshots[0].Rigs[0].ID)
+	suite.Equal(0.0002, snapshots[0].Algorithms["KAWPOW"].Profitability)
+	suite.Len(suite.impl.Payouts(time.Time{}, time.Time{}), 1)
+
+	aggs, err := suite.impl.Aggregate(ledger.Monthly, time.Time{}, time.Time{})
+	suite.NoError(err)
+	suite.Len(aggs, 1)
+	suite.Equal(time.Now().UTC().Format("2006-01"), aggs[0].Period)
+	suite.InDelta(0.0005, aggs[0].Earned, 1e-12)
+	suite.InDelta(0.0009, aggs[0].Paid, 1e-12)
+	suite.InDelta(0.0001, aggs[0].Fees, 1e-12)
+	suite.Equal(0.0005, aggs[0].Unpaid)
+	suite.Equal(0.0002, aggs[0].Profitability["KAWPOW"])
+}
+
+func (suite *LedgerTestSuite) TestSyncRejectsInvalidSecret() {
+	// Arrange
+	suite.client.APISecret = "invalid"
+
+	// Act
+	err := suite.impl.Sync(context.Background(), suite.client, "wallet")
+
+	// Assert
+	suite.ErrorContains(err, "401")
+}
+
+func (suite *LedgerTestSuite) TestReopen() {
+	// Arrange
+	dir := suite.T().TempDir()
+	store, err := ledger.Open(dir)
+	suite.Require().NoError(err)
+	day := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	suite.Require().NoError(store.AddSnapshot(ledger.Snapshot{Time: day, Unpaid: 0.001}))
+	suite.Require().NoError(store.AddSnapshot(ledger.Snapshot{Time: day.Add(24 * time.Hour), Unpaid: 0.003}))
+	suite.Require().NoError(store.AddPayouts([]ledger.Payout{{ID: "p1", Time: day, Amount: 0.01}}))
+	suite.Require().NoError(store.AddPayouts([]ledger.Payout{{ID: "p1", Time: day, Amount: 0.01}}))
+
+	// Act
+	reopened, err := ledger.Open(dir)
+	suite.Require().NoError(err)
+	aggs, err := reopened.Aggregate(ledger.Daily, time.Time{}, time.Time{})
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(reopened.Payouts(time.Time{}, time.Time{}), 1)
+	suite.Len(aggs, 2)
+	suite.Equal("2023-06-01", aggs[0].Period)
+	suite.Equal(0.01, aggs[0].Paid)
+	suite.Equal("2023-06-02", aggs[1].Period)
+	suite.InDelta(0.002, aggs[1].Earned, 1e-12)
+}
+
+func TestLedgerTestSuite(t *testing.T) {
+	suite.Run(t, &LedgerTestSuite{})
+}
@@ -0,0 +1,65 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"github.com/squarefactory/miner-api/nicehash"
+)
+
+// Sync fetches the state of the NiceHash account and stores a snapshot and the new payouts.
+func (s *Store) Sync(ctx context.Context, client *nicehash.Client, wallet string) error {
+	rigs, err := client.Rigs(ctx)
+	if err != nil {
+		return err
+	}
+	algos, err := client.AlgoStats(ctx)
+	if err != nil {
+		return err
+	}
+	account, err := client.Account(ctx, "BTC")
+	if err != nil {
+		return err
+	}
+	payouts, err := client.Payouts(ctx, 100)
+	if err != nil {
+		return err
+	}
+
+	snap := Snapshot{
+		Time:          time.Now().UTC(),
+		Wallet:        wallet,
+		Unpaid:        float64(rigs.UnpaidAmount),
+		Balance:       float64(account.TotalBalance),
+		Profitability: float64(rigs.TotalProfitability),
+		Algorithms:    make(map[string]AlgoEarnings, len(algos.Algorithms)),
+	}
+	for _, rig := range rigs.MiningRigs {
+		snap.Rigs = append(snap.Rigs, RigEarnings{
+			ID:            rig.RigID,
+			Name:          rig.Name,
+			Unpaid:        float64(rig.UnpaidAmount),
+			Profitability: float64(rig.Profitability),
+		})
+	}
+	for algo, stats := range algos.Algorithms {
+		snap.Algorithms[algo] = AlgoEarnings{
+			Unpaid:        float64(stats.Unpaid),
+			Profitability: float64(stats.Profitability),
+		}
+	}
+	if err := s.AddSnapshot(snap); err != nil {
+		return err
+	}
+
+	newPayouts := make([]Payout, 0, len(payouts.List))
+	for _, p := range payouts.List {
+		newPayouts = append(newPayouts, Payout{
+			ID:     p.ID,
+			Time:   time.UnixMilli(p.Created).UTC(),
+			Amount: float64(p.Amount),
+			Fee:    float64(p.FeeAmount),
+		})
+	}
+	return s.AddPayouts(newPayouts)
+}
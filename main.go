@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,8 +16,22 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/squarefactory/miner-api/api"
+	"github.com/squarefactory/miner-api/audit"
 	"github.com/squarefactory/miner-api/autoswitch"
+	"github.com/squarefactory/miner-api/calendar"
+	"github.com/squarefactory/miner-api/executor"
+	"github.com/squarefactory/miner-api/heat"
+	"github.com/squarefactory/miner-api/jobstate"
+	"github.com/squarefactory/miner-api/ledger"
+	"github.com/squarefactory/miner-api/monitor"
+	"github.com/squarefactory/miner-api/nicehash"
+	"github.com/squarefactory/miner-api/price"
+	"github.com/squarefactory/miner-api/report"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/squarefactory/miner-api/stratum"
+	"github.com/squarefactory/miner-api/wallet"
 	"gopkg.in/yaml.v3"
 )
 
@@ -33,13 +49,136 @@ func main() {
 	if err := yaml.Unmarshal(cb, &config); err != nil {
 		log.Fatal(err)
 	}
+	if err := config.General.ValidateMode(); err != nil {
+		log.Fatal(err)
+	}
+	if err := config.Workers.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range config.GetPools() {
+		if err := p.WorkerLimits.Validate(); err != nil {
+			log.Fatalf("pool %s: %s", p.Name, err)
+		}
+		for _, address := range p.Wallets {
+			if err := wallet.ValidateAddress(address); err != nil {
+				log.Fatalf("pool %s: %s", p.Name, err)
+			}
+		}
+	}
+
+	exec, err := newExecutor(config.Executor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The logs are read without the audit, the metrics and the retries, as they are polled while followed.
+	api.ConfigureLogs(exec)
+	var auditLog *audit.Log
+	if config.Audit.Path != "" {
+		auditLog, err = audit.NewLog(config.Audit.Path, config.Audit.MaxSizeBytes, config.Audit.MaxBackups)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer auditLog.Close()
+		exec = audit.NewExecutor(exec, auditLog, config.Audit.MaxOutputBytes)
+	}
+	breaker := &scheduler.CircuitBreaker{
+		Threshold: config.Retry.CircuitBreaker.Threshold,
+		Cooldown:  config.Retry.CircuitBreaker.Cooldown,
+	}
+	if breaker.Threshold == 0 {
+		breaker.Threshold = 5
+	}
+	if breaker.Cooldown == 0 {
+		breaker.Cooldown = time.Minute
+	}
+	exec = scheduler.NewRetryExecutor(executor.NewMetered(exec), newRetryPolicy(config.Retry), breaker)
+	api.Configure(exec, config.Executor.User)
+	cancelSignal := config.Cancel.Signal
+	switch cancelSignal {
+	case "":
+		cancelSignal = api.DefaultCancelSignal
+	case "none":
+		cancelSignal = ""
+	}
+	cancelGracePeriod := config.Cancel.GracePeriod
+	if cancelGracePeriod == 0 {
+		cancelGracePeriod = 30 * time.Second
+	}
+	if err := api.ConfigureCancel(cancelSignal, cancelGracePeriod); err != nil {
+		log.Fatal(err)
+	}
+	jobs, err := jobstate.Open(config.Instance.StatePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := api.ConfigureInstance(config.Instance.Name, jobs); err != nil {
+		log.Fatal(err)
+	}
+	if err := api.ConfigurePlacement(api.Placements{
+		GPU: newPlacement(config.Jobs.GPU),
+		CPU: newPlacement(config.Jobs.CPU),
+	}); err != nil {
+		log.Fatal(err)
+	}
+	collector := &monitor.Collector{
+		GminerBasePort: config.Monitor.GminerBasePort,
+		XmrigBasePort:  config.Monitor.XmrigBasePort,
+	}
+	if collector.GminerBasePort == 0 {
+		collector.GminerBasePort = 10050
+	}
+	if collector.XmrigBasePort == 0 {
+		collector.XmrigBasePort = 10450
+	}
+	collector.XmrigAccessToken = config.Monitor.XmrigAccessToken
+	if collector.XmrigAccessToken == "" {
+		if collector.XmrigAccessToken, err = monitor.NewAccessToken(); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := monitor.ValidateAccessToken(collector.XmrigAccessToken); err != nil {
+		log.Fatal(err)
+	}
+	var analyzer *monitor.LogAnalyzer
+	if config.Monitor.Logs.Enabled {
+		analyzer = &monitor.LogAnalyzer{
+			MaxBytes:             config.Monitor.Logs.MaxBytes,
+			RestartLoopThreshold: config.Monitor.Logs.RestartLoopThreshold,
+		}
+	}
+	api.ConfigureMonitor(collector, analyzer)
 
+	var earnings *ledger.Store
+	if config.Earnings.Path != "" {
+		earnings, err = ledger.Open(config.Earnings.Path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	niceHash := &nicehash.Client{
+		URL:            config.NiceHash.URL,
+		APIKey:         config.NiceHash.APIKey,
+		APISecret:      config.NiceHash.APISecret,
+		OrganizationID: config.NiceHash.OrganizationID,
+	}
 	switcher := &autoswitch.Switcher{
 		Config: &config,
+		Rates:  niceHash,
+	}
+	poolChecker := &stratum.Checker{Timeout: config.PoolProbe.Timeout}
+	switcher.Health = poolChecker
+	prices, err := newPriceSource(config.Prices)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if prices != nil {
+		switcher.Prices = &price.Cache{Source: prices, TTL: config.Prices.CacheTTL}
 	}
 	r := chi.NewRouter()
 
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
+	r.Use(audit.Middleware)
 
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		render.HTML(w, r, f)
@@ -49,7 +188,96 @@ func main() {
 		api.MineStart(w, r, switcher)
 	})
 	r.Post("/stop", api.MineStop)
-	r.Get("/health", api.Health)
+	r.Handle("/metrics", promhttp.Handler())
+	r.Get("/api/v1/status", api.GetStatus)
+	r.Get("/api/v1/jobs/{id}/tasks/{task}/logs", api.TaskLogs)
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		api.Health(w, r, breaker)
+	})
+	if auditLog != nil {
+		r.Get("/api/v1/audit", func(w http.ResponseWriter, r *http.Request) {
+			api.Audit(w, r, auditLog)
+		})
+	}
+
+	profit := &report.Generator{
+		Energy:   scheduler.NewSlurm(exec, config.Executor.User),
+		User:     config.Executor.User,
+		JobNames: api.JobNames(),
+		PriceSpan: func(t, limit time.Time) (float64, time.Time) {
+			tariff, until := switcher.TariffSpan(context.Background(), t, limit)
+			return tariff.PricePerKwh, until
+		},
+		Currency: config.Currency(),
+	}
+	if earnings != nil {
+		profit.Ledger = earnings
+		profit.Rates = niceHash
+	}
+	var heatController *heat.Controller
+	if config.Heat.URL != "" {
+		heatController, err = newHeatController(config.Heat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Get("/api/v1/heat", func(w http.ResponseWriter, r *http.Request) {
+			api.HeatState(w, r, heatController)
+		})
+	}
+	windows := make([]calendar.Window, 0, len(config.Schedules.Windows))
+	for _, w := range config.Schedules.Windows {
+		windows = append(windows, calendar.Window{
+			Name:     w.Name,
+			Schedule: w.Schedule,
+			Usage:    w.Usage,
+			Wallet:   w.Wallet,
+			Algo:     w.Algo,
+		})
+	}
+	schedules, err := calendar.Open(config.Schedules.Path, windows)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.Get("/api/v1/schedules", func(w http.ResponseWriter, r *http.Request) {
+		api.ListWindows(w, r, schedules)
+	})
+	r.Put("/api/v1/schedules/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.PutWindow(w, r, schedules)
+	})
+	r.Delete("/api/v1/schedules/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.DeleteWindow(w, r, schedules)
+	})
+	r.Get("/api/v1/placement", api.GetPlacement)
+	r.Put("/api/v1/placement", api.PutPlacement)
+	wallets, err := wallet.Open(config.Wallets.Path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.ConfigureWallets(wallets)
+	r.Get("/api/v1/wallets", func(w http.ResponseWriter, r *http.Request) {
+		api.ListWallets(w, r, wallets)
+	})
+	r.Get("/api/v1/wallets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.GetWallet(w, r, wallets)
+	})
+	r.Put("/api/v1/wallets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.PutWallet(w, r, wallets)
+	})
+	r.Delete("/api/v1/wallets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		api.DeleteWallet(w, r, wallets)
+	})
+	r.Get("/api/v1/pools", func(w http.ResponseWriter, r *http.Request) {
+		api.PoolStatus(w, r, poolChecker)
+	})
+	r.Get("/api/v1/reports/profit", func(w http.ResponseWriter, r *http.Request) {
+		api.ProfitReport(w, r, profit)
+	})
+
+	if earnings != nil {
+		r.Get("/api/v1/earnings", func(w http.ResponseWriter, r *http.Request) {
+			api.Earnings(w, r, earnings)
+		})
+	}
 
 	listenAddress := os.Getenv("LISTEN_ADDRESS")
 	if len(listenAddress) == 0 {
@@ -71,9 +299,121 @@ func main() {
 		}
 	}()
 
+	go func() {
+		ctx := audit.WithTrigger(context.Background(), "startup:preemption")
+		if err := api.CheckPreemption(ctx); err != nil {
+			log.Printf("mining jobs may not be preempted: %s", err)
+		}
+	}()
+
 	// context for the relaunch job goroutine
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	ctx = audit.WithTrigger(ctx, "tick:autoswitch")
+
+	go func() {
+		ctx := audit.WithTrigger(ctx, "tick:monitor")
+		interval := config.Monitor.Interval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := api.Refresh(ctx); err != nil {
+				log.Printf("failed to refresh status: %s", err)
+			}
+			if err := api.Reconcile(audit.WithTrigger(ctx, "tick:reconcile"), switcher); err != nil {
+				log.Printf("failed to reconcile jobs: %s", err)
+			}
+			<-ticker.C
+		}
+	}()
+
+	if earnings != nil {
+		go func() {
+			interval := config.Earnings.Interval
+			if interval == 0 {
+				interval = 15 * time.Minute
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				if err := earnings.Sync(ctx, niceHash, config.NiceHash.Wallet); err != nil {
+					log.Printf("failed to sync earnings: %s", err)
+				}
+				<-ticker.C
+			}
+		}()
+	}
+
+	go func() {
+		interval := config.PoolProbe.Interval
+		if interval == 0 {
+			interval = time.Minute
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			api.ProbePools(ctx, switcher, poolChecker)
+			<-ticker.C
+		}
+	}()
+
+	if switcher.Prices != nil || len(config.Tariffs) > 0 {
+		go func() {
+			ctx := audit.WithTrigger(ctx, "tick:prices")
+			interval := config.Prices.Interval
+			if interval == 0 {
+				interval = 15 * time.Minute
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				<-ticker.C
+				if err := api.CheckProfitability(ctx, switcher); err != nil {
+					log.Printf("failed to check profitability: %s", err)
+				}
+			}
+		}()
+	}
+
+	if heatController != nil {
+		go func() {
+			ctx := audit.WithTrigger(ctx, "tick:heat")
+			interval := config.Heat.Interval
+			if interval == 0 {
+				interval = 5 * time.Minute
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				<-ticker.C
+				if err := api.RegulateHeat(ctx, switcher, heatController); err != nil {
+					log.Printf("failed to regulate heat: %s", err)
+				}
+			}
+		}()
+	}
+
+	go func() {
+		ctx := audit.WithTrigger(ctx, "tick:schedule")
+		// The windows are cron schedules: their boundaries are on the minute.
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if err := api.ApplySchedule(ctx, switcher, schedules); err != nil {
+				log.Printf("failed to apply schedule: %s", err)
+			}
+			<-ticker.C
+		}
+	}()
 
 	go func() {
 		ticker := time.NewTicker(time.Duration(switcher.Config.General.PollingFrequency) * time.Minute)
@@ -81,9 +421,7 @@ func main() {
 
 		for {
 			<-ticker.C
-			log.Printf("autoswitch: restarting miners now")
-			err := api.RestartMiners(ctx, switcher)
-			if err != nil {
+			if err := api.Autoswitch(ctx, switcher); err != nil {
 				log.Printf("failed to restart jobs: %s", err)
 			}
 		}
@@ -92,3 +430,109 @@ func main() {
 	wg.Wait()
 
 }
+
+// newHeatController returns the controller of the heat-demand mode.
+func newHeatController(config autoswitch.Heat) (*heat.Controller, error) {
+	curve := make(heat.Curve, 0, len(config.Curve))
+	for _, p := range config.Curve {
+		curve = append(curve, heat.Point{Demand: p.Demand, Usage: p.Usage})
+	}
+	if err := curve.Validate(); err != nil {
+		return nil, err
+	}
+	return &heat.Controller{
+		Signal: &heat.HTTP{
+			URL:              config.URL,
+			TemperatureField: config.TemperatureField,
+			SetpointField:    config.SetpointField,
+			Setpoint:         config.Setpoint,
+		},
+		Curve:     curve,
+		MaxStep:   config.MaxStep,
+		MinChange: config.MinChange,
+	}, nil
+}
+
+// newPriceSource returns the source of the electricity price feed, or nil if it is not configured.
+func newPriceSource(config autoswitch.Prices) (price.Source, error) {
+	format := price.Format{
+		Type:       config.Format,
+		ListField:  config.ListField,
+		TimeField:  config.TimeField,
+		PriceField: config.PriceField,
+	}
+	switch {
+	case config.Unit == "", strings.EqualFold(config.Unit, "kWh"):
+	case strings.EqualFold(config.Unit, "MWh"):
+		format.Divisor = 1000
+	default:
+		return nil, fmt.Errorf("unknown price unit %q", config.Unit)
+	}
+	switch {
+	case config.URL != "":
+		return &price.HTTP{URL: config.URL, Format: format}, nil
+	case config.Path != "":
+		return &price.File{Path: config.Path, Format: format}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func newPlacement(config autoswitch.Placement) scheduler.Placement {
+	return scheduler.Placement{
+		Partition:   config.Partition,
+		Account:     config.Account,
+		Reservation: config.Reservation,
+		Constraint:  config.Constraint,
+		Exclude:     config.Exclude,
+		NodeList:    config.NodeList,
+		Time:        config.Time,
+		Nice:        config.Nice,
+	}
+}
+
+func newExecutor(config autoswitch.Executor) (scheduler.Executor, error) {
+	switch config.Type {
+	case "", "shell":
+		return &executor.Shell{
+			WorkDir:        config.WorkDir,
+			Path:           config.Path,
+			Timeout:        config.Timeout,
+			MaxOutputBytes: config.MaxOutputBytes,
+		}, nil
+	case "ssh":
+		return executor.NewSSH(executor.SSHConfig{
+			Address:         config.SSH.Address,
+			User:            config.SSH.User,
+			PrivateKeyPath:  config.SSH.PrivateKeyPath,
+			CertificatePath: config.SSH.CertificatePath,
+			KnownHostsPath:  config.SSH.KnownHostsPath,
+			WorkDir:         config.WorkDir,
+			DialTimeout:     config.SSH.DialTimeout,
+			Timeout:         config.Timeout,
+			MaxOutputBytes:  config.MaxOutputBytes,
+		})
+	default:
+		return nil, fmt.Errorf("unknown executor type %q", config.Type)
+	}
+}
+
+func newRetryPolicy(config autoswitch.Retry) scheduler.RetryPolicy {
+	policy := scheduler.DefaultRetryPolicy
+	if config.MaxAttempts > 0 {
+		policy.MaxAttempts = config.MaxAttempts
+	}
+	if config.InitialBackoff > 0 {
+		policy.InitialBackoff = config.InitialBackoff
+	}
+	if config.MaxBackoff > 0 {
+		policy.MaxBackoff = config.MaxBackoff
+	}
+	if config.Multiplier > 0 {
+		policy.Multiplier = config.Multiplier
+	}
+	if config.Jitter > 0 {
+		policy.Jitter = config.Jitter
+	}
+	return policy
+}
@@ -0,0 +1,231 @@
+// Package metrics declares the Prometheus metrics of miner-api.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "miner_api"
+
+var (
+	// MiningDesired is 1 when mining has been requested.
+	MiningDesired = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mining_desired",
+		Help:      "Whether mining is requested (1) or not (0).",
+	})
+
+	// MiningActual is 1 when mining tasks are running on the cluster.
+	MiningActual = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mining_running",
+		Help:      "Whether mining tasks are running on the cluster (1) or not (0).",
+	})
+
+	// Algorithm is an info metric of the current GPU mining algorithm.
+	Algorithm = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "algorithm_info",
+		Help:      "Current GPU mining algorithm.",
+	}, []string{"algo"})
+
+	// ReplicasRequested is the number of mining tasks submitted, by kind (gpu or cpu).
+	ReplicasRequested = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replicas_requested",
+		Help:      "Number of mining tasks requested.",
+	}, []string{"kind"})
+
+	// ReplicasRunning is the number of mining tasks running, by kind (gpu or cpu).
+	ReplicasRunning = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replicas_running",
+		Help:      "Number of mining tasks running.",
+	}, []string{"kind"})
+
+	// ReplicasPreempted is the number of mining tasks preempted and waiting to be requeued, by kind (gpu or cpu).
+	ReplicasPreempted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replicas_preempted",
+		Help:      "Number of mining tasks preempted and waiting to be requeued.",
+	}, []string{"kind"})
+
+	// Resubmissions counts the mining jobs resubmitted after they were gone, like when preempted.
+	Resubmissions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resubmissions_total",
+		Help:      "Number of resubmissions of the mining jobs after they were gone.",
+	})
+
+	// SwitchDecisions counts the decisions of the autoswitch, by selected algorithm and reason.
+	SwitchDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "autoswitch_decisions_total",
+		Help:      "Autoswitch decisions by selected algorithm and reason.",
+	}, []string{"algo", "reason"})
+
+	// Profitability is the estimated revenue of each algorithm on each pool.
+	Profitability = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "algorithm_revenue_btc_per_day",
+		Help:      "Estimated revenue of the algorithm on the pool in BTC per day, after the pool fee.",
+	}, []string{"algo", "pool"})
+
+	// UpstreamDuration is the latency of the requests to the upstream APIs.
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of the requests to the upstream APIs.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"upstream"})
+
+	// UpstreamErrors counts the failed requests to the upstream APIs.
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_errors_total",
+		Help:      "Failed requests to the upstream APIs.",
+	}, []string{"upstream"})
+
+	// SlurmCommandDuration is the latency of the Slurm commands, by subcommand.
+	SlurmCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "slurm_command_duration_seconds",
+		Help:      "Latency of the Slurm commands.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command"})
+
+	// SlurmCommandErrors counts the failed Slurm commands, by subcommand.
+	SlurmCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slurm_command_errors_total",
+		Help:      "Failed Slurm commands.",
+	}, []string{"command"})
+
+	// SlurmRetries counts the retried Slurm commands.
+	SlurmRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slurm_command_retries_total",
+		Help:      "Retries of transient Slurm failures.",
+	}, []string{"command"})
+
+	// SlurmCircuitOpen is 1 when the Slurm circuit breaker is open.
+	SlurmCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slurm_circuit_breaker_open",
+		Help:      "Whether the Slurm circuit breaker is open (1) or not (0).",
+	})
+
+	lastSwitchMu sync.Mutex
+	lastSwitch   time.Time
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "seconds_since_last_switch",
+		Help:      "Seconds since the mining jobs were last (re)started successfully. -1 if never.",
+	}, func() float64 {
+		lastSwitchMu.Lock()
+		defer lastSwitchMu.Unlock()
+		if lastSwitch.IsZero() {
+			return -1
+		}
+		return time.Since(lastSwitch).Seconds()
+	})
+)
+
+// SetAlgorithm records algo as the current algorithm.
+func SetAlgorithm(algo string) {
+	Algorithm.Reset()
+	Algorithm.WithLabelValues(algo).Set(1)
+}
+
+// SwitchSucceeded records a successful (re)start of the mining jobs.
+func SwitchSucceeded() {
+	lastSwitchMu.Lock()
+	defer lastSwitchMu.Unlock()
+	lastSwitch = time.Now()
+}
+
+// ObserveUpstream records a request to an upstream API.
+func ObserveUpstream(upstream string, start time.Time, err error) {
+	UpstreamDuration.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+	if err != nil {
+		UpstreamErrors.WithLabelValues(upstream).Inc()
+	}
+}
+
+// BoolToFloat converts b to 1 or 0.
+func BoolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var minerLabels = []string{"miner", "job", "task", "node"}
+
+var (
+	// MinerHashrate is the hashrate reported by each miner in H/s.
+	MinerHashrate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_hashrate_hashes_per_second",
+		Help:      "Hashrate reported by the miner.",
+	}, minerLabels)
+
+	// MinerShares is the number of shares reported by each miner, by status (accepted or rejected).
+	MinerShares = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_shares",
+		Help:      "Shares reported by the miner since it started.",
+	}, append(minerLabels, "status"))
+
+	// MinerTemperature is the maximum temperature of the devices of each miner.
+	MinerTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_temperature_celsius",
+		Help:      "Maximum temperature of the devices of the miner.",
+	}, minerLabels)
+
+	// MinerPower is the power draw of the devices of each miner.
+	MinerPower = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_power_watts",
+		Help:      "Power draw of the devices of the miner.",
+	}, minerLabels)
+)
+
+var (
+	// MinerHealth is 1 for the health status of each miner computed from its log.
+	MinerHealth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_health_status",
+		Help:      "Health status of the miner computed from its log (unknown, healthy, degraded or failing).",
+	}, append(minerLabels, "status"))
+
+	// MinerRestarts is the number of restarts of each miner found in its log.
+	MinerRestarts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "miner_log_restarts",
+		Help:      "Restarts of the miner by the retry loop of the job, within the tailed log.",
+	}, minerLabels)
+)
+
+var poolLabels = []string{"pool", "address"}
+
+var (
+	// PoolUp is the result of the last stratum probe of each pool address.
+	PoolUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_up",
+		Help:      "1 if the last stratum handshake with the pool succeeded.",
+	}, poolLabels)
+	// PoolLatency is the latency of the last successful stratum probe.
+	PoolLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pool_latency_seconds",
+		Help:      "Duration of the connection and mining.subscribe to the pool.",
+	}, poolLabels)
+)
@@ -4,6 +4,7 @@ package mocks
 
 import (
 	context "context"
+	io "io"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -13,23 +14,23 @@ type Executor struct {
 	mock.Mock
 }
 
-// ExecAs provides a mock function with given fields: ctx, user, cmd
-func (_m *Executor) ExecAs(ctx context.Context, user string, cmd string) (string, error) {
-	ret := _m.Called(ctx, user, cmd)
+// Run provides a mock function with given fields: ctx, user, argv, stdin
+func (_m *Executor) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	ret := _m.Called(ctx, user, argv, stdin)
 
 	var r0 string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) (string, error)); ok {
-		return rf(ctx, user, cmd)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string, io.Reader) (string, error)); ok {
+		return rf(ctx, user, argv, stdin)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) string); ok {
-		r0 = rf(ctx, user, cmd)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []string, io.Reader) string); ok {
+		r0 = rf(ctx, user, argv, stdin)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
-		r1 = rf(ctx, user, cmd)
+	if rf, ok := ret.Get(1).(func(context.Context, string, []string, io.Reader) error); ok {
+		r1 = rf(ctx, user, argv, stdin)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -0,0 +1,257 @@
+package monitor
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// Health statuses of a task.
+const (
+	HealthUnknown  = "unknown"
+	HealthHealthy  = "healthy"
+	HealthDegraded = "degraded"
+	HealthFailing  = "failing"
+)
+
+// TaskHealth is the health of an array task, computed from its log.
+type TaskHealth struct {
+	JobID  int    `json:"jobId"`
+	TaskID int    `json:"taskId"`
+	Node   string `json:"node"`
+	Miner  string `json:"miner"`
+	Status string `json:"status"`
+	// Hashrate is the last hashrate reported in the log, in H/s.
+	Hashrate       float64 `json:"hashrate"`
+	AcceptedShares int     `json:"acceptedShares"`
+	RejectedShares int     `json:"rejectedShares"`
+	ConnectionLost int     `json:"connectionLost"`
+	StratumErrors  int     `json:"stratumErrors"`
+	Crashes        int     `json:"crashes"`
+	// Restarts counts the restarts of the miner by the retry loop of the job.
+	Restarts    int       `json:"restarts"`
+	RestartLoop bool      `json:"restartLoop"`
+	Reasons     []string  `json:"reasons,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// LogReader reads the logs of the array tasks.
+type LogReader interface {
+	TailLog(ctx context.Context, req *scheduler.TailLogRequest) (string, error)
+}
+
+// LogAnalyzer tails the log of each active task and computes its health.
+type LogAnalyzer struct {
+	Reader LogReader
+	// User owning the jobs.
+	User string
+	// MaxBytes is the number of bytes read from the end of each log.
+	MaxBytes int
+	// RestartLoopThreshold is the number of restarts within the tailed log flagged as a restart loop.
+	RestartLoopThreshold int
+
+	mu     sync.Mutex
+	health []TaskHealth
+}
+
+// patterns are the events extracted from the log of a miner.
+type patterns struct {
+	hashrate       *regexp.Regexp
+	accepted       *regexp.Regexp
+	rejected       *regexp.Regexp
+	connectionLost *regexp.Regexp
+	stratumError   *regexp.Regexp
+	crash          *regexp.Regexp
+}
+
+var minerPatterns = map[string]patterns{
+	// Total Speed: 24.35 MH/s Shares Accepted: 12 Rejected: 0 Stale: 0 Power: 120W 0.20 MH/J
+	MinerGminer: {
+		hashrate:       regexp.MustCompile(`Total Speed: ([0-9.]+) ([kMGT]?)H/s`),
+		accepted:       regexp.MustCompile(`Share #\d+ accepted`),
+		rejected:       regexp.MustCompile(`Share #\d+ rejected`),
+		connectionLost: regexp.MustCompile(`(?i)(connection (to pool )?lost|disconnected from|failed to connect)`),
+		stratumError:   regexp.MustCompile(`(?i)(stratum error|pool returned error|authorization failed)`),
+		crash:          regexp.MustCompile(`(?i)(cuda error|opencl error|cl_out_of_resources|out of memory|illegal memory access|segmentation fault)`),
+	},
+	// [2023-06-01 10:00:00.000]  miner    speed 10s/60s/15m 5120.5 5000.1 n/a H/s max 5300.2 H/s
+	// [2023-06-01 10:00:00.000]  cpu      accepted (17/2) diff 100001 (45 ms)
+	MinerXmrig: {
+		hashrate:       regexp.MustCompile(`speed 10s/60s/15m ([0-9.]+|n/a) ([0-9.]+|n/a)? ?([0-9.]+|n/a)? ?([kMGT]?)H/s`),
+		accepted:       regexp.MustCompile(`\saccepted \(\d+/\d+\)`),
+		rejected:       regexp.MustCompile(`\srejected \(\d+/\d+\)`),
+		connectionLost: regexp.MustCompile(`(?i)(read error|connection reset|connect error|no active pools)`),
+		stratumError:   regexp.MustCompile(`(?i)(login error code|job error|stratum error)`),
+		crash:          regexp.MustCompile(`(?i)(opencl error|cuda error|segmentation fault|out of memory|illegal instruction)`),
+	},
+}
+
+// restartPattern matches the retry loop of the job templates.
+var restartPattern = regexp.MustCompile(`Script exited with code (\d+)\. Retrying\.\.\.`)
+
+var unitMultipliers = map[string]float64{
+	"":  1,
+	"k": 1e3,
+	"M": 1e6,
+	"G": 1e9,
+	"T": 1e12,
+}
+
+// Analyze computes the health of a task from its log.
+func (a *LogAnalyzer) Analyze(miner string, task scheduler.Task, log string) TaskHealth {
+	h := TaskHealth{
+		JobID:     task.JobID,
+		TaskID:    task.TaskID,
+		Node:      task.Node,
+		Miner:     miner,
+		UpdatedAt: time.Now(),
+	}
+	p, ok := minerPatterns[miner]
+	if !ok {
+		h.Status = HealthUnknown
+		return h
+	}
+
+	// A crash is failing only if the miner did not report a hashrate afterwards.
+	crashedSinceHashing := false
+	for _, line := range strings.Split(log, "\n") {
+		switch {
+		case restartPattern.MatchString(line):
+			h.Restarts++
+		case p.crash.MatchString(line):
+			h.Crashes++
+			crashedSinceHashing = true
+		case p.connectionLost.MatchString(line):
+			h.ConnectionLost++
+		case p.stratumError.MatchString(line):
+			h.StratumErrors++
+		case p.rejected.MatchString(line):
+			h.RejectedShares++
+		case p.accepted.MatchString(line):
+			h.AcceptedShares++
+		}
+		if hashrate, ok := parseHashrate(p.hashrate, line); ok {
+			h.Hashrate = hashrate
+			crashedSinceHashing = false
+		}
+	}
+
+	threshold := a.RestartLoopThreshold
+	if threshold <= 0 {
+		threshold = 3
+	}
+	h.RestartLoop = h.Restarts >= threshold
+
+	switch {
+	case strings.TrimSpace(log) == "":
+		h.Status = HealthUnknown
+		h.Reasons = append(h.Reasons, "empty log")
+	case h.RestartLoop:
+		h.Status = HealthFailing
+		h.Reasons = append(h.Reasons, "restart loop")
+	case crashedSinceHashing:
+		h.Status = HealthFailing
+		h.Reasons = append(h.Reasons, "miner crashed")
+	case h.Hashrate == 0:
+		h.Status = HealthDegraded
+		h.Reasons = append(h.Reasons, "no hashrate")
+	default:
+		h.Status = HealthHealthy
+	}
+	if h.Status == HealthHealthy {
+		if h.ConnectionLost > 0 || h.StratumErrors > 0 {
+			h.Status = HealthDegraded
+			h.Reasons = append(h.Reasons, "pool connection errors")
+		}
+		if total := h.AcceptedShares + h.RejectedShares; total > 0 && float64(h.RejectedShares)/float64(total) > 0.1 {
+			h.Status = HealthDegraded
+			h.Reasons = append(h.Reasons, "more than 10% rejected shares")
+		}
+	}
+	return h
+}
+
+// parseHashrate extracts the first available hashrate of a line, in H/s.
+func parseHashrate(re *regexp.Regexp, line string) (float64, bool) {
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		return 0, false
+	}
+	unit := m[len(m)-1]
+	for _, v := range m[1 : len(m)-1] {
+		if v == "" || v == "n/a" {
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+		return f * unitMultipliers[unit], true
+	}
+	return 0, false
+}
+
+// AnalyzeAll tails the log of the running tasks of each miner and stores their health.
+func (a *LogAnalyzer) AnalyzeAll(ctx context.Context, tasks map[string][]scheduler.Task) []TaskHealth {
+	maxBytes := a.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 64 * 1024
+	}
+
+	var health []TaskHealth
+	for miner, minerTasks := range tasks {
+		for _, task := range minerTasks {
+			if task.State != scheduler.TaskRunning || task.TaskID < 0 {
+				continue
+			}
+			out, err := a.Reader.TailLog(ctx, &scheduler.TailLogRequest{
+				User:     a.User,
+				ID:       task.ID,
+				TaskID:   task.TaskID,
+				MaxBytes: maxBytes,
+			})
+			var h TaskHealth
+			if err != nil {
+				h = TaskHealth{
+					JobID:     task.JobID,
+					TaskID:    task.TaskID,
+					Node:      task.Node,
+					Miner:     miner,
+					Status:    HealthUnknown,
+					Error:     err.Error(),
+					UpdatedAt: time.Now(),
+				}
+			} else {
+				h = a.Analyze(miner, task, out)
+			}
+			health = append(health, h)
+		}
+	}
+
+	metrics.MinerHealth.Reset()
+	metrics.MinerRestarts.Reset()
+	for _, h := range health {
+		labels := []string{h.Miner, strconv.Itoa(h.JobID), strconv.Itoa(h.TaskID), h.Node}
+		metrics.MinerHealth.WithLabelValues(append(labels, h.Status)...).Set(1)
+		metrics.MinerRestarts.WithLabelValues(labels...).Set(float64(h.Restarts))
+	}
+
+	a.mu.Lock()
+	a.health = health
+	a.mu.Unlock()
+	return health
+}
+
+// Health returns the results of the last analysis.
+func (a *LogAnalyzer) Health() []TaskHealth {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]TaskHealth(nil), a.health...)
+}
@@ -0,0 +1,115 @@
+//go:build unit
+
+package monitor_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/squarefactory/miner-api/monitor"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/stretchr/testify/suite"
+)
+
+const gminerLog = `+----------------------------------------------------------------+
+|                          GMiner v3.44                          |
++----------------------------------------------------------------+
+Connected to kawpow.auto.nicehash.com:443
+GPU0: Share #1 accepted 35 ms
+GPU0: Share #2 accepted 31 ms
+GPU0: Share #3 rejected 40 ms
+GPU0: Share #4 accepted 33 ms
+GPU0: Share #5 accepted 33 ms
+GPU0: Share #6 accepted 33 ms
+GPU0: Share #7 accepted 33 ms
+GPU0: Share #8 accepted 33 ms
+GPU0: Share #9 accepted 33 ms
+GPU0: Share #10 accepted 33 ms
+GPU0: Share #11 accepted 33 ms
+Total Speed: 24.35 MH/s Shares Accepted: 10 Rejected: 1 Stale: 0 Power: 120W 0.20 MH/J
+`
+
+const xmrigLog = `[2023-06-01 10:00:00.000]  net      use pool randomxmonero.auto.nicehash.com:443 TLSv1.3
+[2023-06-01 10:00:10.000]  cpu      accepted (1/0) diff 100001 (45 ms)
+[2023-06-01 10:00:20.000]  net      randomxmonero.auto.nicehash.com:443 read error: "end of file"
+[2023-06-01 10:00:30.000]  cpu      accepted (2/0) diff 100001 (45 ms)
+[2023-06-01 10:01:00.000]  miner    speed 10s/60s/15m 5120.5 5000.1 n/a H/s max 5300.2 H/s
+`
+
+const restartLoopLog = `CUDA error: out of memory
+Script exited with code 1. Retrying...
+CUDA error: out of memory
+Script exited with code 1. Retrying...
+CUDA error: out of memory
+Script exited with code 1. Retrying...
+`
+
+type fakeLogReader map[int]string
+
+func (r fakeLogReader) TailLog(ctx context.Context, req *scheduler.TailLogRequest) (string, error) {
+	return r[req.ID], nil
+}
+
+type LogAnalyzerTestSuite struct {
+	suite.Suite
+	impl *monitor.LogAnalyzer
+}
+
+func (suite *LogAnalyzerTestSuite) BeforeTest(suiteName, testName string) {
+	suite.impl = &monitor.LogAnalyzer{
+		Reader: fakeLogReader{
+			101: gminerLog,
+			102: restartLoopLog,
+			201: xmrigLog,
+		},
+		User: "miner",
+	}
+}
+
+func (suite *LogAnalyzerTestSuite) TestAnalyzeAll() {
+	// Arrange
+	tasks := map[string][]scheduler.Task{
+		monitor.MinerGminer: {
+			{JobID: 100, ID: 101, TaskID: 1, State: scheduler.TaskRunning, Node: "cn1"},
+			{JobID: 100, ID: 102, TaskID: 2, State: scheduler.TaskRunning, Node: "cn2"},
+			{JobID: 100, ID: 100, TaskID: -1, State: "PENDING"},
+		},
+		monitor.MinerXmrig: {
+			{JobID: 200, ID: 201, TaskID: 1, State: scheduler.TaskRunning, Node: "cn1"},
+		},
+	}
+
+	// Act
+	health := suite.impl.AnalyzeAll(context.Background(), tasks)
+
+	// Assert
+	suite.Len(health, 3)
+	byTask := make(map[int]monitor.TaskHealth)
+	for _, h := range health {
+		byTask[h.JobID*10+h.TaskID] = h
+	}
+
+	gminer := byTask[1001]
+	suite.Equal(monitor.HealthHealthy, gminer.Status)
+	suite.Equal(24.35e6, gminer.Hashrate)
+	suite.Equal(10, gminer.AcceptedShares)
+	suite.Equal(1, gminer.RejectedShares)
+
+	loop := byTask[1002]
+	suite.Equal(monitor.HealthFailing, loop.Status)
+	suite.True(loop.RestartLoop)
+	suite.Equal(3, loop.Restarts)
+	suite.Equal(3, loop.Crashes)
+
+	xmrig := byTask[2001]
+	suite.Equal(monitor.HealthDegraded, xmrig.Status)
+	suite.Equal(5120.5, xmrig.Hashrate)
+	suite.Equal(2, xmrig.AcceptedShares)
+	suite.Equal(1, xmrig.ConnectionLost)
+
+	suite.Len(suite.impl.Health(), 3)
+}
+
+func TestLogAnalyzerTestSuite(t *testing.T) {
+	suite.Run(t, &LogAnalyzerTestSuite{})
+}
@@ -0,0 +1,278 @@
+// Package monitor scrapes the statistics of the running miners.
+package monitor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+const (
+	MinerGminer = "gminer"
+	MinerXmrig  = "xmrig"
+)
+
+// MinerStats are the statistics reported by the HTTP API of a miner.
+type MinerStats struct {
+	JobID  int    `json:"jobId"`
+	TaskID int    `json:"taskId"`
+	Node   string `json:"node"`
+	Miner  string `json:"miner"`
+	// Hashrate is in H/s.
+	Hashrate       float64 `json:"hashrate"`
+	AcceptedShares int     `json:"acceptedShares"`
+	RejectedShares int     `json:"rejectedShares"`
+	// Temperature is the maximum temperature of the devices in °C. Zero if unknown.
+	Temperature float64 `json:"temperature"`
+	// Power is the power draw of the devices in W. Zero if unknown.
+	Power     float64   `json:"power"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Collector queries the local HTTP API of each running miner.
+//
+// Each task listens on BasePort + array task ID, so that the tasks sharing a node do not conflict.
+type Collector struct {
+	// GminerBasePort is the base port of the gminer API (--api). gminer has no authentication and listens on every
+	// address of the nodes, as it is scraped from the host of the miner-api: the nodes must firewall these ports.
+	GminerBasePort int
+	// XmrigBasePort is the base port of the xmrig API (--http-port).
+	XmrigBasePort int
+	// XmrigAccessToken authenticates the requests to the xmrig API (--http-access-token), which listens on every
+	// address of the nodes.
+	XmrigAccessToken string
+	// Client is the HTTP client. Defaults to a client with a 5 seconds timeout.
+	Client *http.Client
+	// Address returns the host:port of a miner API. Defaults to node:port.
+	Address func(node string, port int) string
+
+	mu    sync.Mutex
+	stats []MinerStats
+}
+
+// Port returns the port of the API of the miner running the array task.
+func (c *Collector) Port(miner string, taskID int) int {
+	if miner == MinerXmrig {
+		return c.XmrigBasePort + taskID
+	}
+	return c.GminerBasePort + taskID
+}
+
+// CheckPorts returns an error if the API ports of gpuTasks gminer tasks and cpuTasks xmrig tasks overlap, as the
+// tasks of both jobs may share a node, or if they are out of the port range.
+func (c *Collector) CheckPorts(gpuTasks int, cpuTasks int) error {
+	gminerLast := c.GminerBasePort + gpuTasks
+	xmrigLast := c.XmrigBasePort + cpuTasks
+	if gpuTasks > 0 && cpuTasks > 0 && c.GminerBasePort < xmrigLast && c.XmrigBasePort < gminerLast {
+		return fmt.Errorf("the gminer API ports %d-%d overlap the xmrig API ports %d-%d",
+			c.GminerBasePort+1, gminerLast, c.XmrigBasePort+1, xmrigLast)
+	}
+	if gminerLast > maxPort || xmrigLast > maxPort {
+		return fmt.Errorf("the API ports of the miners exceed %d", maxPort)
+	}
+	return nil
+}
+
+// maxPort is the highest TCP port.
+const maxPort = 65535
+
+// tokenPattern matches the access tokens which are safe in the job scripts.
+var tokenPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/=-]+$`)
+
+// ValidateAccessToken returns an error if the token of the xmrig API cannot be given to xmrig in a job script.
+func ValidateAccessToken(token string) error {
+	if !tokenPattern.MatchString(token) {
+		return errors.New("invalid xmrig access token: only letters, digits and ._~+/=- are allowed")
+	}
+	return nil
+}
+
+// NewAccessToken returns a random token for the xmrig API.
+func NewAccessToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (c *Collector) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return &http.Client{Timeout: 5 * time.Second}
+}
+
+func (c *Collector) address(node string, port int) string {
+	if c.Address != nil {
+		return c.Address(node, port)
+	}
+	return net.JoinHostPort(node, strconv.Itoa(port))
+}
+
+// Collect scrapes the running tasks of each miner and stores the results.
+func (c *Collector) Collect(ctx context.Context, tasks map[string][]scheduler.Task) []MinerStats {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		stats []MinerStats
+	)
+	for miner, minerTasks := range tasks {
+		for _, task := range minerTasks {
+			if task.State != scheduler.TaskRunning || task.Node == "" {
+				continue
+			}
+			wg.Add(1)
+			go func(miner string, task scheduler.Task) {
+				defer wg.Done()
+				s := c.scrape(ctx, miner, task)
+				mu.Lock()
+				stats = append(stats, s)
+				mu.Unlock()
+			}(miner, task)
+		}
+	}
+	wg.Wait()
+
+	metrics.MinerHashrate.Reset()
+	metrics.MinerShares.Reset()
+	metrics.MinerTemperature.Reset()
+	metrics.MinerPower.Reset()
+	for _, s := range stats {
+		if s.Error != "" {
+			continue
+		}
+		labels := []string{s.Miner, strconv.Itoa(s.JobID), strconv.Itoa(s.TaskID), s.Node}
+		metrics.MinerHashrate.WithLabelValues(labels...).Set(s.Hashrate)
+		metrics.MinerShares.WithLabelValues(append(labels, "accepted")...).Set(float64(s.AcceptedShares))
+		metrics.MinerShares.WithLabelValues(append(labels, "rejected")...).Set(float64(s.RejectedShares))
+		metrics.MinerTemperature.WithLabelValues(labels...).Set(s.Temperature)
+		metrics.MinerPower.WithLabelValues(labels...).Set(s.Power)
+	}
+
+	c.mu.Lock()
+	c.stats = stats
+	c.mu.Unlock()
+	return stats
+}
+
+// Stats returns the results of the last collection.
+func (c *Collector) Stats() []MinerStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]MinerStats(nil), c.stats...)
+}
+
+func (c *Collector) scrape(ctx context.Context, miner string, task scheduler.Task) MinerStats {
+	stats := MinerStats{
+		JobID:     task.JobID,
+		TaskID:    task.TaskID,
+		Node:      task.Node,
+		Miner:     miner,
+		UpdatedAt: time.Now(),
+	}
+	addr := c.address(task.Node, c.Port(miner, task.TaskID))
+
+	var err error
+	switch miner {
+	case MinerGminer:
+		err = c.scrapeGminer(ctx, addr, &stats)
+	case MinerXmrig:
+		err = c.scrapeXmrig(ctx, addr, &stats)
+	default:
+		err = fmt.Errorf("unknown miner %s", miner)
+	}
+	if err != nil {
+		stats.Error = err.Error()
+	}
+	return stats
+}
+
+// get decodes the JSON response of url, authenticated with token if not empty.
+func (c *Collector) get(ctx context.Context, url string, token string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// gminerStat is the response of the gminer /stat endpoint.
+type gminerStat struct {
+	TotalAcceptedShares int `json:"total_accepted_shares"`
+	TotalRejectedShares int `json:"total_rejected_shares"`
+	Devices             []struct {
+		Speed       float64 `json:"speed"`
+		Temperature float64 `json:"temperature"`
+		PowerUsage  float64 `json:"power_usage"`
+	} `json:"devices"`
+}
+
+func (c *Collector) scrapeGminer(ctx context.Context, addr string, stats *MinerStats) error {
+	var stat gminerStat
+	if err := c.get(ctx, "http://"+addr+"/stat", "", &stat); err != nil {
+		return err
+	}
+	stats.AcceptedShares = stat.TotalAcceptedShares
+	stats.RejectedShares = stat.TotalRejectedShares
+	for _, d := range stat.Devices {
+		stats.Hashrate += d.Speed
+		stats.Power += d.PowerUsage
+		if d.Temperature > stats.Temperature {
+			stats.Temperature = d.Temperature
+		}
+	}
+	return nil
+}
+
+// xmrigSummary is the response of the xmrig /2/summary endpoint.
+type xmrigSummary struct {
+	Hashrate struct {
+		// Total is the hashrate over 10s, 60s and 15m. Values may be null while warming up.
+		Total []*float64 `json:"total"`
+	} `json:"hashrate"`
+	Connection struct {
+		Accepted int `json:"accepted"`
+		Rejected int `json:"rejected"`
+	} `json:"connection"`
+}
+
+func (c *Collector) scrapeXmrig(ctx context.Context, addr string, stats *MinerStats) error {
+	var summary xmrigSummary
+	if err := c.get(ctx, "http://"+addr+"/2/summary", c.XmrigAccessToken, &summary); err != nil {
+		return err
+	}
+	for _, h := range summary.Hashrate.Total {
+		if h != nil {
+			stats.Hashrate = *h
+			break
+		}
+	}
+	stats.AcceptedShares = summary.Connection.Accepted
+	stats.RejectedShares = summary.Connection.Rejected
+	return nil
+}
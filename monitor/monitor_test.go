@@ -0,0 +1,143 @@
+//go:build unit
+
+package monitor_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/squarefactory/miner-api/monitor"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/stretchr/testify/suite"
+)
+
+// xmrigToken is the access token of the xmrig API of the fake miner.
+const xmrigToken = "0123456789abcdef"
+
+// fakeMiner serves the HTTP APIs of gminer and xmrig.
+func fakeMiner() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stat", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{
+  "miner": "GMiner 3.44",
+  "algorithm": "KawPow",
+  "total_accepted_shares": 42,
+  "total_rejected_shares": 1,
+  "devices": [
+    {"gpu_id": 0, "name": "NVIDIA GeForce RTX 3070", "speed": 25000000, "temperature": 61, "power_usage": 120},
+    {"gpu_id": 1, "name": "NVIDIA GeForce RTX 3070", "speed": 24000000, "temperature": 65, "power_usage": 118}
+  ]
+}`))
+	})
+	mux.HandleFunc("/2/summary", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+xmrigToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+  "hashrate": {"total": [null, 5120.5, 5000.1], "highest": 5300.2},
+  "connection": {"pool": "randomxmonero.auto.nicehash.com:443", "accepted": 17, "rejected": 2}
+}`))
+	})
+	return mux
+}
+
+type MonitorTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	impl   *monitor.Collector
+}
+
+func (suite *MonitorTestSuite) BeforeTest(suiteName, testName string) {
+	suite.server = httptest.NewServer(fakeMiner())
+	suite.T().Cleanup(suite.server.Close)
+	host, _, err := net.SplitHostPort(suite.server.Listener.Addr().String())
+	suite.Require().NoError(err)
+
+	suite.impl = &monitor.Collector{
+		GminerBasePort:   10050,
+		XmrigBasePort:    10450,
+		XmrigAccessToken: xmrigToken,
+		// Every node and port is served by the fake miner, except the ones on "down".
+		Address: func(node string, port int) string {
+			if node == "down" {
+				return net.JoinHostPort(host, "1")
+			}
+			suite.Contains([]int{10051, 10052, 10451}, port)
+			return suite.server.Listener.Addr().String()
+		},
+	}
+}
+
+func (suite *MonitorTestSuite) TestCollect() {
+	// Arrange
+	tasks := map[string][]scheduler.Task{
+		monitor.MinerGminer: {
+			{JobID: 10, TaskID: 1, State: scheduler.TaskRunning, Node: "cn1"},
+			{JobID: 10, TaskID: 2, State: scheduler.TaskRunning, Node: "down"},
+			{JobID: 10, TaskID: -1, State: "PENDING"},
+		},
+		monitor.MinerXmrig: {
+			{JobID: 11, TaskID: 1, State: scheduler.TaskRunning, Node: "cn1"},
+		},
+	}
+
+	// Act
+	stats := suite.impl.Collect(context.Background(), tasks)
+
+	// Assert
+	suite.Len(stats, 3)
+	stats = sortedStats(stats)
+	suite.Equal(monitor.MinerGminer, stats[0].Miner)
+	suite.Equal(49000000.0, stats[0].Hashrate)
+	suite.Equal(42, stats[0].AcceptedShares)
+	suite.Equal(1, stats[0].RejectedShares)
+	suite.Equal(65.0, stats[0].Temperature)
+	suite.Equal(238.0, stats[0].Power)
+	suite.Empty(stats[0].Error)
+
+	suite.Equal("down", stats[1].Node)
+	suite.NotEmpty(stats[1].Error)
+
+	suite.Equal(monitor.MinerXmrig, stats[2].Miner)
+	suite.Equal(5120.5, stats[2].Hashrate)
+	suite.Equal(17, stats[2].AcceptedShares)
+	suite.Equal(2, stats[2].RejectedShares)
+
+	suite.Equal(stats, sortedStats(suite.impl.Stats()))
+}
+
+func (suite *MonitorTestSuite) TestCheckPorts() {
+	suite.NoError(suite.impl.CheckPorts(400, 20))
+	suite.ErrorContains(suite.impl.CheckPorts(401, 20), "10051-10451 overlap the xmrig API ports 10451-10470")
+	suite.NoError(suite.impl.CheckPorts(401, 0))
+	suite.impl.XmrigBasePort = 65530
+	suite.ErrorContains(suite.impl.CheckPorts(4, 6), "exceed")
+}
+
+func (suite *MonitorTestSuite) TestAccessToken() {
+	// Act
+	token, err := monitor.NewAccessToken()
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(token, 32)
+	suite.NoError(monitor.ValidateAccessToken(token))
+	suite.Error(monitor.ValidateAccessToken(""))
+	suite.Error(monitor.ValidateAccessToken("x' --http-no-restricted '"))
+}
+
+func sortedStats(stats []monitor.MinerStats) []monitor.MinerStats {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].JobID*100+stats[i].TaskID < stats[j].JobID*100+stats[j].TaskID
+	})
+	return stats
+}
+
+func TestMonitorTestSuite(t *testing.T) {
+	suite.Run(t, &MonitorTestSuite{})
+}
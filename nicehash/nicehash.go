@@ -0,0 +1,237 @@
+// Package nicehash is a client of the NiceHash mining and accounting APIs.
+package nicehash
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// DefaultURL is the production NiceHash API.
+const DefaultURL = "https://api2.nicehash.com"
+
+// Client authenticates to the NiceHash API with an API key and secret.
+type Client struct {
+	// URL of the API. Defaults to DefaultURL.
+	URL            string
+	APIKey         string
+	APISecret      string
+	OrganizationID string
+	// HTTPClient defaults to a client with a 30 seconds timeout.
+	HTTPClient *http.Client
+}
+
+// Amount is a BTC amount, encoded by NiceHash as a string.
+type Amount float64
+
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	str := strings.Trim(string(b), `"`)
+	if str == "" || str == "null" {
+		*a = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return err
+	}
+	*a = Amount(f)
+	return nil
+}
+
+// Rigs is the response of /main/api/v2/mining/rigs2.
+type Rigs struct {
+	UnpaidAmount       Amount `json:"unpaidAmount"`
+	TotalProfitability Amount `json:"totalProfitability"`
+	MiningRigs         []Rig  `json:"miningRigs"`
+}
+
+type Rig struct {
+	RigID         string `json:"rigId"`
+	Name          string `json:"name"`
+	UnpaidAmount  Amount `json:"unpaidAmount"`
+	Profitability Amount `json:"profitability"`
+}
+
+// AlgoStats is the response of /main/api/v2/mining/algo/stats.
+type AlgoStats struct {
+	Algorithms map[string]struct {
+		Unpaid        Amount `json:"unpaid"`
+		Profitability Amount `json:"profitability"`
+	} `json:"algorithms"`
+}
+
+// Account is the response of /main/api/v2/accounting/account2/{currency}.
+type Account struct {
+	Currency     string `json:"currency"`
+	TotalBalance Amount `json:"totalBalance"`
+	Available    Amount `json:"available"`
+	Pending      Amount `json:"pending"`
+}
+
+// Payouts is the response of /main/api/v2/mining/rigs/payouts.
+type Payouts struct {
+	List []Payout `json:"list"`
+}
+
+type Payout struct {
+	ID string `json:"id"`
+	// Created is a timestamp in milliseconds.
+	Created   int64  `json:"created"`
+	Amount    Amount `json:"amount"`
+	FeeAmount Amount `json:"feeAmount"`
+}
+
+// ExchangeRates is the response of /main/api/v2/exchangeRate/list.
+type ExchangeRates struct {
+	List []struct {
+		FromCurrency string `json:"fromCurrency"`
+		ToCurrency   string `json:"toCurrency"`
+		ExchangeRate Amount `json:"exchangeRate"`
+	} `json:"list"`
+}
+
+// ExchangeRate returns the exchange rate from a currency to another, like BTC to USD.
+func (c *Client) ExchangeRate(ctx context.Context, from string, to string) (float64, error) {
+	var rates ExchangeRates
+	if err := c.get(ctx, "/main/api/v2/exchangeRate/list", nil, &rates); err != nil {
+		return 0, err
+	}
+	for _, r := range rates.List {
+		if strings.EqualFold(r.FromCurrency, from) && strings.EqualFold(r.ToCurrency, to) {
+			return float64(r.ExchangeRate), nil
+		}
+	}
+	return 0, fmt.Errorf("no exchange rate from %s to %s", from, to)
+}
+
+// Rigs returns the unpaid amount and the profitability of the rigs.
+func (c *Client) Rigs(ctx context.Context) (*Rigs, error) {
+	var rigs Rigs
+	return &rigs, c.get(ctx, "/main/api/v2/mining/rigs2", nil, &rigs)
+}
+
+// AlgoStats returns the unpaid amount and the profitability of each algorithm.
+func (c *Client) AlgoStats(ctx context.Context) (*AlgoStats, error) {
+	var stats AlgoStats
+	return &stats, c.get(ctx, "/main/api/v2/mining/algo/stats", nil, &stats)
+}
+
+// Account returns the balance of the account in the given currency.
+func (c *Client) Account(ctx context.Context, currency string) (*Account, error) {
+	var account Account
+	return &account, c.get(ctx, "/main/api/v2/accounting/account2/"+url.PathEscape(currency), nil, &account)
+}
+
+// Payouts returns the last mining payouts.
+func (c *Client) Payouts(ctx context.Context, size int) (*Payouts, error) {
+	var payouts Payouts
+	query := url.Values{
+		"page": {"0"},
+		"size": {strconv.Itoa(size)},
+	}
+	return &payouts, c.get(ctx, "/main/api/v2/mining/rigs/payouts", query, &payouts)
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveUpstream("nicehash", start, err) }()
+
+	base := c.URL
+	if base == "" {
+		base = DefaultURL
+	}
+	u := base + path
+	rawQuery := query.Encode()
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if err := c.sign(req, path, rawQuery, time.Now()); err != nil {
+		return err
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("nicehash %s returned %s: %s", path, resp.Status, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// sign sets the HMAC authentication headers of a request without body.
+func (c *Client) sign(req *http.Request, path string, rawQuery string, now time.Time) error {
+	nonce, err := randomID()
+	if err != nil {
+		return err
+	}
+	requestID, err := randomID()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.UnixMilli(), 10)
+
+	req.Header.Set("X-Time", timestamp)
+	req.Header.Set("X-Nonce", nonce)
+	req.Header.Set("X-Organization-Id", c.OrganizationID)
+	req.Header.Set("X-Request-Id", requestID)
+	req.Header.Set("X-Auth", c.APIKey+":"+Signature(
+		c.APISecret,
+		c.APIKey,
+		timestamp,
+		nonce,
+		c.OrganizationID,
+		req.Method,
+		path,
+		rawQuery,
+	))
+	return nil
+}
+
+// Signature computes the HMAC-SHA256 of a request, as expected in the X-Auth header.
+func Signature(secret, key, timestamp, nonce, organizationID, method, path, rawQuery string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{
+		key,
+		timestamp,
+		nonce,
+		"",
+		organizationID,
+		"",
+		method,
+		path,
+		rawQuery,
+	}, "\x00")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
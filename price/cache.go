@@ -0,0 +1,136 @@
+package price
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is the duration for which the cached prices are used before fetching them again.
+const DefaultTTL = time.Hour
+
+// DefaultHistory is the duration for which the past prices are kept, to price the reports of the past month.
+const DefaultHistory = 31 * 24 * time.Hour
+
+// retryInterval bounds the rate of the fetches while the source is failing.
+const retryInterval = time.Minute
+
+// Cache caches the prices of a Source.
+//
+// The fetched prices are merged into the prices fetched before, so that the past prices which are no longer in the
+// feed are kept for History. If the source fails, the stale prices are used until they no longer cover the
+// requested time.
+type Cache struct {
+	Source Source
+	// TTL defaults to DefaultTTL.
+	TTL time.Duration
+	// History defaults to DefaultHistory.
+	History time.Duration
+
+	mu        sync.Mutex
+	prices    []Price
+	fetchedAt time.Time
+	triedAt   time.Time
+	fetching  bool // Indicates if a fetch is in progress, while the others use the cached prices.
+}
+
+// PriceAt returns the electricity price per kWh at t, and false if the feed does not cover t.
+func (c *Cache) PriceAt(ctx context.Context, t time.Time) (float64, bool, error) {
+	p, _, ok, err := c.PriceSpan(ctx, t)
+	return p, ok, err
+}
+
+// PriceSpan is PriceAt also returning the end of the price covering t.
+func (c *Cache) PriceSpan(ctx context.Context, t time.Time) (float64, time.Time, bool, error) {
+	err := c.refresh(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, end, ok := c.lookup(t); ok {
+		if err != nil {
+			log.Printf("using cached electricity prices: %s", err)
+		}
+		return p, end, true, nil
+	}
+	return 0, time.Time{}, false, err
+}
+
+// refresh fetches the prices if they expired, without holding the lock during the fetch.
+func (c *Cache) refresh(ctx context.Context) error {
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	c.mu.Lock()
+	failed := c.triedAt.After(c.fetchedAt)
+	if c.fetching || time.Since(c.fetchedAt) <= ttl || failed && time.Since(c.triedAt) <= retryInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	c.fetching = true
+	c.triedAt = time.Now()
+	c.mu.Unlock()
+
+	prices, err := c.Source.Prices(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetching = false
+	if err != nil {
+		return err
+	}
+	c.merge(prices)
+	c.fetchedAt = time.Now()
+	return nil
+}
+
+// merge adds the fetched prices to the cached ones, replacing the prices with the same start,
+// and forgets the prices starting more than History before the last one.
+func (c *Cache) merge(fetched []Price) {
+	history := c.History
+	if history == 0 {
+		history = DefaultHistory
+	}
+
+	byStart := make(map[int64]Price, len(c.prices)+len(fetched))
+	for _, list := range [][]Price{c.prices, fetched} {
+		for _, p := range list {
+			byStart[p.Start.UnixNano()] = p
+		}
+	}
+	prices := make([]Price, 0, len(byStart))
+	for _, p := range byStart {
+		prices = append(prices, p)
+	}
+	sortPrices(prices)
+	if len(prices) > 0 {
+		oldest := prices[len(prices)-1].Start.Add(-history)
+		i := sort.Search(len(prices), func(i int) bool {
+			return !prices[i].Start.Before(oldest)
+		})
+		prices = prices[i:]
+	}
+	c.prices = prices
+}
+
+// lookup finds the price covering t: the last price starting before t, valid for Interval or until the next one.
+// A gap in the feed is not covered.
+func (c *Cache) lookup(t time.Time) (float64, time.Time, bool) {
+	for i := len(c.prices) - 1; i >= 0; i-- {
+		p := c.prices[i]
+		if p.Start.After(t) {
+			continue
+		}
+		end := p.Start.Add(Interval)
+		if i+1 < len(c.prices) && c.prices[i+1].Start.Before(end) {
+			end = c.prices[i+1].Start
+		}
+		if t.Before(end) {
+			return p.PricePerKwh, end, true
+		}
+		return 0, time.Time{}, false
+	}
+	return 0, time.Time{}, false
+}
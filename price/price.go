@@ -0,0 +1,230 @@
+// Package price fetches the hourly electricity prices of the day-ahead market.
+package price
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// Price is the electricity price from Start until the next price, or for an hour.
+type Price struct {
+	Start time.Time `json:"start"`
+	// PricePerKwh is converted from the unit of the feed.
+	PricePerKwh float64 `json:"pricePerKwh"`
+}
+
+// Interval is the duration covered by each price of the feed, unless the next price starts earlier.
+const Interval = time.Hour
+
+// Source lists the electricity prices, in any order.
+type Source interface {
+	Prices(ctx context.Context) ([]Price, error)
+}
+
+// Formats of the price feeds.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Format describes the price feeds.
+//
+// A JSON feed is an array of objects, or an object holding that array in ListField.
+// A CSV feed has a header naming its columns.
+// Times are RFC3339, "2006-01-02 15:04" in the local time zone, or UNIX seconds.
+type Format struct {
+	// Type is either FormatJSON or FormatCSV.
+	Type string
+	// ListField is the field of the JSON object holding the prices. The feed is an array if empty.
+	ListField string
+	// TimeField is the field or column of the start time. Defaults to "start".
+	TimeField string
+	// PriceField is the field or column of the price. Defaults to "price".
+	PriceField string
+	// Divisor converts the prices of the feed to prices per kWh, like 1000 for prices per MWh. Defaults to 1.
+	Divisor float64
+}
+
+// HTTP fetches the prices from an HTTP endpoint.
+type HTTP struct {
+	URL    string
+	Format Format
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Prices implements Source.
+func (h *HTTP) Prices(ctx context.Context) (prices []Price, err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveUpstream("prices", start, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("price feed returned %s", resp.Status)
+	}
+	return h.Format.Parse(resp.Body)
+}
+
+// File reads the prices from a local file, for instance exported daily by a cron job.
+type File struct {
+	Path   string
+	Format Format
+}
+
+// Prices implements Source.
+func (f *File) Prices(ctx context.Context) ([]Price, error) {
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	return f.Format.Parse(bytes.NewReader(b))
+}
+
+// Parse parses a price feed.
+func (f Format) Parse(r io.Reader) ([]Price, error) {
+	timeField, priceField := f.TimeField, f.PriceField
+	if timeField == "" {
+		timeField = "start"
+	}
+	if priceField == "" {
+		priceField = "price"
+	}
+	divisor := f.Divisor
+	if divisor == 0 {
+		divisor = 1
+	}
+
+	var rows []map[string]string
+	var err error
+	switch f.Type {
+	case "", FormatJSON:
+		rows, err = readJSON(r, f.ListField)
+	case FormatCSV:
+		rows, err = readCSV(r)
+	default:
+		return nil, fmt.Errorf("unknown price feed format %q", f.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make([]Price, 0, len(rows))
+	for i, row := range rows {
+		start, err := parseTime(row[timeField])
+		if err != nil {
+			return nil, fmt.Errorf("price %d: invalid %s: %w", i, timeField, err)
+		}
+		p, err := strconv.ParseFloat(row[priceField], 64)
+		if err != nil {
+			return nil, fmt.Errorf("price %d: invalid %s: %w", i, priceField, err)
+		}
+		prices = append(prices, Price{Start: start, PricePerKwh: p / divisor})
+	}
+	return prices, nil
+}
+
+func readJSON(r io.Reader, listField string) ([]map[string]string, error) {
+	var raw []map[string]json.RawMessage
+	if listField == "" {
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, err
+		}
+	} else {
+		var obj map[string]json.RawMessage
+		if err := json.NewDecoder(r).Decode(&obj); err != nil {
+			return nil, err
+		}
+		list, ok := obj[listField]
+		if !ok {
+			return nil, fmt.Errorf("no %q field in the price feed", listField)
+		}
+		if err := json.Unmarshal(list, &raw); err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]map[string]string, 0, len(raw))
+	for _, item := range raw {
+		row := make(map[string]string, len(item))
+		for k, v := range item {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				// Numbers are kept as is.
+				s = string(v)
+			}
+			row[k] = s
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readCSV(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("empty price feed")
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(record) {
+				row[strings.TrimSpace(name)] = strings.TrimSpace(record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", v, time.Local); err == nil {
+		return t, nil
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unsupported time %q", v)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// sortPrices sorts the prices by start time.
+func sortPrices(prices []Price) {
+	sort.Slice(prices, func(i, j int) bool {
+		return prices[i].Start.Before(prices[j].Start)
+	})
+}
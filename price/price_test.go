@@ -0,0 +1,224 @@
+//go:build unit
+
+package price_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/price"
+	"github.com/stretchr/testify/suite"
+)
+
+type PriceTestSuite struct {
+	suite.Suite
+}
+
+var hour = time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+func (suite *PriceTestSuite) TestHTTPJSON() {
+	// Arrange
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"prices":[
+  {"start":"2023-05-01T10:00:00Z","price":95.5},
+  {"start":1682938800,"price":"420"}
+]}`))
+	}))
+	defer server.Close()
+	source := &price.HTTP{
+		URL: server.URL,
+		Format: price.Format{
+			Type:      price.FormatJSON,
+			ListField: "prices",
+			Divisor:   1000,
+		},
+	}
+
+	// Act
+	prices, err := source.Prices(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(prices, 2)
+	suite.True(hour.Equal(prices[0].Start))
+	suite.InDelta(0.0955, prices[0].PricePerKwh, 1e-9)
+	suite.True(hour.Add(time.Hour).Equal(prices[1].Start))
+	suite.InDelta(0.42, prices[1].PricePerKwh, 1e-9)
+}
+
+func (suite *PriceTestSuite) TestParseCSV() {
+	// Arrange
+	format := price.Format{
+		Type:       price.FormatCSV,
+		TimeField:  "datetime",
+		PriceField: "eur_per_kwh",
+	}
+
+	// Act
+	prices, err := format.Parse(strings.NewReader(`datetime, eur_per_kwh
+2023-05-01T10:00:00Z, 0.12
+2023-05-01T11:00:00Z, 0.31
+`))
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]price.Price{
+		{Start: hour, PricePerKwh: 0.12},
+		{Start: hour.Add(time.Hour), PricePerKwh: 0.31},
+	}, prices)
+}
+
+type fakeSource struct {
+	prices []price.Price
+	err    error
+	calls  int
+}
+
+func (f *fakeSource) Prices(ctx context.Context) ([]price.Price, error) {
+	f.calls++
+	return f.prices, f.err
+}
+
+func (suite *PriceTestSuite) TestCache() {
+	// Arrange
+	source := &fakeSource{prices: []price.Price{
+		{Start: hour.Add(time.Hour), PricePerKwh: 0.31},
+		{Start: hour, PricePerKwh: 0.12},
+	}}
+	cache := &price.Cache{Source: source, TTL: time.Hour}
+	ctx := context.Background()
+
+	// Act
+	first, ok1, err1 := cache.PriceAt(ctx, hour.Add(30*time.Minute))
+	second, ok2, err2 := cache.PriceAt(ctx, hour.Add(90*time.Minute))
+	_, ok3, err3 := cache.PriceAt(ctx, hour.Add(3*time.Hour))
+	_, end, _, _ := cache.PriceSpan(ctx, hour.Add(30*time.Minute))
+	_, lastEnd, _, _ := cache.PriceSpan(ctx, hour.Add(90*time.Minute))
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.NoError(err3)
+	suite.True(ok1)
+	suite.True(ok2)
+	suite.False(ok3)
+	suite.Equal(0.12, first)
+	suite.Equal(0.31, second)
+	suite.Equal(hour.Add(time.Hour), end)
+	suite.Equal(hour.Add(2*time.Hour), lastEnd)
+	suite.Equal(1, source.calls)
+}
+
+func (suite *PriceTestSuite) TestCacheGap() {
+	// Arrange
+	source := &fakeSource{prices: []price.Price{
+		{Start: hour, PricePerKwh: 0.12},
+		{Start: hour.Add(3 * time.Hour), PricePerKwh: 0.31},
+	}}
+	cache := &price.Cache{Source: source}
+	ctx := context.Background()
+
+	// Act
+	_, end, ok1, err1 := cache.PriceSpan(ctx, hour.Add(30*time.Minute))
+	_, ok2, err2 := cache.PriceAt(ctx, hour.Add(90*time.Minute))
+
+	// Assert
+	suite.NoError(err1)
+	suite.NoError(err2)
+	suite.True(ok1)
+	suite.Equal(hour.Add(time.Hour), end)
+	suite.False(ok2)
+}
+
+func (suite *PriceTestSuite) TestCacheError() {
+	// Arrange
+	source := &fakeSource{err: errors.New("unavailable")}
+	cache := &price.Cache{Source: source}
+
+	// Act
+	_, ok, err := cache.PriceAt(context.Background(), hour)
+
+	// Assert
+	suite.False(ok)
+	suite.Error(err)
+}
+
+func (suite *PriceTestSuite) TestCacheHistory() {
+	// Arrange
+	source := &fakeSource{prices: []price.Price{
+		{Start: hour, PricePerKwh: 0.12},
+		{Start: hour.Add(time.Hour), PricePerKwh: 0.31},
+	}}
+	cache := &price.Cache{Source: source, TTL: time.Nanosecond, History: 48 * time.Hour}
+	ctx := context.Background()
+	_, _, _ = cache.PriceAt(ctx, hour)
+
+	// Act
+	source.prices = []price.Price{
+		{Start: hour.Add(time.Hour), PricePerKwh: 0.28},
+		{Start: hour.Add(2 * time.Hour), PricePerKwh: 0.2},
+	}
+	past, ok1, _ := cache.PriceAt(ctx, hour)
+	corrected, ok2, _ := cache.PriceAt(ctx, hour.Add(time.Hour))
+	source.prices = []price.Price{
+		{Start: hour.Add(72 * time.Hour), PricePerKwh: 0.1},
+	}
+	_, ok3, _ := cache.PriceAt(ctx, hour)
+
+	// Assert
+	suite.True(ok1)
+	suite.Equal(0.12, past)
+	suite.True(ok2)
+	suite.Equal(0.28, corrected)
+	suite.False(ok3)
+	suite.Equal(4, source.calls)
+}
+
+type blockingSource struct {
+	prices  []price.Price
+	fetched chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSource) Prices(ctx context.Context) ([]price.Price, error) {
+	if b.fetched != nil {
+		b.fetched <- struct{}{}
+		<-b.release
+	}
+	return b.prices, nil
+}
+
+func (suite *PriceTestSuite) TestCacheFetchWithoutLock() {
+	// Arrange
+	source := &blockingSource{prices: []price.Price{{Start: hour, PricePerKwh: 0.12}}}
+	cache := &price.Cache{Source: source, TTL: time.Nanosecond}
+	ctx := context.Background()
+	_, _, _ = cache.PriceAt(ctx, hour)
+	source.fetched = make(chan struct{})
+	source.release = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _, _ = cache.PriceAt(ctx, hour)
+	}()
+	<-source.fetched
+
+	// Act
+	p, ok, err := cache.PriceAt(ctx, hour)
+	close(source.release)
+	<-done
+
+	// Assert
+	suite.NoError(err)
+	suite.True(ok)
+	suite.Equal(0.12, p)
+}
+
+func TestPriceTestSuite(t *testing.T) {
+	suite.Run(t, new(PriceTestSuite))
+}
@@ -0,0 +1,305 @@
+// Package report computes the net profit of mining: the revenue minus the electricity cost.
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/squarefactory/miner-api/ledger"
+	"github.com/squarefactory/miner-api/scheduler"
+)
+
+// joulesPerKWh converts the energy recorded by Slurm to kWh.
+const joulesPerKWh = 3.6e6
+
+// EnergySource lists the energy consumed by the mining jobs.
+type EnergySource interface {
+	FindJobsEnergy(ctx context.Context, req *scheduler.FindJobsEnergyRequest) ([]scheduler.JobEnergy, error)
+}
+
+// RateSource converts the revenue to the currency of the electricity cost.
+type RateSource interface {
+	ExchangeRate(ctx context.Context, from string, to string) (float64, error)
+}
+
+// Generator generates the net profit reports.
+type Generator struct {
+	Energy EnergySource
+	// Ledger provides the revenue. The revenue is zero if nil.
+	Ledger *ledger.Store
+	// Rates converts the revenue from BTC. The revenue in Currency is zero if nil.
+	Rates RateSource
+	// User owning the mining jobs.
+	User string
+	// JobNames are the names of the mining jobs.
+	JobNames []string
+	// PriceSpan returns the electricity price in Currency per kWh at t, and until when it applies, at most until limit.
+	PriceSpan func(t, limit time.Time) (float64, time.Time)
+	Currency  string
+}
+
+// JobCost is the energy consumed by a job during the report and its cost.
+type JobCost struct {
+	scheduler.JobEnergy
+	Algo       string  `json:"algo"`
+	EnergyKWh  float64 `json:"energyKWh"`
+	EnergyCost float64 `json:"energyCost"`
+	// Coin mined on a coin pool, and the coins estimated to be mined by the job during the report.
+	Coin    string  `json:"coin,omitempty"`
+	Rewards float64 `json:"rewards,omitempty"`
+	// RevenueBTC is the value of the rewards at the exchange rate of the coin when the job was submitted.
+	RevenueBTC float64 `json:"revenueBTC,omitempty"`
+}
+
+// AlgoCost sums the energy consumed by the jobs of an algorithm.
+type AlgoCost struct {
+	Algo       string  `json:"algo"`
+	Jobs       int     `json:"jobs"`
+	EnergyKWh  float64 `json:"energyKWh"`
+	EnergyCost float64 `json:"energyCost"`
+}
+
+// DayProfit is the net profit of a UTC day.
+//
+// Slurm only records the total energy of a job, so the energy and the revenue of a job are split over its days
+// in proportion to its runtime during each day.
+type DayProfit struct {
+	Date       string  `json:"date"`
+	RevenueBTC float64 `json:"revenueBTC"`
+	Revenue    float64 `json:"revenue"`
+	EnergyKWh  float64 `json:"energyKWh"`
+	EnergyCost float64 `json:"energyCost"`
+	NetProfit  float64 `json:"netProfit"`
+}
+
+// Report is the net profit over [Since, Until). Costs and revenues are in Currency.
+//
+// The revenue is the one earned on NiceHash, recorded by the ledger, plus the estimated revenue of the jobs
+// mining on coin pools.
+type Report struct {
+	Since        time.Time `json:"since"`
+	Until        time.Time `json:"until"`
+	Currency     string    `json:"currency"`
+	ExchangeRate float64   `json:"exchangeRate"`
+	RevenueBTC   float64   `json:"revenueBTC"`
+	// CoinRevenueBTC is the part of RevenueBTC estimated for the coin pools.
+	CoinRevenueBTC float64     `json:"coinRevenueBTC"`
+	Revenue        float64     `json:"revenue"`
+	EnergyKWh      float64     `json:"energyKWh"`
+	EnergyCost     float64     `json:"energyCost"`
+	NetProfit      float64     `json:"netProfit"`
+	Days           []DayProfit `json:"days"`
+	Algorithms     []AlgoCost  `json:"algorithms"`
+	Jobs           []JobCost   `json:"jobs"`
+}
+
+// Generate computes the report over [since, until).
+func (g *Generator) Generate(ctx context.Context, since, until time.Time) (*Report, error) {
+	jobs, err := g.Energy.FindJobsEnergy(ctx, &scheduler.FindJobsEnergyRequest{
+		User:  g.User,
+		Names: g.JobNames,
+		Since: since,
+		Until: until,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Since:    since,
+		Until:    until,
+		Currency: g.Currency,
+	}
+	days := make(map[string]*DayProfit)
+	day := func(t time.Time) *DayProfit {
+		date := t.UTC().Format("2006-01-02")
+		d, ok := days[date]
+		if !ok {
+			d = &DayProfit{Date: date}
+			days[date] = d
+		}
+		return d
+	}
+
+	algos := make(map[string]*AlgoCost)
+	for _, job := range jobs {
+		tags := scheduler.ParseComment(job.Comment)
+		cost := JobCost{
+			JobEnergy: job,
+			Algo:      tags["algo"],
+			Coin:      tags["coin"],
+		}
+		for _, p := range periods(job, since, until) {
+			kwh := job.Energy / joulesPerKWh * p.share
+			energyCost := kwh * g.averagePrice(p.start, p.end)
+			cost.EnergyKWh += kwh
+			cost.EnergyCost += energyCost
+
+			d := day(p.start)
+			d.EnergyKWh += kwh
+			d.EnergyCost += energyCost
+			if cost.Coin != "" {
+				days := p.end.Sub(p.start).Hours() / 24
+				revenue := parseFloat(tags["revenue"]) * days
+				cost.Rewards += parseFloat(tags["rewards"]) * days
+				cost.RevenueBTC += revenue
+				d.RevenueBTC += revenue
+			}
+		}
+		report.Jobs = append(report.Jobs, cost)
+		report.EnergyKWh += cost.EnergyKWh
+		report.EnergyCost += cost.EnergyCost
+		report.CoinRevenueBTC += cost.RevenueBTC
+
+		a, ok := algos[cost.Algo]
+		if !ok {
+			a = &AlgoCost{Algo: cost.Algo}
+			algos[cost.Algo] = a
+		}
+		a.Jobs++
+		a.EnergyKWh += cost.EnergyKWh
+		a.EnergyCost += cost.EnergyCost
+	}
+	for _, a := range algos {
+		report.Algorithms = append(report.Algorithms, *a)
+	}
+	sort.Slice(report.Algorithms, func(i, j int) bool {
+		return report.Algorithms[i].Algo < report.Algorithms[j].Algo
+	})
+
+	report.RevenueBTC = report.CoinRevenueBTC
+	if g.Ledger != nil {
+		aggs, err := g.Ledger.Aggregate(ledger.Daily, since, until)
+		if err != nil {
+			return nil, err
+		}
+		for _, agg := range aggs {
+			day(agg.Start).RevenueBTC += agg.Earned
+			report.RevenueBTC += agg.Earned
+		}
+	}
+	if report.RevenueBTC != 0 && g.Rates != nil {
+		if report.ExchangeRate, err = g.Rates.ExchangeRate(ctx, "BTC", g.Currency); err != nil {
+			return nil, err
+		}
+	}
+	report.Revenue = report.RevenueBTC * report.ExchangeRate
+	report.NetProfit = report.Revenue - report.EnergyCost
+
+	for _, d := range days {
+		d.Revenue = d.RevenueBTC * report.ExchangeRate
+		d.NetProfit = d.Revenue - d.EnergyCost
+		report.Days = append(report.Days, *d)
+	}
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Date < report.Days[j].Date
+	})
+	return report, nil
+}
+
+// period is the part of the runtime of a job during a UTC day of the report.
+type period struct {
+	start, end time.Time
+	// share is the part of the runtime of the job during the period.
+	share float64
+}
+
+// periods splits the runtime of a job during [since, until) by UTC day.
+// A job which did not run for any time, like a job which never started, is accounted when it ended.
+func periods(job scheduler.JobEnergy, since, until time.Time) []period {
+	start, end := job.Start, job.End
+	if end.IsZero() {
+		end = time.Now()
+	}
+	if start.IsZero() || !start.Before(end) {
+		if end.Before(since) || !end.Before(until) {
+			return nil
+		}
+		return []period{{start: end, end: end, share: 1}}
+	}
+
+	runtime := end.Sub(start).Seconds()
+	if start.Before(since) {
+		start = since
+	}
+	if end.After(until) {
+		end = until
+	}
+	var list []period
+	for start.Before(end) {
+		y, m, d := start.UTC().Date()
+		next := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		if next.After(end) {
+			next = end
+		}
+		list = append(list, period{start: start, end: next, share: next.Sub(start).Seconds() / runtime})
+		start = next
+	}
+	return list
+}
+
+// averagePrice returns the mean electricity price over [start, end), weighted by the time each price applies.
+//
+// Slurm only records the total energy of a job, so the power draw is assumed constant over its duration.
+func (g *Generator) averagePrice(start, end time.Time) float64 {
+	if !start.Before(end) {
+		price, _ := g.PriceSpan(end, end.Add(time.Minute))
+		return price
+	}
+	var sum float64
+	for t := start; t.Before(end); {
+		price, until := g.PriceSpan(t, end)
+		if !until.After(t) {
+			until = t.Add(time.Minute)
+		}
+		if until.After(end) {
+			until = end
+		}
+		sum += price * until.Sub(t).Seconds()
+		t = until
+	}
+	return sum / end.Sub(start).Seconds()
+}
+
+// WriteCSV exports the daily net profit of the report as CSV.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{
+		"date",
+		"revenue_btc",
+		"revenue_" + r.Currency,
+		"energy_kwh",
+		"energy_cost_" + r.Currency,
+		"net_profit_" + r.Currency,
+	}); err != nil {
+		return err
+	}
+	for _, d := range r.Days {
+		if err := cw.Write([]string{
+			d.Date,
+			formatFloat(d.RevenueBTC),
+			formatFloat(d.Revenue),
+			formatFloat(d.EnergyKWh),
+			formatFloat(d.EnergyCost),
+			formatFloat(d.NetProfit),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseFloat parses a number of a job comment, zero if invalid.
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,171 @@
+//go:build unit
+
+package report_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/report"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/stretchr/testify/suite"
+)
+
+type fakeEnergy []scheduler.JobEnergy
+
+func (f fakeEnergy) FindJobsEnergy(
+	ctx context.Context,
+	req *scheduler.FindJobsEnergyRequest,
+) ([]scheduler.JobEnergy, error) {
+	return f, nil
+}
+
+type ReportTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ReportTestSuite) TestGenerate() {
+	// Arrange
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	generator := &report.Generator{
+		Energy: fakeEnergy{
+			{
+				JobID:   "1",
+				Comment: "algo=kawpow",
+				Start:   day,
+				End:     day.Add(2 * time.Hour),
+				Energy:  7.2e6,
+			},
+			{
+				JobID:   "2",
+				Comment: "algo=randomx",
+				Start:   day.Add(20 * time.Hour),
+				End:     day.Add(28 * time.Hour),
+				Energy:  3.6e6,
+			},
+		},
+		PriceSpan: func(t, limit time.Time) (float64, time.Time) {
+			// Off-peak price during the first hour.
+			if t.Before(day.Add(time.Hour)) {
+				return 0.25, day.Add(time.Hour)
+			}
+			return 0.5, limit
+		},
+		Currency: "USD",
+	}
+
+	// Act
+	rep, err := generator.Generate(context.Background(), day, day.AddDate(0, 0, 2))
+
+	// Assert
+	suite.NoError(err)
+	suite.InDelta(3.0, rep.EnergyKWh, 1e-9)
+	suite.InDelta(1.25, rep.EnergyCost, 1e-9)
+	suite.InDelta(-1.25, rep.NetProfit, 1e-9)
+	suite.Len(rep.Algorithms, 2)
+	suite.Equal("kawpow", rep.Algorithms[0].Algo)
+	suite.InDelta(2.0, rep.Algorithms[0].EnergyKWh, 1e-9)
+	suite.Len(rep.Days, 2)
+	suite.Equal("2023-05-01", rep.Days[0].Date)
+	suite.InDelta(-1.0, rep.Days[0].NetProfit, 1e-9)
+
+	var csv bytes.Buffer
+	suite.NoError(rep.WriteCSV(&csv))
+	suite.Equal(
+		"date,revenue_btc,revenue_USD,energy_kwh,energy_cost_USD,net_profit_USD\n"+
+			"2023-05-01,0,0,2.5,1,-1\n"+
+			"2023-05-02,0,0,0.5,0.25,-0.25\n",
+		csv.String(),
+	)
+}
+
+type fakeRates float64
+
+func (f fakeRates) ExchangeRate(ctx context.Context, from string, to string) (float64, error) {
+	return float64(f), nil
+}
+
+func (suite *ReportTestSuite) TestGenerateCoinRevenue() {
+	// Arrange
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	generator := &report.Generator{
+		Energy: fakeEnergy{
+			{
+				JobID:   "1",
+				Comment: "algo=kawpow coin=RVN pool=2miners-rvn rate=5e-07 revenue=0.00004 rewards=80",
+				Start:   day,
+				End:     day.Add(12 * time.Hour),
+				Energy:  3.6e6,
+			},
+		},
+		Rates:     fakeRates(25000),
+		PriceSpan: func(t, limit time.Time) (float64, time.Time) { return 0.2, limit },
+		Currency:  "USD",
+	}
+
+	// Act
+	rep, err := generator.Generate(context.Background(), day, day.AddDate(0, 0, 1))
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("RVN", rep.Jobs[0].Coin)
+	suite.InDelta(40.0, rep.Jobs[0].Rewards, 1e-9)
+	suite.InDelta(0.00002, rep.CoinRevenueBTC, 1e-12)
+	suite.InDelta(0.00002, rep.RevenueBTC, 1e-12)
+	suite.InDelta(0.5, rep.Revenue, 1e-9)
+	suite.InDelta(0.3, rep.NetProfit, 1e-9)
+	suite.InDelta(0.3, rep.Days[0].NetProfit, 1e-9)
+}
+
+func (suite *ReportTestSuite) TestGenerateProRated() {
+	// Arrange
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	var calls int
+	generator := &report.Generator{
+		Energy: fakeEnergy{
+			{
+				// 1 kWh per hour from the middle of the previous day to the middle of the next day.
+				JobID:   "1",
+				Comment: "algo=kawpow coin=RVN pool=2miners-rvn rate=5e-07 revenue=0.00004 rewards=80",
+				Start:   day.Add(-12 * time.Hour),
+				End:     day.Add(36 * time.Hour),
+				Energy:  48 * 3.6e6,
+			},
+		},
+		Rates: fakeRates(25000),
+		PriceSpan: func(t, limit time.Time) (float64, time.Time) {
+			calls++
+			// Peak price from 8:00 to 20:00.
+			midnight := t.Truncate(24 * time.Hour)
+			switch {
+			case t.Hour() < 8:
+				return 0.1, midnight.Add(8 * time.Hour)
+			case t.Hour() < 20:
+				return 0.4, midnight.Add(20 * time.Hour)
+			}
+			return 0.1, limit
+		},
+		Currency: "USD",
+	}
+
+	// Act
+	rep, err := generator.Generate(context.Background(), day, day.AddDate(0, 0, 1))
+
+	// Assert
+	suite.NoError(err)
+	suite.InDelta(24.0, rep.EnergyKWh, 1e-9)
+	suite.InDelta(12*0.4+12*0.1, rep.EnergyCost, 1e-9)
+	suite.InDelta(24.0, rep.Jobs[0].EnergyKWh, 1e-9)
+	suite.InDelta(80.0, rep.Jobs[0].Rewards, 1e-9)
+	suite.InDelta(0.00004, rep.CoinRevenueBTC, 1e-12)
+	suite.Len(rep.Days, 1)
+	suite.Equal("2023-05-01", rep.Days[0].Date)
+	suite.InDelta(1.0-6.0, rep.Days[0].NetProfit, 1e-9)
+	suite.LessOrEqual(calls, 3)
+}
+
+func TestReportTestSuite(t *testing.T) {
+	suite.Run(t, new(ReportTestSuite))
+}
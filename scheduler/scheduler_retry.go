@@ -0,0 +1,301 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// transientErrors are the outputs of Slurm commands which fail because of a temporary controller issue.
+// They are matched case-insensitively.
+var transientErrors = []string{
+	"unable to contact slurm controller",
+	"socket timed out",
+	"zero bytes were transmitted or received",
+	"slurm backup controller in standby mode",
+	"transport endpoint is not connected",
+	"connection refused",
+	"resource temporarily unavailable",
+}
+
+// unreachableErrors are the transient errors raised before the controller processed the request.
+//
+// Only those, and the failures to dial the login node, are retried for non-idempotent commands like sbatch.
+var unreachableErrors = []string{
+	"unable to contact slurm controller",
+	"connection refused",
+}
+
+// ErrCircuitOpen is returned when the circuit breaker rejects a command.
+var ErrCircuitOpen = errors.New("slurm circuit breaker is open")
+
+// IsTransient classifies the failure of a Slurm command from its output and its error.
+//
+// Besides the Slurm errors, the network errors, like a login node which cannot be dialed, and the commands timing
+// out or killed by a signal are transient.
+func IsTransient(out string, err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || killed(err) {
+		return true
+	}
+	return matchesAny(out, err, transientErrors)
+}
+
+// isUnreachable reports whether a transient failure happened before the command reached the controller.
+func isUnreachable(out string, err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return matchesAny(out, err, unreachableErrors)
+}
+
+// killed reports whether the command was killed by a signal, like when the executor timeout expires.
+func killed(err error) bool {
+	var exitErr interface{ ExitCode() int }
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == -1
+}
+
+func matchesAny(out string, err error, patterns []string) bool {
+	if err == nil {
+		return false
+	}
+	out, msg := strings.ToLower(out), strings.ToLower(err.Error())
+	for _, p := range patterns {
+		if strings.Contains(out, p) || strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy configures the exponential backoff of the transient failures.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter is the fraction of the backoff which is randomized, between 0 and 1.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns the delay before the attempt-th retry, starting at 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff += backoff * p.Jitter * (2*rand.Float64() - 1)
+	return time.Duration(backoff)
+}
+
+// CircuitBreaker opens after Threshold consecutive failures and rejects the commands for Cooldown.
+//
+// Once the cooldown has elapsed, a single command is let through: the breaker closes if it succeeds and opens again
+// otherwise.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openedAt  time.Time
+	lastError string
+	probing   bool
+}
+
+// BreakerState is a snapshot of a CircuitBreaker.
+type BreakerState struct {
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+	OpenedAt  time.Time `json:"openedAt,omitempty"`
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.Cooldown || b.probing {
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, b.lastError)
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *CircuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+	metrics.SlurmCircuitOpen.Set(0)
+}
+
+// answered ends a half-open probe which failed because of the request, leaving the state of the breaker unchanged.
+// The next command probes the controller again.
+func (b *CircuitBreaker) answered() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	if b.probing || (b.Threshold > 0 && b.failures >= b.Threshold) {
+		if b.openedAt.IsZero() || b.probing {
+			log.Printf("slurm circuit breaker opened after %d failures: %s", b.failures, err)
+		}
+		b.openedAt = time.Now()
+		b.probing = false
+		metrics.SlurmCircuitOpen.Set(1)
+	}
+}
+
+// State returns a snapshot of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BreakerState{
+		State:     BreakerClosed,
+		Failures:  b.failures,
+		LastError: b.lastError,
+		OpenedAt:  b.openedAt,
+	}
+	if !b.openedAt.IsZero() {
+		state.State = BreakerOpen
+		if b.probing || time.Since(b.openedAt) >= b.Cooldown {
+			state.State = BreakerHalfOpen
+		}
+	}
+	return state
+}
+
+// Healthy reports whether the breaker is not open.
+func (b *CircuitBreaker) Healthy() bool {
+	return b.State().State != BreakerOpen
+}
+
+// RetryExecutor retries the transient failures of the wrapped executor and feeds a circuit breaker.
+type RetryExecutor struct {
+	next    Executor
+	policy  RetryPolicy
+	breaker *CircuitBreaker
+}
+
+// NewRetryExecutor wraps next. breaker may be nil.
+func NewRetryExecutor(next Executor, policy RetryPolicy, breaker *CircuitBreaker) *RetryExecutor {
+	return &RetryExecutor{
+		next:    next,
+		policy:  policy,
+		breaker: breaker,
+	}
+}
+
+func (e *RetryExecutor) Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error) {
+	// Buffer stdin so that it can be replayed.
+	var body []byte
+	if stdin != nil {
+		var err error
+		if body, err = io.ReadAll(stdin); err != nil {
+			return "", err
+		}
+	}
+	// sbatch is not idempotent: a timed out submission may have been accepted.
+	idempotent := len(argv) > 0 && filepath.Base(argv[0]) != "sbatch"
+
+	command := ""
+	if len(argv) > 0 {
+		command = filepath.Base(argv[0])
+	}
+	return e.do(ctx, command, idempotent, func() (string, error) {
+		var in io.Reader
+		if stdin != nil {
+			in = bytes.NewReader(body)
+		}
+		return e.next.Run(ctx, user, argv, in)
+	})
+}
+
+func (e *RetryExecutor) do(ctx context.Context, command string, idempotent bool, fn func() (string, error)) (string, error) {
+	if e.breaker != nil {
+		if err := e.breaker.allow(); err != nil {
+			return "", err
+		}
+	}
+
+	var out string
+	var err error
+retry:
+	for attempt := 1; ; attempt++ {
+		out, err = fn()
+		if err == nil {
+			if e.breaker != nil {
+				e.breaker.success()
+			}
+			return out, nil
+		}
+
+		if !IsTransient(out, err) {
+			// Permanent failures are caused by the request: they neither open nor close the breaker.
+			if e.breaker != nil {
+				e.breaker.answered()
+			}
+			return out, err
+		}
+		retryable := idempotent || isUnreachable(out, err)
+		if !retryable || attempt >= e.policy.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		metrics.SlurmRetries.WithLabelValues(command).Inc()
+		backoff := e.policy.Backoff(attempt)
+		log.Printf("transient slurm failure (attempt %d/%d), retrying in %s: %s", attempt, e.policy.MaxAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			// Still a failure, which also ends a half-open probe.
+			break retry
+		}
+	}
+
+	if e.breaker != nil {
+		e.breaker.failure(err)
+	}
+	return out, err
+}
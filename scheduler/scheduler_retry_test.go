@@ -0,0 +1,272 @@
+//go:build unit
+
+package scheduler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/mocks"
+	"github.com/squarefactory/miner-api/scheduler"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+)
+
+var errExit = errors.New("exit status 1")
+
+type RetryTestSuite struct {
+	suite.Suite
+	executor *mocks.Executor
+	breaker  *scheduler.CircuitBreaker
+	impl     *scheduler.RetryExecutor
+}
+
+func (suite *RetryTestSuite) BeforeTest(suiteName, testName string) {
+	suite.executor = mocks.NewExecutor(suite.T())
+	suite.breaker = &scheduler.CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  time.Hour,
+	}
+	suite.impl = scheduler.NewRetryExecutor(
+		suite.executor,
+		scheduler.RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Multiplier:     2,
+		},
+		suite.breaker,
+	)
+}
+
+func (suite *RetryTestSuite) TestRetriesTransientFailures() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("squeue: error: Unable to contact slurm controller (connect failure)", errExit).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("ok", nil).
+		Once()
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("ok", out)
+	suite.Equal(scheduler.BreakerClosed, suite.breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestReplaysStdin() {
+	// Arrange
+	var bodies []string
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := io.ReadAll(args.Get(3).(io.Reader))
+			bodies = append(bodies, string(b))
+		}).
+		Return("sbatch: error: Unable to contact slurm controller", errExit).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			b, _ := io.ReadAll(args.Get(3).(io.Reader))
+			bodies = append(bodies, string(b))
+		}).
+		Return("123", nil).
+		Once()
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.Run(ctx, user, []string{"sbatch"}, strings.NewReader("#!/bin/sh"))
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]string{"#!/bin/sh", "#!/bin/sh"}, bodies)
+}
+
+func (suite *RetryTestSuite) TestDoesNotRetryTimedOutSubmission() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, mock.Anything).
+		Return("sbatch: error: Socket timed out on send/recv operation", errExit).
+		Once()
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.Run(ctx, user, []string{"sbatch"}, strings.NewReader("#!/bin/sh"))
+
+	// Assert
+	suite.Error(err)
+	suite.executor.AssertNumberOfCalls(suite.T(), "Run", 1)
+}
+
+func (suite *RetryTestSuite) TestDoesNotRetryPermanentFailures() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, nil).
+		Return("scancel: error: Invalid job id specified", errExit).
+		Once()
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.Run(ctx, user, []string{"scancel", "abc"}, nil)
+
+	// Assert
+	suite.Error(err)
+	suite.Equal(scheduler.BreakerClosed, suite.breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestOpensCircuitBreaker() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, nil).
+		Return("squeue: error: Socket timed out on send/recv operation", errExit).
+		Times(6)
+	ctx := context.Background()
+
+	// Act
+	_, err1 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+	_, err2 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+	_, err3 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.Error(err1)
+	suite.Error(err2)
+	suite.ErrorIs(err3, scheduler.ErrCircuitOpen)
+	suite.Equal(scheduler.BreakerOpen, suite.breaker.State().State)
+	suite.False(suite.breaker.Healthy())
+}
+
+func (suite *RetryTestSuite) TestCancelledProbeEndsHalfOpenState() {
+	// Arrange
+	breaker := &scheduler.CircuitBreaker{Threshold: 1, Cooldown: 10 * time.Millisecond}
+	impl := scheduler.NewRetryExecutor(
+		suite.executor,
+		scheduler.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, Multiplier: 2},
+		breaker,
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.executor.On("Run", mock.Anything, user, []string{"sbatch"}, nil).
+		Return("sbatch: error: Socket timed out on send/recv operation", errExit).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Run(func(args mock.Arguments) { time.AfterFunc(5*time.Millisecond, cancel) }).
+		Return("squeue: error: Socket timed out on send/recv operation", errExit).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("", nil).
+		Once()
+
+	// Act
+	_, errOpen := impl.Run(context.Background(), user, []string{"sbatch"}, nil)
+	time.Sleep(20 * time.Millisecond)
+	_, errProbe := impl.Run(ctx, user, []string{"squeue"}, nil)
+	stateAfterProbe := breaker.State().State
+	time.Sleep(20 * time.Millisecond)
+	_, errNextProbe := impl.Run(context.Background(), user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.Error(errOpen)
+	suite.Error(errProbe)
+	suite.NotErrorIs(errProbe, scheduler.ErrCircuitOpen)
+	suite.Equal(scheduler.BreakerOpen, stateAfterProbe)
+	suite.NoError(errNextProbe)
+	suite.Equal(scheduler.BreakerClosed, breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestRetriesDialErrors() {
+	// Arrange
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	suite.executor.On("Run", mock.Anything, user, []string{"sbatch"}, mock.Anything).
+		Return("", dialErr).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"sbatch"}, mock.Anything).
+		Return("123", nil).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("", dialErr).
+		Times(6)
+	ctx := context.Background()
+
+	// Act
+	out, errSubmit := suite.impl.Run(ctx, user, []string{"sbatch"}, strings.NewReader("#!/bin/sh"))
+	_, err1 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+	_, err2 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.NoError(errSubmit)
+	suite.Equal("123", out)
+	suite.ErrorIs(err1, syscall.ECONNREFUSED)
+	suite.ErrorIs(err2, syscall.ECONNREFUSED)
+	suite.Equal(scheduler.BreakerOpen, suite.breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestTimeoutsOpenCircuitBreaker() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, []string{"sbatch"}, mock.Anything).
+		Return("", context.DeadlineExceeded).
+		Once()
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("", context.DeadlineExceeded).
+		Times(3)
+	ctx := context.Background()
+
+	// Act
+	_, errSubmit := suite.impl.Run(ctx, user, []string{"sbatch"}, strings.NewReader("#!/bin/sh"))
+	_, err := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.ErrorIs(errSubmit, context.DeadlineExceeded)
+	suite.ErrorIs(err, context.DeadlineExceeded)
+	suite.Equal(scheduler.BreakerOpen, suite.breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestPermanentFailuresKeepFailureCount() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, user, []string{"squeue"}, nil).
+		Return("squeue: error: connect: connection refused", errExit).
+		Times(6)
+	suite.executor.On("Run", mock.Anything, user, []string{"scancel", "abc"}, nil).
+		Return("scancel: error: Invalid job id specified", errExit).
+		Once()
+	ctx := context.Background()
+
+	// Act
+	_, err1 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+	_, errPermanent := suite.impl.Run(ctx, user, []string{"scancel", "abc"}, nil)
+	failures := suite.breaker.State().Failures
+	_, err2 := suite.impl.Run(ctx, user, []string{"squeue"}, nil)
+
+	// Assert
+	suite.Error(err1)
+	suite.Error(errPermanent)
+	suite.Error(err2)
+	suite.Equal(1, failures)
+	suite.Equal(scheduler.BreakerOpen, suite.breaker.State().State)
+}
+
+func (suite *RetryTestSuite) TestIsTransient() {
+	suite.True(scheduler.IsTransient("", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}))
+	suite.True(scheduler.IsTransient("", fmt.Errorf("ssh: %w", context.DeadlineExceeded)))
+	suite.True(scheduler.IsTransient("", killedError{}))
+	suite.True(scheduler.IsTransient("squeue: error: connect: connection refused", errExit))
+	suite.True(scheduler.IsTransient("", errors.New("SOCKET TIMED OUT")))
+	suite.False(scheduler.IsTransient("scancel: error: Invalid job id specified", errExit))
+	suite.False(scheduler.IsTransient("", context.Canceled))
+	suite.False(scheduler.IsTransient("", nil))
+}
+
+// killedError is the error of a process killed by a signal, like *exec.ExitError.
+type killedError struct{}
+
+func (killedError) Error() string { return "signal: killed" }
+
+func (killedError) ExitCode() int { return -1 }
+
+func TestRetryTestSuite(t *testing.T) {
+	suite.Run(t, &RetryTestSuite{})
+}
@@ -5,14 +5,48 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/squarefactory/miner-api/utils"
+	"time"
 )
 
 const QosName = "mining"
 
+// OutputPattern is the sbatch --output pattern of the mining jobs.
+const OutputPattern = "/tmp/miner-%j_%a.log"
+
+// LogPath returns the path of the log of an array task, following OutputPattern.
+func LogPath(id int, taskID int) string {
+	return fmt.Sprintf("/tmp/miner-%d_%d.log", id, taskID)
+}
+
+// FormatComment formats tags as a job comment, like "algo=kawpow instance=a".
+func FormatComment(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+tags[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseComment parses the tags of a job comment formatted by FormatComment.
+func ParseComment(comment string) map[string]string {
+	tags := make(map[string]string)
+	for _, field := range strings.Fields(comment) {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
 type Slurm struct {
 	executor  Executor
 	adminUser string
@@ -28,68 +62,276 @@ func NewSlurm(
 	}
 }
 
-// CancelJob kills a job using scancel command.
+// cancelPollInterval is the interval between the checks of the tasks stopping after a signal.
+const cancelPollInterval = time.Second
+
+// signalPattern matches the signal names accepted by scancel, without the SIG prefix.
+var signalPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*$`)
+
+// ValidateSignal checks a signal name like "TERM".
+func ValidateSignal(signal string) error {
+	if !signalPattern.MatchString(signal) || strings.HasPrefix(signal, "SIG") {
+		return fmt.Errorf("invalid signal %q, expected a name like TERM", signal)
+	}
+	return nil
+}
+
+// CancelJob cancels a job using scancel command: req.JobID or its req.TaskIDs, else the jobs named req.Name.
+//
+// If req.Signal is set, it is sent to the job first. The running tasks are killed once req.GracePeriod elapses,
+// then the job is cancelled.
 func (s *Slurm) CancelJob(ctx context.Context, req *CancelRequest) error {
-	cmd := fmt.Sprintf("scancel --name=%s --me", req.Name)
-	_, err := s.executor.ExecAs(ctx, req.User, cmd)
+	if req.Signal != "" {
+		if err := ValidateSignal(req.Signal); err != nil {
+			return err
+		}
+		if err := s.signal(ctx, req, req.Signal); err != nil {
+			return err
+		}
+		stopped, err := s.waitStopped(ctx, req)
+		if err != nil {
+			return err
+		}
+		if !stopped {
+			log.Printf("job %s still running after %s, killing it", req.Name, req.GracePeriod)
+			if err := s.signal(ctx, req, "KILL"); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := s.executor.Run(ctx, req.User, append([]string{"scancel"}, req.targets()...), nil)
 	if err != nil {
 		log.Printf("cancel failed: %s", err)
 	}
 	return err
 }
 
+// targets returns the scancel arguments selecting the jobs of the request.
+func (req *CancelRequest) targets() []string {
+	if req.JobID == 0 {
+		return []string{"--name=" + req.Name, "--me"}
+	}
+	target := strconv.Itoa(req.JobID)
+	if len(req.TaskIDs) > 0 {
+		ids := make([]string, 0, len(req.TaskIDs))
+		for _, id := range req.TaskIDs {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		target += "_[" + strings.Join(ids, ",") + "]"
+	}
+	return []string{"--me", target}
+}
+
+// signal sends a signal to the batch script and every step of the running tasks of a job.
+func (s *Slurm) signal(ctx context.Context, req *CancelRequest, signal string) error {
+	_, err := s.executor.Run(ctx, req.User, append([]string{
+		"scancel",
+		"--signal=" + signal,
+		"--full",
+	}, req.targets()...), nil)
+	if err != nil {
+		log.Printf("signal %s failed: %s", signal, err)
+	}
+	return err
+}
+
+// selects reports whether the task is cancelled by the request.
+func (req *CancelRequest) selects(t Task) bool {
+	if len(req.TaskIDs) == 0 {
+		return true
+	}
+	for _, id := range req.TaskIDs {
+		if t.TaskID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// waitStopped waits for the tasks of a job to stop running, up to req.GracePeriod.
+func (s *Slurm) waitStopped(ctx context.Context, req *CancelRequest) (bool, error) {
+	deadline := time.Now().Add(req.GracePeriod)
+	for {
+		tasks, err := s.ListTasks(ctx, &FindRunningJobByNameRequest{
+			Name:  req.Name,
+			User:  req.User,
+			JobID: req.JobID,
+		})
+		if err != nil {
+			return false, err
+		}
+		running := false
+		for _, t := range tasks {
+			if (t.State == TaskRunning || t.State == "COMPLETING") && req.selects(t) {
+				running = true
+			}
+		}
+		if !running {
+			return true, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		if remaining > cancelPollInterval {
+			remaining = cancelPollInterval
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(remaining):
+		}
+	}
+}
+
 // Submit a sbatch definition script to the SLURM controller using the sbatch command.
+//
+// The script is passed to sbatch on stdin.
 func (s *Slurm) Submit(ctx context.Context, req *SubmitRequest) (string, error) {
-	eof := utils.GenerateRandomString(10)
-
-	cmd := fmt.Sprintf(`sbatch \
-  --job-name=%s \
-  --qos=%s \
-  --output=/tmp/miner-%%j_%%a.log \
-  --parsable << '%s'
-%s
-%s`,
-		req.Name,
-		QosName,
-		eof,
-		req.Body,
-		eof,
-	)
-	out, err := s.executor.ExecAs(ctx, req.User, cmd)
+	argv := []string{
+		"sbatch",
+		"--job-name=" + req.Name,
+		"--qos=" + QosName,
+		"--output=" + OutputPattern,
+		"--parsable",
+	}
+	if req.Comment != "" {
+		argv = append(argv, "--comment="+req.Comment)
+	}
+	if req.Requeue {
+		argv = append(argv, "--requeue", "--open-mode=append")
+	}
+	argv = append(argv, req.Placement.Args()...)
+	out, err := s.executor.Run(ctx, req.User, argv, strings.NewReader(req.Body))
 	if err != nil {
 		log.Printf("submit failed: %s", err)
-		return strings.TrimSpace(strings.TrimRight(string(out), "\n")), err
+		return strings.TrimSpace(out), err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// ParseJobID parses the job ID printed by `sbatch --parsable`, like "123" or "123;cluster".
+func ParseJobID(out string) (int, error) {
+	id, _, _ := strings.Cut(strings.TrimSpace(out), ";")
+	jobID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, fmt.Errorf("invalid job ID %q", out)
+	}
+	return jobID, nil
+}
+
+// Args returns the sbatch options of the placement.
+func (p Placement) Args() []string {
+	var args []string
+	for _, opt := range []struct{ name, value string }{
+		{"partition", p.Partition},
+		{"account", p.Account},
+		{"reservation", p.Reservation},
+		{"constraint", p.Constraint},
+		{"exclude", p.Exclude},
+		{"nodelist", p.NodeList},
+		{"time", p.Time},
+	} {
+		if opt.value != "" {
+			args = append(args, "--"+opt.name+"="+opt.value)
+		}
 	}
+	if p.Nice != 0 {
+		args = append(args, "--nice="+strconv.Itoa(p.Nice))
+	}
+	return args
+}
 
-	return strings.TrimSpace(strings.TrimRight(string(out), "\n")), nil
+// Validate checks that the options of the placement are single words.
+func (p Placement) Validate() error {
+	for name, value := range map[string]string{
+		"partition":   p.Partition,
+		"account":     p.Account,
+		"reservation": p.Reservation,
+		"constraint":  p.Constraint,
+		"exclude":     p.Exclude,
+		"nodelist":    p.NodeList,
+		"time":        p.Time,
+	} {
+		if strings.ContainsAny(value, " \t\r\n") || strings.HasPrefix(value, "-") {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	return nil
 }
 
 // HealthCheck runs squeue to check if the queue is running
 func (s *Slurm) HealthCheck(ctx context.Context) error {
-	_, err := s.executor.ExecAs(ctx, s.adminUser, "squeue")
+	_, err := s.executor.Run(ctx, s.adminUser, []string{"squeue"}, nil)
 	if err != nil {
 		log.Printf("healthcheck failed: %s", err)
 	}
 	return err
 }
 
+// ListJobs lists the job arrays of the user named req.Name, with their comments.
+func (s *Slurm) ListJobs(
+	ctx context.Context,
+	req *FindRunningJobByNameRequest,
+) ([]Job, error) {
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"squeue",
+		"--name=" + req.Name,
+		"--me",
+		"--noheader",
+		"--format=%F|%k",
+	}, nil)
+	if err != nil {
+		log.Printf("ListJobs failed: %s", err)
+		return nil, err
+	}
+
+	var jobs []Job
+	seen := make(map[int]bool)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		id, comment, ok := strings.Cut(strings.TrimSpace(line), "|")
+		if !ok {
+			continue
+		}
+		jobID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, err
+		}
+		// The tasks of an array in different states are listed on separate lines.
+		if seen[jobID] {
+			continue
+		}
+		seen[jobID] = true
+		jobs = append(jobs, Job{ID: jobID, Comment: comment})
+	}
+	return jobs, nil
+}
+
 // FindRunningJobByName find a running job using squeue.
 func (s *Slurm) FindRunningJobByName(
 	ctx context.Context,
 	req *FindRunningJobByNameRequest,
 ) (int, error) {
-	cmd := fmt.Sprintf("squeue --name %s -O ArrayJobId:256 --noheader", req.Name)
-	out, err := s.executor.ExecAs(ctx, req.User, cmd)
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"squeue",
+		"--name=" + req.Name,
+		"-O", "ArrayJobId:256",
+		"--noheader",
+	}, nil)
 	if err != nil {
 		log.Printf("FindRunningJobByName failed: %s", err)
 		return 0, err
 	}
 
-	lines := strings.Split(strings.TrimSpace(out), "\n")
-	if len(lines) == 0 {
+	out = strings.TrimSpace(out)
+	if len(out) == 0 {
 		log.Println("no jobs currently running")
 		return 0, errors.New("no running jobs found")
 	}
+	lines := strings.Split(out, "\n")
 
 	jobID, err := strconv.Atoi(strings.TrimSpace(lines[0]))
 	if err != nil {
@@ -100,25 +342,196 @@ func (s *Slurm) FindRunningJobByName(
 	return jobID, nil
 }
 
-func (s *Slurm) FindMaxGPU(ctx context.Context) (int, error) {
-	cmd := "scontrol show nodes | grep CfgTRES | sed -E 's|.*gres/gpu=([^,]*)|\\1|g'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+// ListTasks lists the tasks of the jobs of req.User named req.Name, expanding the job arrays.
+func (s *Slurm) ListTasks(
+	ctx context.Context,
+	req *FindRunningJobByNameRequest,
+) ([]Task, error) {
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"squeue",
+		"--name=" + req.Name,
+		"--me",
+		"--array",
+		"--noheader",
+		"--format=%F|%K|%A|%T|%N|%r",
+	}, nil)
 	if err != nil {
-		log.Printf("FindMaxGPU failed: %s", err)
+		log.Printf("ListTasks failed: %s", err)
+		return nil, err
+	}
+
+	tasks, err := parseTasks(out)
+	if err != nil || req.JobID == 0 {
+		return tasks, err
+	}
+	// Filtered here, squeue --jobs failing once the job is purged.
+	var filtered []Task
+	for _, t := range tasks {
+		if t.JobID == req.JobID {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered, nil
+}
+
+// parseTasks parses the output of `squeue --format=%F|%K|%A|%T|%N|%r`.
+func parseTasks(out string) ([]Task, error) {
+	var tasks []Task
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 6 {
+			continue
+		}
+		jobID, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		// Pending arrays are not expanded, like "1-4".
+		taskID, err := strconv.Atoi(fields[1])
+		if err != nil {
+			taskID = -1
+		}
+		tasks = append(tasks, Task{
+			JobID:  jobID,
+			ID:     id,
+			TaskID: taskID,
+			State:  fields[3],
+			Node:   fields[4],
+			Reason: reason(fields[5]),
+		})
+	}
+	return tasks, nil
+}
+
+// reason returns the reason of a task, empty if squeue reports "None".
+func reason(r string) string {
+	if r == "None" {
+		return ""
+	}
+	return r
+}
+
+// TailLog reads the end of the log of an array task.
+func (s *Slurm) TailLog(ctx context.Context, req *TailLogRequest) (string, error) {
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"tail",
+		"--bytes=" + strconv.Itoa(req.MaxBytes),
+		LogPath(req.ID, req.TaskID),
+	}, nil)
+	if err != nil {
+		log.Printf("TailLog failed: %s", err)
+		return "", err
+	}
+	return out, nil
+}
+
+// LogSize returns the size in bytes of the log of an array task.
+func (s *Slurm) LogSize(ctx context.Context, req *TailLogRequest) (int64, error) {
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"stat",
+		"--format=%s",
+		LogPath(req.ID, req.TaskID),
+	}, nil)
+	if err != nil {
+		log.Printf("LogSize failed: %s", err)
 		return 0, err
 	}
+	return strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+}
 
-	out = strings.TrimSpace(string(out))
-	lines := strings.Split(out, "\n")
+// ReadLog reads a range of the log of an array task.
+func (s *Slurm) ReadLog(ctx context.Context, req *ReadLogRequest) (string, error) {
+	out, err := s.executor.Run(ctx, req.User, []string{
+		"dd",
+		"if=" + LogPath(req.ID, req.TaskID),
+		"iflag=skip_bytes,count_bytes",
+		"skip=" + strconv.FormatInt(req.Offset, 10),
+		"count=" + strconv.FormatInt(req.Length, 10),
+		"status=none",
+	}, nil)
+	if err != nil {
+		log.Printf("ReadLog failed: %s", err)
+		return "", err
+	}
+	return out, nil
+}
 
-	maxGPU := 0
-	for _, line := range lines {
-		num, err := strconv.Atoi(line)
+// sacctTimeFormat is the format of the times of sacct.
+const sacctTimeFormat = "2006-01-02T15:04:05"
+
+// FindJobsEnergy lists the energy consumed by the jobs, using sacct.
+//
+// The energy is only recorded if an acct_gather_energy plugin is configured.
+func (s *Slurm) FindJobsEnergy(ctx context.Context, req *FindJobsEnergyRequest) ([]JobEnergy, error) {
+	argv := []string{
+		"sacct",
+		"--name=" + strings.Join(req.Names, ","),
+		"--allocations",
+		"--noheader",
+		"--parsable2",
+		"--format=JobIDRaw,JobName,Start,End,ConsumedEnergyRaw,Comment",
+	}
+	if !req.Since.IsZero() {
+		argv = append(argv, "--starttime="+req.Since.Local().Format(sacctTimeFormat))
+	}
+	if !req.Until.IsZero() {
+		argv = append(argv, "--endtime="+req.Until.Local().Format(sacctTimeFormat))
+	}
+	out, err := s.executor.Run(ctx, req.User, argv, nil)
+	if err != nil {
+		log.Printf("FindJobsEnergy failed: %s", err)
+		return nil, err
+	}
+
+	return parseJobsEnergy(out)
+}
+
+// parseJobsEnergy parses the output of `sacct --parsable2 --format=JobIDRaw,JobName,Start,End,ConsumedEnergyRaw,Comment`.
+func parseJobsEnergy(out string) ([]JobEnergy, error) {
+	var jobs []JobEnergy
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "|", 6)
+		if len(fields) != 6 {
+			continue
+		}
+		job := JobEnergy{
+			JobID:   fields[0],
+			Name:    fields[1],
+			Comment: fields[5],
+		}
+		start, err := time.ParseInLocation(sacctTimeFormat, fields[2], time.Local)
 		if err != nil {
-			log.Printf("Failed to convert %q to integer: %s", line, err)
-			return 0, err
+			// The job has not started yet.
+			continue
 		}
-		maxGPU += num
+		job.Start = start
+		if end, err := time.ParseInLocation(sacctTimeFormat, fields[3], time.Local); err == nil {
+			job.End = end
+		}
+		if fields[4] != "" {
+			if job.Energy, err = strconv.ParseFloat(fields[4], 64); err != nil {
+				return nil, err
+			}
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *Slurm) FindMaxGPU(ctx context.Context) (int, error) {
+	nodes, err := s.showNodes(ctx)
+	if err != nil {
+		log.Printf("FindMaxGPU failed: %s", err)
+		return 0, err
+	}
+
+	maxGPU := 0
+	for _, node := range nodes {
+		maxGPU += node.GPUs
 	}
 
 	return maxGPU, nil
@@ -126,25 +539,15 @@ func (s *Slurm) FindMaxGPU(ctx context.Context) (int, error) {
 
 // FindMaxCPU computes the maximum number of cores available from the cluster
 func (s *Slurm) FindMaxCPU(ctx context.Context) (int, error) {
-
-	cmd := "scontrol show nodes | grep CfgTRES | sed -E 's/.*cpu=([0-9]+).*/\\1/'"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	nodes, err := s.showNodes(ctx)
 	if err != nil {
 		log.Printf("FindMaxCPU failed: %s", err)
 		return 0, err
 	}
 
-	out = strings.TrimSpace(string(out))
-	lines := strings.Split(out, "\n")
-
 	maxCPU := 0
-	for _, line := range lines {
-		num, err := strconv.Atoi(line)
-		if err != nil {
-			log.Printf("Failed to convert %q to integer: %s", line, err)
-			return 0, err
-		}
-		maxCPU += num
+	for _, node := range nodes {
+		maxCPU += node.CPUs
 	}
 
 	return maxCPU, nil
@@ -152,16 +555,473 @@ func (s *Slurm) FindMaxCPU(ctx context.Context) (int, error) {
 
 // FindMaxNode finds the number of nodes available in the cluster
 func (s *Slurm) FindMaxNode(ctx context.Context) (int, error) {
-
-	cmd := "scontrol show nodes | grep NodeName"
-	out, err := s.executor.ExecAs(ctx, s.adminUser, cmd)
+	nodes, err := s.showNodes(ctx)
 	if err != nil {
-		log.Printf("FindMaxCPU failed: %s", err)
+		log.Printf("FindMaxNode failed: %s", err)
 		return 0, err
 	}
 
-	out = strings.TrimSpace(string(out))
-	lines := strings.Split(out, "\n")
+	return len(nodes), nil
+}
+
+// FindCapacity sums the resources of the nodes which the jobs of a placement may run on: the nodes of its partition,
+// or of the cluster if empty, and of its reservation, in its node list, not excluded and with its constraint.
+func (s *Slurm) FindCapacity(ctx context.Context, placement Placement) (Capacity, error) {
+	nodes, err := s.showNodes(ctx)
+	if err != nil {
+		log.Printf("FindCapacity failed: %s", err)
+		return Capacity{}, err
+	}
+	matches, err := s.nodeFilter(ctx, placement)
+	if err != nil {
+		log.Printf("FindCapacity failed: %s", err)
+		return Capacity{}, err
+	}
+
+	var capacity Capacity
+	for _, node := range nodes {
+		if !matches(&node) {
+			continue
+		}
+		capacity.Nodes++
+		capacity.CPUs += node.CPUs
+		capacity.GPUs += node.GPUs
+		if node.available(placement.Reservation != "") {
+			capacity.IdleCPUs += node.CPUs - node.AllocCPUs
+			capacity.IdleGPUs += node.GPUs - node.AllocGPUs
+		}
+	}
+	return capacity, nil
+}
+
+// node is the subset of `scontrol show nodes` used by miner-api.
+type node struct {
+	Name       string
+	CPUs       int
+	GPUs       int
+	AllocCPUs  int
+	AllocGPUs  int
+	Partitions []string
+	// Features are the available features of the node, matched by the constraints.
+	Features []string
+	// States are the base state and the flags of the node, like ["MIXED", "DRAIN"].
+	States []string
+}
+
+// available reports whether jobs can start on the node, the reserved nodes being available to the jobs of their
+// reservation.
+func (n *node) available(reservation bool) bool {
+	for _, state := range n.States {
+		switch state {
+		case "DOWN", "DRAIN", "FAIL", "NOT_RESPONDING", "MAINTENANCE":
+			return false
+		case "RESERVED":
+			if !reservation {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nodeFilter returns whether the jobs of the placement may run on a node.
+func (s *Slurm) nodeFilter(ctx context.Context, p Placement) (func(n *node) bool, error) {
+	var included, excluded, reserved map[string]bool
+	var err error
+	if p.NodeList != "" {
+		if included, err = s.hostnames(ctx, p.NodeList); err != nil {
+			return nil, err
+		}
+	}
+	if p.Exclude != "" {
+		if excluded, err = s.hostnames(ctx, p.Exclude); err != nil {
+			return nil, err
+		}
+	}
+	if p.Reservation != "" {
+		list, err := s.reservationNodes(ctx, p.Reservation)
+		if err != nil {
+			return nil, err
+		}
+		if reserved, err = s.hostnames(ctx, list); err != nil {
+			return nil, err
+		}
+	}
+	var constraint *constraint
+	if p.Constraint != "" {
+		if constraint, err = parseConstraint(p.Constraint); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(n *node) bool {
+		switch {
+		case p.Partition != "" && !n.inPartition(p.Partition):
+			return false
+		case included != nil && !included[n.Name]:
+			return false
+		case excluded[n.Name]:
+			return false
+		case reserved != nil && !reserved[n.Name]:
+			return false
+		case constraint != nil && !constraint.matches(n.Features):
+			return false
+		}
+		return true
+	}, nil
+}
+
+// hostnames expands a host list, like "cn[1-4],cn7", with `scontrol show hostnames`.
+func (s *Slurm) hostnames(ctx context.Context, list string) (map[string]bool, error) {
+	out, err := s.executor.Run(ctx, s.adminUser, []string{
+		"scontrol",
+		"show",
+		"hostnames",
+		list,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, name := range strings.Fields(out) {
+		names[name] = true
+	}
+	return names, nil
+}
+
+// reservationNodes returns the host list of a reservation, with `scontrol show reservation`.
+func (s *Slurm) reservationNodes(ctx context.Context, reservation string) (string, error) {
+	out, err := s.executor.Run(ctx, s.adminUser, []string{
+		"scontrol",
+		"show",
+		"reservation",
+		reservation,
+		"--oneliner",
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	nodes := parseKeyValues(out)["Nodes"]
+	if nodes == "" || nodes == "(null)" {
+		return "", fmt.Errorf("reservation %s has no nodes", reservation)
+	}
+	return nodes, nil
+}
+
+// constraint is a node feature expression, like "a100&nvlink", "a100|v100" or "[rack1|rack2]&(a100*2)".
+//
+// The counts of features are ignored: each node is matched on its own.
+type constraint struct {
+	feature  string
+	op       byte // '&' or '|' between the operands, 0 for a feature.
+	operands []*constraint
+}
+
+// parseConstraint parses a constraint, '&' binding tighter than '|'.
+func parseConstraint(expr string) (*constraint, error) {
+	p := &constraintParser{expr: expr}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.expr) {
+		return nil, fmt.Errorf("invalid constraint %q", expr)
+	}
+	return c, nil
+}
+
+type constraintParser struct {
+	expr string
+	pos  int
+}
+
+func (p *constraintParser) or() (*constraint, error) {
+	return p.binary('|', p.and)
+}
+
+func (p *constraintParser) and() (*constraint, error) {
+	return p.binary('&', p.operand)
+}
+
+func (p *constraintParser) binary(op byte, next func() (*constraint, error)) (*constraint, error) {
+	c, err := next()
+	if err != nil {
+		return nil, err
+	}
+	operands := []*constraint{c}
+	for p.pos < len(p.expr) && p.expr[p.pos] == op {
+		p.pos++
+		c, err := next()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, c)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &constraint{op: op, operands: operands}, nil
+}
+
+func (p *constraintParser) operand() (*constraint, error) {
+	if p.pos < len(p.expr) && (p.expr[p.pos] == '(' || p.expr[p.pos] == '[') {
+		closing := byte(')')
+		if p.expr[p.pos] == '[' {
+			closing = ']'
+		}
+		p.pos++
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.expr) || p.expr[p.pos] != closing {
+			return nil, fmt.Errorf("invalid constraint %q", p.expr)
+		}
+		p.pos++
+		return c, p.count()
+	}
+	start := p.pos
+	for p.pos < len(p.expr) && !strings.ContainsRune("&|()[]*", rune(p.expr[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, fmt.Errorf("invalid constraint %q", p.expr)
+	}
+	c := &constraint{feature: p.expr[start:p.pos]}
+	return c, p.count()
+}
+
+// count skips the count of an operand, like "*2".
+func (p *constraintParser) count() error {
+	if p.pos >= len(p.expr) || p.expr[p.pos] != '*' {
+		return nil
+	}
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.expr) && p.expr[p.pos] >= '0' && p.expr[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return fmt.Errorf("invalid constraint %q", p.expr)
+	}
+	return nil
+}
+
+// matches reports whether a node with features satisfies the constraint.
+func (c *constraint) matches(features []string) bool {
+	switch c.op {
+	case '&':
+		for _, o := range c.operands {
+			if !o.matches(features) {
+				return false
+			}
+		}
+		return true
+	case '|':
+		for _, o := range c.operands {
+			if o.matches(features) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, f := range features {
+		if f == c.feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *node) inPartition(partition string) bool {
+	for _, p := range n.Partitions {
+		if p == partition {
+			return true
+		}
+	}
+	return false
+}
+
+// showNodes runs `scontrol show nodes` and parses the configured TRES of each node.
+func (s *Slurm) showNodes(ctx context.Context) ([]node, error) {
+	out, err := s.executor.Run(ctx, s.adminUser, []string{
+		"scontrol",
+		"show",
+		"nodes",
+		"--oneliner",
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNodes(out)
+}
+
+// parseNodes parses the output of `scontrol show nodes --oneliner`.
+func parseNodes(out string) ([]node, error) {
+	var nodes []node
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := parseKeyValues(line)
+		name, ok := fields["NodeName"]
+		if !ok {
+			continue
+		}
+		n := node{Name: name}
+		if partitions := fields["Partitions"]; partitions != "" {
+			n.Partitions = strings.Split(partitions, ",")
+		}
+		if features := fields["AvailableFeatures"]; features != "" && features != "(null)" {
+			n.Features = strings.Split(features, ",")
+		}
+		if state := fields["State"]; state != "" {
+			n.States = strings.Split(strings.TrimRight(state, "*~#!%$@^-"), "+")
+		}
+		var err error
+		if n.CPUs, n.GPUs, err = parseTRES(fields["CfgTRES"]); err != nil {
+			return nil, err
+		}
+		if n.AllocCPUs, n.AllocGPUs, err = parseTRES(fields["AllocTRES"]); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
 
-	return len(lines), nil
+	return nodes, nil
+}
+
+// parseTRES parses the CPUs and the GPUs of a TRES list, like "cpu=16,mem=64000M,gres/gpu=2".
+func parseTRES(list string) (cpus int, gpus int, err error) {
+	for _, tres := range strings.Split(list, ",") {
+		key, value, ok := strings.Cut(tres, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "cpu":
+			cpus, err = strconv.Atoi(value)
+		case "gres/gpu":
+			gpus, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			log.Printf("Failed to convert %q to integer: %s", value, err)
+			return 0, 0, err
+		}
+	}
+	return cpus, gpus, nil
+}
+
+// ListQos lists the QoS of the accounting database with sacctmgr.
+func (s *Slurm) ListQos(ctx context.Context) ([]Qos, error) {
+	out, err := s.executor.Run(ctx, s.adminUser, []string{
+		"sacctmgr",
+		"show",
+		"qos",
+		"--parsable2",
+		"--noheader",
+		"format=Name,Preempt,PreemptMode",
+	}, nil)
+	if err != nil {
+		log.Printf("ListQos failed: %s", err)
+		return nil, err
+	}
+
+	var qos []Qos
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 3 || fields[0] == "" {
+			continue
+		}
+		q := Qos{
+			Name:        fields[0],
+			PreemptMode: strings.ToLower(fields[2]),
+		}
+		if fields[1] != "" {
+			q.Preempt = strings.Split(fields[1], ",")
+		}
+		qos = append(qos, q)
+	}
+	return qos, nil
+}
+
+// ClusterPreemptMode returns the lowercase PreemptMode of the cluster, with `scontrol show config`.
+//
+// The GANG option is dropped, leaving "off" if gang scheduling is the only mode.
+func (s *Slurm) ClusterPreemptMode(ctx context.Context) (string, error) {
+	out, err := s.executor.Run(ctx, s.adminUser, []string{"scontrol", "show", "config"}, nil)
+	if err != nil {
+		log.Printf("ClusterPreemptMode failed: %s", err)
+		return "", err
+	}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "PreemptMode" {
+			continue
+		}
+		var modes []string
+		for _, mode := range strings.Split(strings.ToLower(strings.TrimSpace(value)), ",") {
+			if mode != "gang" && mode != "" {
+				modes = append(modes, mode)
+			}
+		}
+		if len(modes) == 0 {
+			return "off", nil
+		}
+		return strings.Join(modes, ","), nil
+	}
+	return "", errors.New("PreemptMode not found in the configuration")
+}
+
+// CheckPreemption returns an error describing why the jobs of the QoS name cannot be preempted.
+//
+// The QoS using the preemption mode of the cluster are checked against clusterMode,
+// as returned by ClusterPreemptMode. They are reported as unverified if clusterMode is empty.
+func CheckPreemption(qos []Qos, name string, clusterMode string) error {
+	var mining *Qos
+	var preemptors []string
+	for i, q := range qos {
+		if q.Name == name {
+			mining = &qos[i]
+			continue
+		}
+		for _, p := range q.Preempt {
+			if p == name {
+				preemptors = append(preemptors, q.Name)
+			}
+		}
+	}
+
+	var errs []error
+	if mining == nil {
+		return fmt.Errorf("qos %s does not exist", name)
+	}
+	if len(preemptors) == 0 {
+		errs = append(errs, fmt.Errorf("no qos preempts %s", name))
+	}
+	mode := mining.PreemptMode
+	if mode == "cluster" || mode == "" {
+		if clusterMode == "" {
+			errs = append(errs, fmt.Errorf("qos %s uses the preemption mode of the cluster, which could not be verified", name))
+		}
+		mode = clusterMode
+	}
+	switch mode {
+	case "off":
+		errs = append(errs, fmt.Errorf("preemption of qos %s is off", name))
+	case "suspend":
+		errs = append(errs, fmt.Errorf("qos %s is suspended when preempted, keeping the GPUs allocated", name))
+	}
+	return errors.Join(errs...)
+}
+
+// parseKeyValues splits a scontrol one-liner into its Key=Value pairs.
+func parseKeyValues(line string) map[string]string {
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		fields[key] = value
+	}
+	return fields
 }
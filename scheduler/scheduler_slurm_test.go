@@ -5,8 +5,10 @@ package scheduler_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/squarefactory/miner-api/mocks"
 	"github.com/squarefactory/miner-api/scheduler"
@@ -21,6 +23,10 @@ var (
 	pkB64 = "private key"
 )
 
+const nodesOutput = `NodeName=cn1 Arch=x86_64 CoresPerSocket=8 CPUAlloc=4 CPUTot=16 State=MIXED Partitions=gpu,all CfgTRES=cpu=16,mem=64000M,billing=16,gres/gpu=2 AllocTRES=cpu=4,gres/gpu=1
+NodeName=cn2 Arch=x86_64 CoresPerSocket=4 CPUAlloc=0 CPUTot=8 State=IDLE+DRAIN Partitions=all CfgTRES=cpu=8,mem=32000M,billing=8 AllocTRES=
+`
+
 type ServiceTestSuite struct {
 	suite.Suite
 	executor *mocks.Executor
@@ -35,6 +41,15 @@ func (suite *ServiceTestSuite) BeforeTest(suiteName, testName string) {
 	)
 }
 
+func containsArg(argv []string, arg string) bool {
+	for _, a := range argv {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
+
 func (suite *ServiceTestSuite) TestCancel() {
 	// Arrange
 	name := utils.GenerateRandomString(6)
@@ -43,13 +58,14 @@ func (suite *ServiceTestSuite) TestCancel() {
 		User: user,
 	}
 	suite.executor.On(
-		"ExecAs",
+		"Run",
 		mock.Anything,
 		user,
-		mock.MatchedBy(func(cmd string) bool {
-			return strings.Contains(cmd, "scancel") &&
-				strings.Contains(cmd, req.Name)
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "scancel" &&
+				containsArg(argv, "--name="+req.Name)
 		}),
+		nil,
 	).Return("ok", nil)
 	ctx := context.Background()
 
@@ -61,6 +77,124 @@ func (suite *ServiceTestSuite) TestCancel() {
 	suite.executor.AssertExpectations(suite.T())
 }
 
+func (suite *ServiceTestSuite) TestCancelGracefully() {
+	// Arrange
+	req := &scheduler.CancelRequest{
+		Name:        "gpu-auto-mining",
+		User:        user,
+		Signal:      "TERM",
+		GracePeriod: time.Minute,
+	}
+	var calls [][]string
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, nil).
+		Run(func(args mock.Arguments) {
+			calls = append(calls, args.Get(2).([]string))
+		}).
+		Return("", nil)
+	ctx := context.Background()
+
+	// Act
+	err := suite.impl.CancelJob(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(calls, 3)
+	suite.Equal([]string{"scancel", "--signal=TERM", "--full", "--name=gpu-auto-mining", "--me"}, calls[0])
+	suite.Equal("squeue", calls[1][0])
+	suite.Equal([]string{"scancel", "--name=gpu-auto-mining", "--me"}, calls[2])
+}
+
+func (suite *ServiceTestSuite) TestCancelEscalates() {
+	// Arrange
+	req := &scheduler.CancelRequest{
+		Name:        "gpu-auto-mining",
+		User:        user,
+		Signal:      "USR1",
+		GracePeriod: 10 * time.Millisecond,
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(argv []string) bool { return argv[0] == "squeue" }),
+		nil,
+	).Return("123|1|124|RUNNING|cn1|None\n", nil)
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{"scancel", "--signal=USR1", "--full", "--name=gpu-auto-mining", "--me"},
+		nil,
+	).Return("", nil).Once()
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{"scancel", "--signal=KILL", "--full", "--name=gpu-auto-mining", "--me"},
+		nil,
+	).Return("", nil).Once()
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{"scancel", "--name=gpu-auto-mining", "--me"},
+		nil,
+	).Return("", nil).Once()
+	ctx := context.Background()
+
+	// Act
+	err := suite.impl.CancelJob(ctx, req)
+	errSignal := suite.impl.CancelJob(ctx, &scheduler.CancelRequest{Name: "x", User: user, Signal: "SIGTERM"})
+
+	// Assert
+	suite.NoError(err)
+	suite.Error(errSignal)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestCancelArrayTasks() {
+	// Arrange
+	req := &scheduler.CancelRequest{
+		Name:        "lab-gpu-auto-mining",
+		User:        user,
+		JobID:       123,
+		TaskIDs:     []int{3, 4},
+		Signal:      "TERM",
+		GracePeriod: time.Minute,
+	}
+	var calls [][]string
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, nil).
+		Run(func(args mock.Arguments) {
+			calls = append(calls, args.Get(2).([]string))
+		}).
+		Return("123|1|124|RUNNING|cn1|None\n200|3|203|RUNNING|cn2|None\n", nil)
+	ctx := context.Background()
+
+	// Act
+	err := suite.impl.CancelJob(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(calls, 3)
+	suite.Equal([]string{"scancel", "--signal=TERM", "--full", "--me", "123_[3,4]"}, calls[0])
+	suite.Equal("squeue", calls[1][0])
+	suite.Equal([]string{"scancel", "--me", "123_[3,4]"}, calls[2])
+}
+
+func (suite *ServiceTestSuite) TestParseJobID() {
+	// Act
+	id, err := scheduler.ParseJobID("123\n")
+	idCluster, errCluster := scheduler.ParseJobID("124;cluster")
+	_, errInvalid := scheduler.ParseJobID("Submitted batch job 125")
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(123, id)
+	suite.NoError(errCluster)
+	suite.Equal(124, idCluster)
+	suite.Error(errInvalid)
+}
+
 func (suite *ServiceTestSuite) TestSubmit() {
 	// Arrange
 	name := utils.GenerateRandomString(6)
@@ -74,13 +208,16 @@ srun sleep infinity
 `,
 	}
 	suite.executor.On(
-		"ExecAs",
+		"Run",
 		mock.Anything,
 		user,
-		mock.MatchedBy(func(cmd string) bool {
-			return strings.Contains(cmd, "sbatch") &&
-				strings.Contains(cmd, req.Name) &&
-				strings.Contains(cmd, req.Body)
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "sbatch" &&
+				containsArg(argv, "--job-name="+req.Name)
+		}),
+		mock.MatchedBy(func(stdin io.Reader) bool {
+			body, err := io.ReadAll(stdin)
+			return err == nil && string(body) == req.Body
 		}),
 	).Return(fmt.Sprintf("%s\n", expectedJobID), nil)
 	ctx := context.Background()
@@ -94,13 +231,87 @@ srun sleep infinity
 	suite.executor.AssertExpectations(suite.T())
 }
 
+func (suite *ServiceTestSuite) TestSubmitPlacement() {
+	// Arrange
+	req := &scheduler.SubmitRequest{
+		Name:    "gpu-auto-mining",
+		User:    user,
+		Body:    "#!/bin/sh\n",
+		Requeue: true,
+		Placement: scheduler.Placement{
+			Partition:  "gpu",
+			Account:    "mining",
+			Constraint: "a100&nvlink",
+			Exclude:    "cn[1-2]",
+			Time:       "1-00:00:00",
+			Nice:       100,
+		},
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "sbatch" &&
+				containsArg(argv, "--partition=gpu") &&
+				containsArg(argv, "--account=mining") &&
+				containsArg(argv, "--constraint=a100&nvlink") &&
+				containsArg(argv, "--exclude=cn[1-2]") &&
+				containsArg(argv, "--time=1-00:00:00") &&
+				containsArg(argv, "--nice=100") &&
+				containsArg(argv, "--requeue") &&
+				containsArg(argv, "--open-mode=append") &&
+				!containsArg(argv, "--reservation=") &&
+				!containsArg(argv, "--nodelist=")
+		}),
+		mock.Anything,
+	).Return("123\n", nil)
+
+	// Act
+	_, err := suite.impl.Submit(context.Background(), req)
+
+	// Assert
+	suite.NoError(err)
+	suite.NoError(req.Placement.Validate())
+	suite.Error(scheduler.Placement{Partition: "gpu --qos=normal"}.Validate())
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestSubmitNeverUsesShell() {
+	// Arrange
+	name := "$(reboot); `reboot`"
+	req := &scheduler.SubmitRequest{
+		Name: name,
+		User: user,
+		Body: "EOF\nreboot\n",
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "sbatch" &&
+				containsArg(argv, "--job-name="+name)
+		}),
+		mock.Anything,
+	).Return("123\n", nil)
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.Submit(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+}
+
 func (suite *ServiceTestSuite) TestHealthCheck() {
 	// Arrange
 	suite.executor.On(
-		"ExecAs",
+		"Run",
 		mock.Anything,
 		admin,
-		"squeue",
+		[]string{"squeue"},
+		nil,
 	).Return("ok", nil)
 	ctx := context.Background()
 
@@ -121,13 +332,14 @@ func (suite *ServiceTestSuite) TestFindRunningJobByName() {
 		User: user,
 	}
 	suite.executor.On(
-		"ExecAs",
+		"Run",
 		mock.Anything,
 		user,
-		mock.MatchedBy(func(cmd string) bool {
-			return strings.Contains(cmd, "squeue") &&
-				strings.Contains(cmd, name)
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "squeue" &&
+				containsArg(argv, "--name="+name)
 		}),
+		nil,
 	).Return(fmt.Sprintf("%d\n", jobID), nil)
 	ctx := context.Background()
 
@@ -140,6 +352,327 @@ func (suite *ServiceTestSuite) TestFindRunningJobByName() {
 	suite.executor.AssertExpectations(suite.T())
 }
 
+func (suite *ServiceTestSuite) TestListJobs() {
+	// Arrange
+	req := &scheduler.FindRunningJobByNameRequest{
+		Name: "lab-gpu-auto-mining",
+		User: user,
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{"squeue", "--name=lab-gpu-auto-mining", "--me", "--noheader", "--format=%F|%k"},
+		nil,
+	).Return("123|algo=kawpow instance=lab\n123|algo=kawpow instance=lab\n130|(null)\n", nil)
+	ctx := context.Background()
+
+	// Act
+	jobs, err := suite.impl.ListJobs(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.Job{
+		{ID: 123, Comment: "algo=kawpow instance=lab"},
+		{ID: 130, Comment: "(null)"},
+	}, jobs)
+}
+
+func (suite *ServiceTestSuite) TestFindRunningJobByNameNotFound() {
+	// Arrange
+	req := &scheduler.FindRunningJobByNameRequest{
+		Name: utils.GenerateRandomString(6),
+		User: user,
+	}
+	suite.executor.On("Run", mock.Anything, user, mock.Anything, nil).Return("\n", nil)
+	ctx := context.Background()
+
+	// Act
+	_, err := suite.impl.FindRunningJobByName(ctx, req)
+
+	// Assert
+	suite.Error(err)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestListTasks() {
+	// Arrange
+	name := utils.GenerateRandomString(6)
+	req := &scheduler.FindRunningJobByNameRequest{
+		Name: name,
+		User: user,
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "squeue" &&
+				containsArg(argv, "--name="+name) &&
+				containsArg(argv, "--me") &&
+				containsArg(argv, "--array")
+		}),
+		nil,
+	).Return("123|1|124|RUNNING|cn1|None\n123|2|125|RUNNING|cn2|None\n123|5|127|PENDING||BeginTime\n123|3-4|123|PENDING||Resources\n", nil)
+	ctx := context.Background()
+
+	// Act
+	tasks, err := suite.impl.ListTasks(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal([]scheduler.Task{
+		{JobID: 123, ID: 124, TaskID: 1, State: scheduler.TaskRunning, Node: "cn1"},
+		{JobID: 123, ID: 125, TaskID: 2, State: scheduler.TaskRunning, Node: "cn2"},
+		{JobID: 123, ID: 127, TaskID: 5, State: scheduler.TaskPending, Reason: "BeginTime"},
+		{JobID: 123, ID: 123, TaskID: -1, State: scheduler.TaskPending, Reason: "Resources"},
+	}, tasks)
+	suite.False(tasks[0].Preempted())
+	suite.True(tasks[2].Preempted())
+	suite.False(tasks[3].Preempted())
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestTailLog() {
+	// Arrange
+	req := &scheduler.TailLogRequest{
+		User:     user,
+		ID:       124,
+		TaskID:   1,
+		MaxBytes: 1024,
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{"tail", "--bytes=1024", "/tmp/miner-124_1.log"},
+		nil,
+	).Return("Total Speed: 24.35 MH/s\n", nil)
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.TailLog(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("Total Speed: 24.35 MH/s\n", out)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestReadLog() {
+	// Arrange
+	req := &scheduler.ReadLogRequest{
+		User:   user,
+		ID:     124,
+		TaskID: 1,
+		Offset: 10,
+		Length: 20,
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		[]string{
+			"dd",
+			"if=/tmp/miner-124_1.log",
+			"iflag=skip_bytes,count_bytes",
+			"skip=10",
+			"count=20",
+			"status=none",
+		},
+		nil,
+	).Return("Speed: 24.35 MH/s\n", nil)
+	ctx := context.Background()
+
+	// Act
+	out, err := suite.impl.ReadLog(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("Speed: 24.35 MH/s\n", out)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindJobsEnergy() {
+	// Arrange
+	req := &scheduler.FindJobsEnergyRequest{
+		User:  user,
+		Names: []string{"gpu-auto-mining", "cpu-auto-mining"},
+	}
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		user,
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "sacct" &&
+				containsArg(argv, "--name=gpu-auto-mining,cpu-auto-mining")
+		}),
+		nil,
+	).Return(`124|gpu-auto-mining|2023-06-01T10:00:00|2023-06-01T11:00:00|3600000|algo=kawpow
+125|gpu-auto-mining|2023-06-01T11:00:00|Unknown|720000|algo=octopus
+126|cpu-auto-mining|None|Unknown||algo=randomx
+`, nil)
+	ctx := context.Background()
+
+	// Act
+	jobs, err := suite.impl.FindJobsEnergy(ctx, req)
+
+	// Assert
+	suite.NoError(err)
+	suite.Len(jobs, 2)
+	suite.Equal("124", jobs[0].JobID)
+	suite.Equal(3600000.0, jobs[0].Energy)
+	suite.Equal("algo=kawpow", jobs[0].Comment)
+	suite.Equal(time.Hour, jobs[0].End.Sub(jobs[0].Start))
+	suite.True(jobs[1].End.IsZero())
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindMaxResources() {
+	// Arrange
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		admin,
+		[]string{"scontrol", "show", "nodes", "--oneliner"},
+		nil,
+	).Return(nodesOutput, nil)
+	ctx := context.Background()
+
+	// Act
+	maxGPU, errGPU := suite.impl.FindMaxGPU(ctx)
+	maxCPU, errCPU := suite.impl.FindMaxCPU(ctx)
+	maxNode, errNode := suite.impl.FindMaxNode(ctx)
+
+	// Assert
+	suite.NoError(errGPU)
+	suite.NoError(errCPU)
+	suite.NoError(errNode)
+	suite.Equal(2, maxGPU)
+	suite.Equal(24, maxCPU)
+	suite.Equal(2, maxNode)
+	suite.executor.AssertExpectations(suite.T())
+}
+
+func (suite *ServiceTestSuite) TestFindCapacity() {
+	// Arrange
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		admin,
+		[]string{"scontrol", "show", "nodes", "--oneliner"},
+		nil,
+	).Return(nodesOutput, nil)
+	ctx := context.Background()
+
+	// Act
+	all, errAll := suite.impl.FindCapacity(ctx, scheduler.Placement{})
+	gpu, errGPU := suite.impl.FindCapacity(ctx, scheduler.Placement{Partition: "gpu"})
+	none, errNone := suite.impl.FindCapacity(ctx, scheduler.Placement{Partition: "debug"})
+
+	// Assert
+	suite.NoError(errAll)
+	suite.NoError(errGPU)
+	suite.NoError(errNone)
+	// cn2 is drained.
+	suite.Equal(scheduler.Capacity{Nodes: 2, CPUs: 24, GPUs: 2, IdleCPUs: 12, IdleGPUs: 1}, all)
+	suite.Equal(scheduler.Capacity{Nodes: 1, CPUs: 16, GPUs: 2, IdleCPUs: 12, IdleGPUs: 1}, gpu)
+	suite.Equal(scheduler.Capacity{}, none)
+}
+
+func (suite *ServiceTestSuite) TestFindCapacityPlacement() {
+	// Arrange
+	var nodes strings.Builder
+	for i := 1; i <= 10; i++ {
+		features := "a100,nvlink"
+		if i > 5 {
+			features = "v100"
+		}
+		fmt.Fprintf(&nodes, "NodeName=gpu%02d CPUTot=16 State=IDLE Partitions=gpu AvailableFeatures=%s CfgTRES=cpu=16,gres/gpu=2 AllocTRES=\n", i, features)
+	}
+	nodes.WriteString("NodeName=gpu11 CPUTot=16 State=RESERVED Partitions=gpu AvailableFeatures=a100 CfgTRES=cpu=16,gres/gpu=2 AllocTRES=\n")
+	run := func(argv []string, out string) {
+		suite.executor.On("Run", mock.Anything, admin, argv, nil).Return(out, nil)
+	}
+	run([]string{"scontrol", "show", "nodes", "--oneliner"}, nodes.String())
+	run([]string{"scontrol", "show", "hostnames", "gpu[01-02]"}, "gpu01\ngpu02\n")
+	run([]string{"scontrol", "show", "hostnames", "gpu[01-09]"}, "gpu01\ngpu02\ngpu03\ngpu04\ngpu05\ngpu06\ngpu07\ngpu08\ngpu09\n")
+	run([]string{"scontrol", "show", "reservation", "mining", "--oneliner"}, "ReservationName=mining Nodes=gpu[10-11] NodeCnt=2 State=ACTIVE\n")
+	run([]string{"scontrol", "show", "hostnames", "gpu[10-11]"}, "gpu10\ngpu11\n")
+	ctx := context.Background()
+
+	// Act
+	nodeList, errNodeList := suite.impl.FindCapacity(ctx, scheduler.Placement{Partition: "gpu", NodeList: "gpu[01-02]"})
+	excluded, errExcluded := suite.impl.FindCapacity(ctx, scheduler.Placement{Exclude: "gpu[01-09]"})
+	constrained, errConstrained := suite.impl.FindCapacity(ctx, scheduler.Placement{Constraint: "[a100&nvlink|v100*2]&(nvlink)"})
+	reserved, errReserved := suite.impl.FindCapacity(ctx, scheduler.Placement{Reservation: "mining"})
+	_, errConstraint := suite.impl.FindCapacity(ctx, scheduler.Placement{Constraint: "a100&(nvlink"})
+
+	// Assert
+	suite.NoError(errNodeList)
+	suite.Equal(scheduler.Capacity{Nodes: 2, CPUs: 32, GPUs: 4, IdleCPUs: 32, IdleGPUs: 4}, nodeList)
+	suite.NoError(errExcluded)
+	// gpu11 is reserved.
+	suite.Equal(scheduler.Capacity{Nodes: 2, CPUs: 32, GPUs: 4, IdleCPUs: 16, IdleGPUs: 2}, excluded)
+	suite.NoError(errConstrained)
+	suite.Equal(5, constrained.Nodes)
+	suite.NoError(errReserved)
+	suite.Equal(scheduler.Capacity{Nodes: 2, CPUs: 32, GPUs: 4, IdleCPUs: 32, IdleGPUs: 4}, reserved)
+	suite.Error(errConstraint)
+}
+
+func (suite *ServiceTestSuite) TestCheckPreemption() {
+	// Arrange
+	suite.executor.On(
+		"Run",
+		mock.Anything,
+		admin,
+		mock.MatchedBy(func(argv []string) bool {
+			return argv[0] == "sacctmgr" && containsArg(argv, "qos")
+		}),
+		nil,
+	).Return("normal|mining|cluster\nmining||REQUEUE\nhigh|normal,mining|cluster\n", nil)
+	ctx := context.Background()
+
+	// Act
+	qos, err := suite.impl.ListQos(ctx)
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal(scheduler.Qos{Name: "mining", PreemptMode: "requeue"}, qos[1])
+	suite.Equal([]string{"normal", "mining"}, qos[2].Preempt)
+	suite.NoError(scheduler.CheckPreemption(qos, scheduler.QosName, ""))
+	suite.ErrorContains(scheduler.CheckPreemption(qos[1:2], scheduler.QosName, ""), "no qos preempts mining")
+	suite.ErrorContains(scheduler.CheckPreemption(qos[:1], scheduler.QosName, ""), "does not exist")
+	suite.ErrorContains(scheduler.CheckPreemption([]scheduler.Qos{
+		{Name: "normal", Preempt: []string{"mining"}},
+		{Name: "mining", PreemptMode: "off"},
+	}, scheduler.QosName, ""), "off")
+}
+
+func (suite *ServiceTestSuite) TestCheckClusterPreemption() {
+	// Arrange
+	suite.executor.On("Run", mock.Anything, admin, []string{"scontrol", "show", "config"}, nil).
+		Return("Configuration data as of 2023-05-01T10:00:00\n"+
+			"PreemptExemptTime       = 00:00:00\n"+
+			"PreemptMode             = SUSPEND,GANG\n"+
+			"PreemptType             = preempt/qos\n", nil)
+	qos := []scheduler.Qos{
+		{Name: "normal", Preempt: []string{"mining"}},
+		{Name: "mining", PreemptMode: "cluster"},
+	}
+
+	// Act
+	mode, err := suite.impl.ClusterPreemptMode(context.Background())
+
+	// Assert
+	suite.NoError(err)
+	suite.Equal("suspend", mode)
+	suite.ErrorContains(scheduler.CheckPreemption(qos, scheduler.QosName, mode), "suspended")
+	suite.NoError(scheduler.CheckPreemption(qos, scheduler.QosName, "requeue"))
+	suite.ErrorContains(scheduler.CheckPreemption(qos, scheduler.QosName, ""), "could not be verified")
+}
+
 func TestServiceTestSuite(t *testing.T) {
 	suite.Run(t, &ServiceTestSuite{})
 }
@@ -1,9 +1,14 @@
 package scheduler
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type Executor interface {
-	ExecAs(ctx context.Context, user string, cmd string) (string, error)
+	// Run executes argv as user without any shell interpretation. stdin may be nil.
+	Run(ctx context.Context, user string, argv []string, stdin io.Reader) (string, error)
 }
 
 type CancelRequest struct {
@@ -11,6 +16,14 @@ type CancelRequest struct {
 	Name string
 	// User is a UNIX User used for impersonation.
 	User string
+	// JobID restricts the cancellation to a job, instead of every job named Name.
+	JobID int
+	// TaskIDs restricts the cancellation to array tasks of JobID, instead of the whole job.
+	TaskIDs []int
+	// Signal, like "TERM", is sent first to the batch script and every step of the job, if not empty.
+	Signal string
+	// GracePeriod is how long the tasks have to stop after Signal, before they are killed.
+	GracePeriod time.Duration
 }
 
 type SubmitRequest struct {
@@ -20,6 +33,47 @@ type SubmitRequest struct {
 	User string
 	// Body of the job
 	Body string
+	// Comment is stored in the accounting database, and read back by FindJobsEnergy.
+	Comment string
+	// Placement restricts the nodes running the job.
+	Placement Placement
+	// Requeue lets Slurm requeue the tasks of the job when they are preempted. Their logs are appended to.
+	Requeue bool
+}
+
+// Placement restricts the nodes running a job, and the account billed for it.
+type Placement struct {
+	Partition   string `json:"partition,omitempty"`
+	Account     string `json:"account,omitempty"`
+	Reservation string `json:"reservation,omitempty"`
+	// Constraint is a node feature expression, like "a100&nvlink".
+	Constraint string `json:"constraint,omitempty"`
+	// Exclude and NodeList are host lists, like "cn[1-4],cn7".
+	Exclude  string `json:"exclude,omitempty"`
+	NodeList string `json:"nodelist,omitempty"`
+	// Time is the time limit of the job, like "1-00:00:00".
+	Time string `json:"time,omitempty"`
+	// Nice lowers the priority of the job when positive. Negative values require a Slurm operator.
+	Nice int `json:"nice,omitempty"`
+}
+
+// Capacity sums the resources of the nodes of a partition.
+type Capacity struct {
+	Nodes int
+	CPUs  int
+	GPUs  int
+	// IdleCPUs and IdleGPUs are not allocated, on the nodes which are neither down nor drained.
+	IdleCPUs int
+	IdleGPUs int
+}
+
+// Qos is a quality of service of the accounting database.
+type Qos struct {
+	Name string
+	// Preempt are the QoS which jobs of this QoS can preempt.
+	Preempt []string
+	// PreemptMode is the lowercase mode of preemption of the jobs of this QoS, like "requeue" or "cluster".
+	PreemptMode string
 }
 
 type FindRunningJobByNameRequest struct {
@@ -27,4 +81,95 @@ type FindRunningJobByNameRequest struct {
 	Name string
 	// User is a UNIX User used for impersonation. This user should be SLURM admin.
 	User string
+	// JobID restricts the listed tasks to a job, if set.
+	JobID int
+}
+
+// Job is a job array listed by squeue.
+type Job struct {
+	ID int
+	// Comment holds the tags of the job, formatted by FormatComment.
+	Comment string
+}
+
+// Task is an array task of a job.
+type Task struct {
+	// JobID is the ID of the job array.
+	JobID int `json:"jobId"`
+	// ID is the job ID of the array task itself.
+	ID int `json:"id"`
+	// TaskID is the array task ID, or -1 if the array is pending and not expanded.
+	TaskID int `json:"taskId"`
+	// State is the Slurm state of the task, like RUNNING or PENDING.
+	State string `json:"state"`
+	// Node is the node running the task. It is empty if the task is not running.
+	Node string `json:"node"`
+	// Reason why the task is pending, like "Resources" or "BeginTime".
+	Reason string `json:"reason,omitempty"`
+}
+
+const (
+	TaskRunning = "RUNNING"
+	TaskPending = "PENDING"
+)
+
+// preemptedStates are the states of the tasks being preempted or requeued.
+var preemptedStates = map[string]bool{
+	"PREEMPTED":    true,
+	"REQUEUED":     true,
+	"REQUEUE_FED":  true,
+	"REQUEUE_HOLD": true,
+}
+
+// Preempted reports whether the task was preempted and waits to be requeued.
+//
+// The pending arrays are listed as a single record: a pending task listed on its own was started then requeued.
+func (t Task) Preempted() bool {
+	return preemptedStates[t.State] || (t.State == TaskPending && t.TaskID >= 0)
+}
+
+type TailLogRequest struct {
+	// User is a UNIX User used for impersonation. This should be the owner of the job.
+	User string
+	// ID is the job ID of the array task.
+	ID int
+	// TaskID is the array task ID.
+	TaskID int
+	// MaxBytes is the number of bytes read from the end of the log.
+	MaxBytes int
+}
+
+type ReadLogRequest struct {
+	// User is a UNIX User used for impersonation. This should be the owner of the job.
+	User string
+	// ID is the job ID of the array task.
+	ID int
+	// TaskID is the array task ID.
+	TaskID int
+	// Offset is the position of the first byte to read.
+	Offset int64
+	// Length is the maximum number of bytes to read.
+	Length int64
+}
+
+type FindJobsEnergyRequest struct {
+	// User is a UNIX User used for impersonation. This should be the owner of the jobs.
+	User string
+	// Names of the jobs.
+	Names []string
+	// Since and Until bound the jobs running in the interval.
+	Since time.Time
+	Until time.Time
+}
+
+// JobEnergy is the energy consumed by a job, as recorded by the accounting.
+type JobEnergy struct {
+	JobID   string    `json:"jobId"`
+	Name    string    `json:"name"`
+	Comment string    `json:"comment,omitempty"`
+	Start   time.Time `json:"start"`
+	// End is zero if the job is still running.
+	End time.Time `json:"end,omitempty"`
+	// Energy is the consumed energy in joules.
+	Energy float64 `json:"energy"`
 }
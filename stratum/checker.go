@@ -0,0 +1,131 @@
+package stratum
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/squarefactory/miner-api/metrics"
+)
+
+// DefaultTimeout bounds each probe.
+const DefaultTimeout = 10 * time.Second
+
+// Target is a pool address to probe.
+type Target struct {
+	Pool    string
+	Address string
+	TLS     bool
+	// Credentials are authorized if not nil.
+	Credentials *Credentials
+}
+
+// Status is the result of the last probe of an address.
+type Status struct {
+	Pool      string        `json:"pool"`
+	Address   string        `json:"address"`
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"latency"`
+	Error     string        `json:"error,omitempty"`
+	CheckedAt time.Time     `json:"checkedAt"`
+}
+
+// Checker probes the pools and remembers their health.
+type Checker struct {
+	// Timeout defaults to DefaultTimeout.
+	Timeout time.Duration
+	// TLSConfig is used for the targets with TLS. The server name is set from the address.
+	TLSConfig *tls.Config
+
+	mu       sync.Mutex
+	statuses map[string]Status
+}
+
+// Check probes the targets concurrently.
+func (c *Checker) Check(ctx context.Context, targets []Target) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t Target) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			var tlsConfig *tls.Config
+			if t.TLS {
+				tlsConfig = &tls.Config{}
+				if c.TLSConfig != nil {
+					tlsConfig = c.TLSConfig.Clone()
+				}
+				tlsConfig.ServerName, _, _ = net.SplitHostPort(t.Address)
+			}
+			latency, err := Probe(ctx, t.Address, tlsConfig, t.Credentials)
+			status := Status{
+				Pool:      t.Pool,
+				Address:   t.Address,
+				Healthy:   err == nil,
+				Latency:   latency,
+				CheckedAt: time.Now(),
+			}
+			if err != nil {
+				status.Error = err.Error()
+			}
+			metrics.PoolUp.WithLabelValues(t.Pool, t.Address).Set(metrics.BoolToFloat(status.Healthy))
+			if status.Healthy {
+				metrics.PoolLatency.WithLabelValues(t.Pool, t.Address).Set(latency.Seconds())
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.statuses == nil {
+				c.statuses = make(map[string]Status)
+			}
+			c.statuses[t.Address] = status
+		}(t)
+	}
+	wg.Wait()
+}
+
+// Healthy reports whether the last probe of address succeeded. Addresses never probed are healthy.
+func (c *Checker) Healthy(address string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status, ok := c.statuses[address]
+	return !ok || status.Healthy
+}
+
+// Latency returns the latency of the last successful probe of address.
+func (c *Checker) Latency(address string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status, ok := c.statuses[address]
+	if !ok || !status.Healthy {
+		return 0, false
+	}
+	return status.Latency, true
+}
+
+// Statuses returns the results of the last probes, sorted by pool and address.
+func (c *Checker) Statuses() []Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statuses := make([]Status, 0, len(c.statuses))
+	for _, s := range c.statuses {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Pool != statuses[j].Pool {
+			return statuses[i].Pool < statuses[j].Pool
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
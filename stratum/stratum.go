@@ -0,0 +1,97 @@
+// Package stratum probes the mining pools with the handshake of the stratum protocol.
+package stratum
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Agent identifies miner-api in mining.subscribe.
+const Agent = "miner-api/1.0"
+
+// Credentials authorize a worker on a pool.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+type request struct {
+	ID     int           `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type response struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// Probe connects to a pool, over TLS if tlsConfig is not nil, subscribes and, if credentials are given,
+// authorizes the worker. It returns the latency of the subscription, from the connection to its response.
+func Probe(ctx context.Context, address string, tlsConfig *tls.Config, creds *Credentials) (time.Duration, error) {
+	start := time.Now()
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	if err := call(enc, scanner, request{ID: 1, Method: "mining.subscribe", Params: []interface{}{Agent}}); err != nil {
+		return 0, fmt.Errorf("mining.subscribe: %w", err)
+	}
+	latency := time.Since(start)
+
+	if creds != nil {
+		if err := call(enc, scanner, request{ID: 2, Method: "mining.authorize", Params: []interface{}{creds.User, creds.Password}}); err != nil {
+			return 0, fmt.Errorf("mining.authorize: %w", err)
+		}
+	}
+	return latency, nil
+}
+
+// call sends a request and waits for its response, skipping the notifications of the pool.
+func call(enc *json.Encoder, scanner *bufio.Scanner, req request) error {
+	if err := enc.Encode(req); err != nil {
+		return err
+	}
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+		if resp.ID == nil || *resp.ID != req.ID {
+			continue
+		}
+		if len(resp.Error) > 0 && string(resp.Error) != "null" {
+			return fmt.Errorf("pool error: %s", resp.Error)
+		}
+		if len(resp.Result) == 0 || string(resp.Result) == "null" || string(resp.Result) == "false" {
+			return errors.New("rejected by the pool")
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed by the pool")
+}
@@ -0,0 +1,147 @@
+//go:build unit
+
+package stratum_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/squarefactory/miner-api/stratum"
+	"github.com/stretchr/testify/suite"
+)
+
+// fakePool is a stratum server accepting the workers of the wallet "wallet".
+type fakePool struct {
+	listener net.Listener
+}
+
+func newFakePool(tlsConfig *tls.Config) (*fakePool, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	p := &fakePool{listener: l}
+	go p.serve()
+	return p, nil
+}
+
+func (p *fakePool) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			enc := json.NewEncoder(conn)
+			for scanner.Scan() {
+				var req struct {
+					ID     int           `json:"id"`
+					Method string        `json:"method"`
+					Params []interface{} `json:"params"`
+				}
+				if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+					return
+				}
+				switch req.Method {
+				case "mining.subscribe":
+					_ = enc.Encode(map[string]interface{}{
+						"id":     req.ID,
+						"result": []interface{}{[]interface{}{"mining.notify", "ae6812eb4cd7735a302a8a9dd95cf71f"}, "08000002", 4},
+						"error":  nil,
+					})
+					// Notifications are sent before the next responses.
+					_ = enc.Encode(map[string]interface{}{"id": nil, "method": "mining.set_difficulty", "params": []interface{}{1}})
+				case "mining.authorize":
+					_ = enc.Encode(map[string]interface{}{
+						"id":     req.ID,
+						"result": req.Params[0] == "wallet.worker",
+						"error":  nil,
+					})
+				}
+			}
+		}()
+	}
+}
+
+func (p *fakePool) Close() {
+	_ = p.listener.Close()
+}
+
+type StratumTestSuite struct {
+	suite.Suite
+}
+
+func (suite *StratumTestSuite) TestProbe() {
+	// Arrange
+	pool, err := newFakePool(nil)
+	suite.Require().NoError(err)
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Act
+	latency, errSubscribe := stratum.Probe(ctx, pool.listener.Addr().String(), nil, nil)
+	_, errAuthorize := stratum.Probe(ctx, pool.listener.Addr().String(), nil, &stratum.Credentials{User: "wallet.worker", Password: "x"})
+	_, errRejected := stratum.Probe(ctx, pool.listener.Addr().String(), nil, &stratum.Credentials{User: "other.worker", Password: "x"})
+
+	// Assert
+	suite.NoError(errSubscribe)
+	suite.Positive(latency)
+	suite.NoError(errAuthorize)
+	suite.ErrorContains(errRejected, "mining.authorize")
+}
+
+func (suite *StratumTestSuite) TestChecker() {
+	// Arrange
+	// Reuse the certificate of httptest for the TLS pool.
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	pool, err := newFakePool(&tls.Config{Certificates: server.TLS.Certificates})
+	suite.Require().NoError(err)
+	defer pool.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	down := closed.Addr().String()
+	_ = closed.Close()
+
+	checker := &stratum.Checker{Timeout: 5 * time.Second, TLSConfig: &tls.Config{RootCAs: roots}}
+	// The certificate of httptest is valid for 127.0.0.1 and example.com.
+	up := pool.listener.Addr().String()
+
+	// Act
+	checker.Check(context.Background(), []stratum.Target{
+		{Pool: "up", Address: up, TLS: true, Credentials: &stratum.Credentials{User: "wallet.worker"}},
+		{Pool: "down", Address: down},
+	})
+
+	// Assert
+	suite.True(checker.Healthy(up))
+	suite.False(checker.Healthy(down))
+	suite.True(checker.Healthy("unknown:3333"))
+	latency, ok := checker.Latency(up)
+	suite.True(ok)
+	suite.Positive(latency)
+	statuses := checker.Statuses()
+	suite.Len(statuses, 2)
+	suite.Equal("down", statuses[0].Pool)
+	suite.NotEmpty(statuses[0].Error)
+}
+
+func TestStratumTestSuite(t *testing.T) {
+	suite.Run(t, new(StratumTestSuite))
+}
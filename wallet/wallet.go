@@ -0,0 +1,206 @@
+// Package wallet holds the addresses receiving the mining rewards, by pool and coin.
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/squarefactory/miner-api/fileutil"
+)
+
+// ErrNotFound is returned when getting or deleting an unknown wallet.
+var ErrNotFound = errors.New("wallet not found")
+
+// Wallet is a payout address of the coin mined on a pool.
+type Wallet struct {
+	Name string `json:"name"`
+	// Coin is the tag of the coin paid to the address, like "RVN", or "BTC" for NiceHash. Any coin if empty.
+	Coin string `json:"coin,omitempty"`
+	// Pool is the name of the pool paying to the address. Any pool if empty.
+	Pool    string `json:"pool,omitempty"`
+	Address string `json:"address"`
+	// Worker overrides the template of the names of the workers mining to the address,
+	// like "{{ .Cluster }}-{{ .Node }}-{{ .TaskID }}".
+	Worker string `json:"worker,omitempty"`
+}
+
+// Validate checks the wallet.
+func (w *Wallet) Validate() error {
+	if w.Name == "" {
+		return errors.New("wallet name is required")
+	}
+	if w.Address == "" {
+		return fmt.Errorf("wallet %s: address is required", w.Name)
+	}
+	if w.Coin == "" && w.Pool == "" {
+		return fmt.Errorf("wallet %s: coin or pool is required", w.Name)
+	}
+	if err := ValidateAddress(w.Address); err != nil {
+		return fmt.Errorf("wallet %s: %w", w.Name, err)
+	}
+	if err := ValidateWorker(w.Worker); err != nil {
+		return fmt.Errorf("wallet %s: %w", w.Name, err)
+	}
+	return nil
+}
+
+// addressPattern matches the payout addresses, which are given unquoted to the miners inside a "bash -c" command.
+var addressPattern = regexp.MustCompile(`^[A-Za-z0-9._:@+-]+$`)
+
+// ValidateAddress checks that a payout address holds no shell syntax.
+func ValidateAddress(address string) error {
+	if !addressPattern.MatchString(address) {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	return nil
+}
+
+// workerForbidden are the characters which could quote, expand or chain commands in the worker names,
+// evaluated inside double quotes by the jobs.
+const workerForbidden = "'\"`$;|&<>()\\\n"
+
+// ValidateWorker checks that a worker name, or its template, holds no shell syntax.
+func ValidateWorker(worker string) error {
+	if strings.ContainsAny(worker, workerForbidden) {
+		return fmt.Errorf("invalid worker %q", worker)
+	}
+	return nil
+}
+
+// Book holds the wallets, persisted in a JSON file if a path is given.
+type Book struct {
+	path string
+
+	mu      sync.Mutex
+	wallets map[string]Wallet
+}
+
+// Open loads the wallets from path.
+func Open(path string) (*Book, error) {
+	b := &Book{
+		path:    path,
+		wallets: make(map[string]Wallet),
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var wallets []Wallet
+	if err := json.Unmarshal(data, &wallets); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, w := range wallets {
+		b.wallets[w.Name] = w
+	}
+	return b, nil
+}
+
+// List returns the wallets sorted by name.
+func (b *Book) List() []Wallet {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sorted(b.wallets)
+}
+
+func sorted(wallets map[string]Wallet) []Wallet {
+	list := make([]Wallet, 0, len(wallets))
+	for _, w := range wallets {
+		list = append(list, w)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Get returns the wallet named name.
+func (b *Book) Get(name string) (Wallet, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, ok := b.wallets[name]
+	if !ok {
+		return Wallet{}, ErrNotFound
+	}
+	return w, nil
+}
+
+// Put adds a wallet, or replaces the wallet with the same name.
+func (b *Book) Put(w Wallet) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wallets, err := fileutil.CopyOnWrite(b.wallets, func(wallets map[string]Wallet) {
+		wallets[w.Name] = w
+	}, b.save)
+	if err != nil {
+		return err
+	}
+	b.wallets = wallets
+	return nil
+}
+
+// Delete removes the wallet named name.
+func (b *Book) Delete(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.wallets[name]; !ok {
+		return ErrNotFound
+	}
+	wallets, err := fileutil.CopyOnWrite(b.wallets, func(wallets map[string]Wallet) {
+		delete(wallets, name)
+	}, b.save)
+	if err != nil {
+		return err
+	}
+	b.wallets = wallets
+	return nil
+}
+
+// Match returns the wallet receiving coin on pool: a wallet of both the pool and the coin,
+// else a wallet of the pool for any coin, else a wallet of the coin on any pool.
+//
+// Wallets of the same precedence are matched by name.
+func (b *Book) Match(pool, coin string) (Wallet, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, matches := range []func(w Wallet) bool{
+		func(w Wallet) bool { return w.Pool == pool && w.Coin == coin },
+		func(w Wallet) bool { return w.Pool == pool && w.Coin == "" },
+		func(w Wallet) bool { return w.Pool == "" && w.Coin == coin },
+	} {
+		for _, w := range sorted(b.wallets) {
+			if matches(w) {
+				return w, true
+			}
+		}
+	}
+	return Wallet{}, false
+}
+
+// save writes the wallets atomically.
+func (b *Book) save(wallets map[string]Wallet) error {
+	if b.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(sorted(wallets), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(b.path, data)
+}
